@@ -0,0 +1,230 @@
+// Package arbitrage compares the books of the same base currency
+// across every exchange, whatever their quote currency, and reports
+// the executable spreads
+package arbitrage
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/metrics"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/shopspring/decimal"
+)
+
+// Opportunity is a spread that can be taken by buying Symbol on BuyVenue
+// and selling the same quantity of SellSymbol on SellVenue. The prices
+// and the profit are in the right currency of Symbol.
+type Opportunity struct {
+	Symbol     orderbook.Symbol
+	SellSymbol orderbook.Symbol // Same left currency as Symbol, the right one may differ
+	BuyVenue   orderbook.ExchangeKey
+	SellVenue  orderbook.ExchangeKey
+	Qty        decimal.Decimal // Executable quantity in the left currency
+	BuyPrice   decimal.Decimal // Volume weighted price paid on BuyVenue
+	SellPrice  decimal.Decimal // Volume weighted price received on SellVenue, converted
+	Profit     decimal.Decimal // Expected profit in the right currency, net of fees
+	Time       time.Time
+}
+
+// Detector listens to the book updates of every exchange and emits
+// an Opportunity whenever a currency can be bought on one exchange
+// and sold on another for a profit above MinProfit
+type Detector struct {
+	MinProfit decimal.Decimal
+	// Now is the clock opportunities are timed with, time.Now by default
+	Now func() time.Time
+	// Convert converts the prices between the quote currencies,
+	// orderbook.ConvertWithBooks by default
+	Convert orderbook.QuoteConverter
+
+	subscribers map[chan Opportunity]struct{}
+	mu          sync.RWMutex
+	quit        chan struct{}
+	done        chan struct{}
+}
+
+// NewDetector creates a detector emitting opportunities above minProfit
+func NewDetector(minProfit decimal.Decimal) *Detector {
+	return &Detector{
+		MinProfit:   minProfit,
+		Now:         time.Now,
+		Convert:     orderbook.ConvertWithBooks,
+		subscribers: make(map[chan Opportunity]struct{}),
+		quit:        make(chan struct{}),
+	}
+}
+
+// Subscribe returns a channel receiving every detected opportunity.
+// Opportunities are dropped for subscribers that do not keep up.
+func (d *Detector) Subscribe() <-chan Opportunity {
+	ch := make(chan Opportunity, 64)
+	d.mu.Lock()
+	d.subscribers[ch] = struct{}{}
+	d.mu.Unlock()
+	return ch
+}
+
+// Start evaluates a currency every time one of its books is updated
+func (d *Detector) Start() {
+	updates := orderbook.SubscribeUpdates()
+	d.done = make(chan struct{})
+	go func() {
+		defer close(d.done)
+		defer orderbook.UnsubscribeUpdates(updates)
+		profitable := make(map[route]bool)
+		for {
+			select {
+			case update := <-updates:
+				opportunities := d.Evaluate(update.Symbol)
+				observe(profitable, orderbook.GetLeftCurrency(string(update.Symbol)), opportunities)
+				for _, o := range opportunities {
+					d.emit(o)
+				}
+			case <-d.quit:
+				return
+			}
+		}
+	}()
+}
+
+// route is where an opportunity buys and sells a currency
+type route struct {
+	symbol, sellSymbol orderbook.Symbol
+	buy, sell          orderbook.ExchangeKey
+}
+
+func (r route) labels() []string {
+	return []string{string(r.symbol), string(r.buy), string(r.sell), string(r.sellSymbol)}
+}
+
+// observe reports the opportunities of an evaluation of base to the
+// metrics, zeroing the profit of its routes not profitable anymore
+func observe(profitable map[route]bool, base string, opportunities []Opportunity) {
+	seen := make(map[route]bool)
+	for _, o := range opportunities {
+		r := route{o.Symbol, o.SellSymbol, o.BuyVenue, o.SellVenue}
+		seen[r], profitable[r] = true, true
+		profit, _ := o.Profit.Float64()
+		metrics.Opportunities.WithLabelValues(r.labels()...).Inc()
+		metrics.OpportunityProfit.WithLabelValues(r.labels()...).Set(profit)
+	}
+	for r := range profitable {
+		if orderbook.GetLeftCurrency(string(r.symbol)) == base && !seen[r] {
+			delete(profitable, r)
+			metrics.OpportunityProfit.WithLabelValues(r.labels()...).Set(0)
+		}
+	}
+}
+
+// Stop stops evaluating the books, and closes the
+// channels of the subscribers once the last one is emitted
+func (d *Detector) Stop() {
+	close(d.quit)
+	<-d.done
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for ch := range d.subscribers {
+		delete(d.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (d *Detector) emit(o Opportunity) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for ch := range d.subscribers {
+		select {
+		case ch <- o:
+		default:
+		}
+	}
+}
+
+// venue is the book of a symbol on an exchange
+type venue struct {
+	key    orderbook.ExchangeKey
+	symbol orderbook.Symbol
+	depth  orderbook.Depth
+}
+
+// Evaluate compares every pair of exchanges holding a book of the left
+// currency of symbol, whatever its right currency, and returns the
+// opportunities with a profit above MinProfit, ordered by buy and sell
+// venue then symbol. The bids of the sell venue are converted to the
+// right currency of the buy venue, the pairs without a rate are skipped.
+func (d *Detector) Evaluate(symbol orderbook.Symbol) (opportunities []Opportunity) {
+	base := orderbook.GetLeftCurrency(string(symbol))
+	var venues []venue
+	for key, ex := range orderbook.Exchanges {
+		for s, book := range ex.Books {
+			if orderbook.GetLeftCurrency(string(s)) == base {
+				venues = append(venues, venue{key, s, book.Depth(0)})
+			}
+		}
+	}
+	for _, buy := range venues {
+		for _, sell := range venues {
+			if buy.key == sell.key {
+				continue
+			}
+			bids, ok := d.convert(sell.depth.Bids, orderbook.GetRightCurrency(string(sell.symbol)), orderbook.GetRightCurrency(string(buy.symbol)))
+			if !ok {
+				continue
+			}
+			buyFee := orderbook.Fees.Taker(buy.key, buy.symbol)
+			sellFee := orderbook.Fees.Taker(sell.key, sell.symbol)
+			o := Spread(buy.depth.Asks, bids, buyFee, sellFee)
+			if o.Qty.IsZero() {
+				continue
+			}
+			// the base bought has to be moved to the sell venue
+			if fee, ok := orderbook.Fees.Withdrawal(buy.key, base, ""); ok {
+				o.Profit = o.Profit.Sub(fee.Mul(o.SellPrice))
+			}
+			if o.Profit.LessThanOrEqual(d.MinProfit) {
+				continue
+			}
+			o.Symbol = buy.symbol
+			o.SellSymbol = sell.symbol
+			o.BuyVenue = buy.key
+			o.SellVenue = sell.key
+			o.Time = d.Now()
+			opportunities = append(opportunities, o)
+		}
+	}
+	sort.Slice(opportunities, func(i, j int) bool {
+		a, b := opportunities[i], opportunities[j]
+		if a.BuyVenue != b.BuyVenue {
+			return a.BuyVenue < b.BuyVenue
+		}
+		if a.SellVenue != b.SellVenue {
+			return a.SellVenue < b.SellVenue
+		}
+		if a.Symbol != b.Symbol {
+			return a.Symbol < b.Symbol
+		}
+		return a.SellSymbol < b.SellSymbol
+	})
+	return opportunities
+}
+
+// convert returns the levels with their price converted from one quote
+// currency to another. Each price is converted on its own, multiplying
+// by the rate of one unit would carry the rounding of that rate.
+func (d *Detector) convert(levels []orderbook.Order, from, to string) ([]orderbook.Order, bool) {
+	if from == to {
+		return levels, true
+	}
+	converted := make([]orderbook.Order, len(levels))
+	for i, o := range levels {
+		price, ok := d.Convert(o.Price, from, to)
+		if !ok || !price.IsPositive() {
+			return nil, false
+		}
+		o.Price = price
+		converted[i] = o
+	}
+	return converted, true
+}
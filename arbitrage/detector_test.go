@@ -0,0 +1,189 @@
+package arbitrage
+
+import (
+	"testing"
+
+	"github.com/anthonychristian/crypto-arbitrage/metrics"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+var d = decimal.RequireFromString
+
+// setFees replaces the fee schedules, returning a func restoring them
+func setFees(fees orderbook.FeeMap) func() {
+	saved := orderbook.Fees
+	orderbook.Fees = fees
+	return func() { orderbook.Fees = saved }
+}
+
+func TestEvaluate(t *testing.T) {
+	cheap := orderbook.NewOrderBook()
+	cheap.AddBuy(orderbook.Order{Price: d("99"), Qty: d("5")})
+	cheap.AddSell(orderbook.Order{Price: d("100"), Qty: d("1")})
+	cheap.AddSell(orderbook.Order{Price: d("101"), Qty: d("2")})
+	cheap.AddSell(orderbook.Order{Price: d("110"), Qty: d("10")})
+
+	rich := orderbook.NewOrderBook()
+	rich.AddBuy(orderbook.Order{Price: d("105"), Qty: d("2")})
+	rich.AddBuy(orderbook.Order{Price: d("104"), Qty: d("5")})
+	rich.AddSell(orderbook.Order{Price: d("106"), Qty: d("5")})
+
+	orderbook.Exchanges[orderbook.Binance] = orderbook.Exchange{
+		Books: orderbook.OrderBookMap{orderbook.BTC_USDC: cheap},
+	}
+	orderbook.Exchanges[orderbook.Indodax] = orderbook.Exchange{
+		Books: orderbook.OrderBookMap{orderbook.BTC_USDC: rich},
+	}
+	defer delete(orderbook.Exchanges, orderbook.Binance)
+	defer delete(orderbook.Exchanges, orderbook.Indodax)
+	defer setFees(orderbook.FeeMap{
+		orderbook.Binance: {Taker: d("0.001")},
+	})()
+
+	opportunities := NewDetector(decimal.Zero).Evaluate(orderbook.BTC_USDC)
+	assert.Len(t, opportunities, 1)
+	o := opportunities[0]
+	assert.Equal(t, orderbook.Binance, o.BuyVenue)
+	assert.Equal(t, orderbook.Indodax, o.SellVenue)
+	// 1 @ 100 -> 105, 1 @ 101 -> 105, 1 @ 101 -> 104, with fees on binance
+	assert.Equal(t, "3", o.Qty.String())
+	assert.True(t, d("302").Div(d("3")).Equal(o.BuyPrice))
+	assert.True(t, d("314").Div(d("3")).Equal(o.SellPrice))
+	assert.Equal(t, "11.698", o.Profit.String())
+}
+
+func TestEvaluateNoSpread(t *testing.T) {
+	a := orderbook.NewOrderBook()
+	a.AddBuy(orderbook.Order{Price: d("99"), Qty: d("5")})
+	a.AddSell(orderbook.Order{Price: d("100"), Qty: d("5")})
+	b := orderbook.NewOrderBook()
+	b.AddBuy(orderbook.Order{Price: d("100"), Qty: d("5")})
+	b.AddSell(orderbook.Order{Price: d("101"), Qty: d("5")})
+
+	orderbook.Exchanges[orderbook.Binance] = orderbook.Exchange{
+		Books: orderbook.OrderBookMap{orderbook.BTC_USDC: a},
+	}
+	orderbook.Exchanges[orderbook.Indodax] = orderbook.Exchange{
+		Books: orderbook.OrderBookMap{orderbook.BTC_USDC: b},
+	}
+	defer delete(orderbook.Exchanges, orderbook.Binance)
+	defer delete(orderbook.Exchanges, orderbook.Indodax)
+
+	defer setFees(orderbook.FeeMap{
+		orderbook.Binance: {Taker: d("0.001")},
+	})()
+
+	// buying at 100 and selling at 100 only pays binance's fee
+	assert.Empty(t, NewDetector(decimal.Zero).Evaluate(orderbook.BTC_USDC))
+}
+
+func TestEvaluateWithdrawalFee(t *testing.T) {
+	cheap := orderbook.NewOrderBook()
+	cheap.AddBuy(orderbook.Order{Price: d("99"), Qty: d("5")})
+	cheap.AddSell(orderbook.Order{Price: d("100"), Qty: d("1")})
+	rich := orderbook.NewOrderBook()
+	rich.AddBuy(orderbook.Order{Price: d("105"), Qty: d("1")})
+	rich.AddSell(orderbook.Order{Price: d("106"), Qty: d("5")})
+
+	orderbook.Exchanges[orderbook.Binance] = orderbook.Exchange{
+		Books: orderbook.OrderBookMap{orderbook.BTC_USDC: cheap},
+	}
+	orderbook.Exchanges[orderbook.Indodax] = orderbook.Exchange{
+		Books: orderbook.OrderBookMap{orderbook.BTC_USDC: rich},
+	}
+	defer delete(orderbook.Exchanges, orderbook.Binance)
+	defer delete(orderbook.Exchanges, orderbook.Indodax)
+	defer setFees(orderbook.FeeMap{
+		orderbook.Binance: {
+			Withdrawals: map[string]map[string]decimal.Decimal{"BTC": {"BTC": d("0.02")}},
+		},
+	})()
+
+	// 1 @ 100 -> 105, less 0.02 BTC worth 2.1 to move it
+	opportunities := NewDetector(decimal.Zero).Evaluate(orderbook.BTC_USDC)
+	assert.Len(t, opportunities, 1)
+	assert.Equal(t, "2.9", opportunities[0].Profit.String())
+
+	// the withdrawal eats the whole spread
+	orderbook.Fees[orderbook.Binance].Withdrawals["BTC"]["BTC"] = d("0.05")
+	assert.Empty(t, NewDetector(decimal.Zero).Evaluate(orderbook.BTC_USDC))
+}
+
+func TestEvaluateAcrossQuotes(t *testing.T) {
+	binance := orderbook.NewOrderBook()
+	binance.AddBuy(orderbook.Order{Price: d("199"), Qty: d("5")})
+	binance.AddSell(orderbook.Order{Price: d("200"), Qty: d("2")})
+	indodax := orderbook.NewOrderBook()
+	indodax.AddBuy(orderbook.Order{Price: d("3150000"), Qty: d("1")})
+	indodax.AddBuy(orderbook.Order{Price: d("2900000"), Qty: d("5")})
+	indodax.AddSell(orderbook.Order{Price: d("3200000"), Qty: d("5")})
+
+	orderbook.Exchanges[orderbook.Binance] = orderbook.Exchange{
+		Books: orderbook.OrderBookMap{orderbook.ETH_USDT: binance},
+	}
+	orderbook.Exchanges[orderbook.Indodax] = orderbook.Exchange{
+		Books: orderbook.OrderBookMap{orderbook.ETH_IDR: indodax},
+	}
+	defer delete(orderbook.Exchanges, orderbook.Binance)
+	defer delete(orderbook.Exchanges, orderbook.Indodax)
+	defer setFees(orderbook.FeeMap{})()
+
+	detector := NewDetector(decimal.Zero)
+	detector.Convert = func(amount decimal.Decimal, from, to string) (decimal.Decimal, bool) {
+		switch {
+		case from == "IDR" && to == "USDT":
+			return amount.Div(d("15000")), true
+		case from == "USDT" && to == "IDR":
+			return amount.Mul(d("15000")), true
+		}
+		return decimal.Zero, false
+	}
+
+	// the update of either book evaluates both
+	for _, symbol := range []orderbook.Symbol{orderbook.ETH_IDR, orderbook.ETH_USDT} {
+		opportunities := detector.Evaluate(symbol)
+		assert.Len(t, opportunities, 1)
+		o := opportunities[0]
+		assert.Equal(t, orderbook.ETH_USDT, o.Symbol)
+		assert.Equal(t, orderbook.ETH_IDR, o.SellSymbol)
+		assert.Equal(t, orderbook.Binance, o.BuyVenue)
+		assert.Equal(t, orderbook.Indodax, o.SellVenue)
+		// 1 @ 200 USDT -> 3150000 IDR, worth 210 USDT
+		assert.Equal(t, "1", o.Qty.String())
+		assert.Equal(t, "210", o.SellPrice.String())
+		assert.Equal(t, "10", o.Profit.String())
+	}
+
+	// without a rate the books can't be compared
+	detector.Convert = func(decimal.Decimal, string, string) (decimal.Decimal, bool) { return decimal.Zero, false }
+	assert.Empty(t, detector.Evaluate(orderbook.ETH_IDR))
+}
+
+func TestObserve(t *testing.T) {
+	count := metrics.Opportunities.WithLabelValues("ETH/IDR", "Indodax", "Binance", "ETH/USDT")
+	profit := metrics.OpportunityProfit.WithLabelValues("ETH/IDR", "Indodax", "Binance", "ETH/USDT")
+	before := testutil.ToFloat64(count)
+	profitable := make(map[route]bool)
+
+	observe(profitable, "ETH", []Opportunity{{
+		Symbol:     orderbook.ETH_IDR,
+		SellSymbol: orderbook.ETH_USDT,
+		BuyVenue:   orderbook.Indodax,
+		SellVenue:  orderbook.Binance,
+		Profit:     d("2.5"),
+	}})
+	assert.Equal(t, before+1, testutil.ToFloat64(count))
+	assert.Equal(t, 2.5, testutil.ToFloat64(profit))
+
+	// another currency leaves the route alone
+	observe(profitable, "BTC", nil)
+	assert.Equal(t, 2.5, testutil.ToFloat64(profit))
+
+	observe(profitable, "ETH", nil)
+	assert.Equal(t, before+1, testutil.ToFloat64(count))
+	assert.Equal(t, 0.0, testutil.ToFloat64(profit))
+	assert.Empty(t, profitable)
+}
@@ -0,0 +1,57 @@
+package arbitrage
+
+import (
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/shopspring/decimal"
+)
+
+var one = decimal.New(1, 0)
+
+// Spread walks the asks of the buy venue upwards and the bids of the
+// sell venue downwards, taking liquidity for as long as buying a level
+// (fees included) costs less than what selling it (fees included) brings.
+// The venues and the symbol of the returned Opportunity are left empty.
+func Spread(asks, bids []orderbook.Order, buyFee, sellFee decimal.Decimal) (o Opportunity) {
+	if len(asks) == 0 || len(bids) == 0 {
+		return o
+	}
+	i, j := 0, 0
+	ask, bid := asks[i], bids[j]
+	askQty, bidQty := ask.Qty, bid.Qty
+
+	var cost, proceeds decimal.Decimal
+	for {
+		buyPrice := ask.Price.Mul(one.Add(buyFee))
+		sellPrice := bid.Price.Mul(one.Sub(sellFee))
+		if sellPrice.LessThanOrEqual(buyPrice) {
+			break
+		}
+		qty := decimal.Min(askQty, bidQty)
+		o.Qty = o.Qty.Add(qty)
+		cost = cost.Add(qty.Mul(ask.Price))
+		proceeds = proceeds.Add(qty.Mul(bid.Price))
+		o.Profit = o.Profit.Add(qty.Mul(sellPrice.Sub(buyPrice)))
+
+		askQty = askQty.Sub(qty)
+		bidQty = bidQty.Sub(qty)
+		if askQty.IsZero() {
+			if i++; i == len(asks) {
+				break
+			}
+			ask = asks[i]
+			askQty = ask.Qty
+		}
+		if bidQty.IsZero() {
+			if j++; j == len(bids) {
+				break
+			}
+			bid = bids[j]
+			bidQty = bid.Qty
+		}
+	}
+	if o.Qty.IsPositive() {
+		o.BuyPrice = cost.Div(o.Qty)
+		o.SellPrice = proceeds.Div(o.Qty)
+	}
+	return o
+}
@@ -0,0 +1,389 @@
+// Package backtest replays recorded depth data through the book building,
+// the opportunity detection and the paper trading of the app, and reports
+// what they would have done, so strategy changes can be evaluated offline
+package backtest
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/arbitrage"
+	"github.com/anthonychristian/crypto-arbitrage/fx"
+	"github.com/anthonychristian/crypto-arbitrage/indodax"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/orders"
+	"github.com/anthonychristian/crypto-arbitrage/paper"
+	"github.com/anthonychristian/crypto-arbitrage/recorder"
+	"github.com/anthonychristian/crypto-arbitrage/websocket"
+	"github.com/shopspring/decimal"
+)
+
+// Clock is the time of a replay, moved forward by the records replayed
+type Clock struct {
+	now time.Time
+	mu  sync.RWMutex
+}
+
+// Now returns the time the last record replayed was received
+func (c *Clock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.now
+}
+
+// Set moves the clock to t
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	c.mu.Unlock()
+}
+
+// Config of a replay
+type Config struct {
+	Dir       string                     // Directory the data was recorded to
+	Speed     float64                    // 1 replays at the recorded speed, 10 ten times faster, 0 as fast as possible
+	From, To  time.Time                  // Bounds of the records replayed, unbounded when zero
+	MinProfit decimal.Decimal            // Opportunities above it are detected and traded
+	Notional  decimal.Decimal            // USDT the indodax loop is evaluated for, not evaluated when zero
+	Balances  map[string]decimal.Decimal // Starting balances of every simulated exchange
+	Reference string                     // Currency the P&L is totalled in, DefaultReference when empty
+
+	// Sleep waits between the records, time.Sleep by default
+	Sleep func(time.Duration)
+}
+
+// DefaultReference is the currency the P&L is totalled in by default
+const DefaultReference = "USDT"
+
+// Report is what the app would have done over the records replayed
+type Report struct {
+	Records       int                                                `json:"records"`
+	From          time.Time                                          `json:"from"`
+	To            time.Time                                          `json:"to"`
+	Opportunities []arbitrage.Opportunity                            `json:"opportunities"`
+	Loops         []indodax.Loop                                     `json:"loops"`
+	Rejected      []string                                           `json:"rejected"`
+	Fills         map[orderbook.ExchangeKey][]paper.Fill             `json:"fills"`
+	Balances      map[orderbook.ExchangeKey]map[string]paper.Balance `json:"balances"`
+	PnL           map[string]decimal.Decimal                         `json:"pnl"` // Change of every asset over all the exchanges
+	Reference     string                                             `json:"reference"`
+	Total         decimal.Decimal                                    `json:"total"`    // P&L of the assets converted to Reference
+	Unpriced      []string                                           `json:"unpriced"` // Assets left out of Total, no rate converts them
+}
+
+// replay holds the state of a run
+type replay struct {
+	cfg        Config
+	clock      *Clock
+	binance    *websocket.BinanceReplay
+	detector   *arbitrage.Detector
+	simulators map[orderbook.ExchangeKey]*paper.Exchange
+	updates    []orderbook.BookUpdate
+	open       map[string]bool // Opportunities and loops currently profitable
+	ids        int
+	report     *Report
+}
+
+// Run replays every record of cfg.Dir in the order they were received.
+// It replaces the books of orderbook.Exchanges with books built from the
+// records, so it must not run along the live adapters.
+//
+// An opportunity or a loop is reported when it becomes profitable, not
+// again until it stops being so. Each opportunity reported is traded on
+// the simulators with market orders for its quantity.
+func Run(cfg Config) (*Report, error) {
+	if cfg.Sleep == nil {
+		cfg.Sleep = time.Sleep
+	}
+	if cfg.Reference == "" {
+		cfg.Reference = DefaultReference
+	}
+	streams, err := recorder.Streams(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(streams) == 0 {
+		return nil, fmt.Errorf("nothing recorded in %v", cfg.Dir)
+	}
+
+	orderbook.Exchanges = make(orderbook.ExchangeMap)
+	for _, s := range streams {
+		ex, ok := orderbook.Exchanges[s.Exchange]
+		if !ok {
+			ex = orderbook.Exchange{Books: make(orderbook.OrderBookMap)}
+			orderbook.Exchanges[s.Exchange] = ex
+		}
+		ex.Books[s.Symbol] = orderbook.NewOrderBookWithTick(orderbook.TickSize(s.Exchange, s.Symbol))
+	}
+
+	r := &replay{
+		cfg:        cfg,
+		clock:      &Clock{},
+		detector:   arbitrage.NewDetector(cfg.MinProfit),
+		simulators: make(map[orderbook.ExchangeKey]*paper.Exchange),
+		open:       make(map[string]bool),
+		report: &Report{
+			Fills:     make(map[orderbook.ExchangeKey][]paper.Fill),
+			Balances:  make(map[orderbook.ExchangeKey]map[string]paper.Balance),
+			PnL:       make(map[string]decimal.Decimal),
+			Reference: cfg.Reference,
+		},
+	}
+	r.detector.Now = r.clock.Now
+	r.binance = websocket.NewBinanceReplay(r.clock.Now, func(update orderbook.BookUpdate) {
+		r.updates = append(r.updates, update)
+	})
+	for key := range orderbook.Exchanges {
+		simulator := paper.New(key, cfg.Balances)
+		simulator.Now = r.clock.Now
+		r.simulators[key] = simulator
+	}
+
+	records, err := recorder.OpenDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	defer records.Close()
+	if err := r.run(records); err != nil {
+		return r.report, err
+	}
+	r.settle()
+	return r.report, nil
+}
+
+func (r *replay) run(records *recorder.Merger) error {
+	var last time.Time
+	for {
+		rec, err := records.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if (!r.cfg.From.IsZero() && rec.Received.Before(r.cfg.From)) ||
+			(!r.cfg.To.IsZero() && rec.Received.After(r.cfg.To)) {
+			continue
+		}
+		if r.cfg.Speed > 0 && !last.IsZero() {
+			r.cfg.Sleep(time.Duration(float64(rec.Received.Sub(last)) / r.cfg.Speed))
+		}
+		last = rec.Received
+		if r.report.Records == 0 {
+			r.report.From = rec.Received
+		}
+		r.report.Records++
+		r.report.To = rec.Received
+
+		r.clock.Set(rec.Received)
+		if err := r.apply(rec); err != nil {
+			return err
+		}
+		updates := r.updates
+		r.updates = nil
+		for _, update := range updates {
+			r.evaluate(update)
+		}
+	}
+}
+
+// apply builds the book of a record the way its adapter does
+func (r *replay) apply(rec recorder.Record) error {
+	switch rec.Exchange {
+	case orderbook.Binance:
+		return r.binance.Apply(rec)
+	case orderbook.Indodax:
+		update, err := indodax.ReplayDepth(rec)
+		if err != nil {
+			return err
+		}
+		r.updates = append(r.updates, update)
+		return nil
+	}
+	return fmt.Errorf("no replay for %v", rec.Exchange)
+}
+
+// evaluate runs the detection on an update, and trades what it detects
+func (r *replay) evaluate(update orderbook.BookUpdate) {
+	if simulator, ok := r.simulators[update.Exchange]; ok {
+		simulator.Update(update.Symbol)
+	}
+
+	profitable := make(map[string]bool)
+	for _, o := range r.detector.Evaluate(update.Symbol) {
+		key := fmt.Sprintf("%v %v>%v %v", o.Symbol, o.BuyVenue, o.SellSymbol, o.SellVenue)
+		profitable[key] = true
+		if !r.open[key] {
+			r.report.Opportunities = append(r.report.Opportunities, o)
+			r.trade(o)
+		}
+	}
+	// the evaluation covers every symbol of the base currency
+	base := orderbook.GetLeftCurrency(string(update.Symbol))
+	for key := range r.open {
+		if strings.HasPrefix(key, base+"/") && !profitable[key] {
+			delete(r.open, key)
+		}
+	}
+	for key := range profitable {
+		r.open[key] = true
+	}
+
+	if !r.cfg.Notional.IsPositive() {
+		return
+	}
+	loops, ok := indodax.EvaluateLoops(r.cfg.Notional, r.clock.Now())
+	if !ok {
+		return
+	}
+	for _, loop := range loops {
+		key := "loop " + loop.Direction
+		if !loop.Filled || !loop.Profit.IsPositive() {
+			delete(r.open, key)
+			continue
+		}
+		if !r.open[key] {
+			r.report.Loops = append(r.report.Loops, loop)
+			r.open[key] = true
+		}
+	}
+}
+
+// trade buys the quantity of an opportunity on its buy venue,
+// and sells it on its sell venue
+func (r *replay) trade(o arbitrage.Opportunity) {
+	r.submit(o.BuyVenue, o.Symbol, orders.Buy, o.Qty)
+	r.submit(o.SellVenue, o.SellSymbol, orders.Sell, o.Qty)
+}
+
+func (r *replay) submit(key orderbook.ExchangeKey, symbol orderbook.Symbol, side orders.Side, qty decimal.Decimal) {
+	r.ids++
+	o := orders.Order{
+		Request: orders.Request{
+			ClientOrderID: fmt.Sprintf("backtest-%d", r.ids),
+			Symbol:        symbol,
+			Exchange:      key,
+			Qty:           qty,
+			Side:          side,
+			Type:          orders.Market,
+			TimeInForce:   orders.IOC,
+		},
+		Status:    orders.New,
+		CreatedAt: r.clock.Now(),
+	}
+	simulator := r.simulators[key]
+	if _, err := simulator.Submit(o); err != nil {
+		r.report.Rejected = append(r.report.Rejected, fmt.Sprintf("%v %v %v %v on %v: %v", r.clock.Now().Format(time.RFC3339Nano), side, qty, symbol, key, err))
+	}
+	// the fills are read from the simulator, not from its executions
+	for len(simulator.Executions()) > 0 {
+		<-simulator.Executions()
+	}
+}
+
+// settle reports the fills and the balances of the simulators, and
+// totals the P&L of the assets with the rates of the replayed books
+func (r *replay) settle() {
+	for key, simulator := range r.simulators {
+		r.report.Fills[key] = simulator.Fills()
+		balances := simulator.Balances()
+		r.report.Balances[key] = balances
+		for asset, b := range balances {
+			r.report.PnL[asset] = r.report.PnL[asset].Add(b.Free).Add(b.Locked).Sub(r.cfg.Balances[asset])
+		}
+	}
+
+	rates := r.rates()
+	assets := make([]string, 0, len(r.report.PnL))
+	for asset := range r.report.PnL {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+	for _, asset := range assets {
+		converted, ok := rates.Convert(r.report.PnL[asset], asset, r.cfg.Reference)
+		if !ok {
+			r.report.Unpriced = append(r.report.Unpriced, asset)
+			continue
+		}
+		r.report.Total = r.report.Total.Add(converted)
+	}
+}
+
+// rates returns the rates of the books at the end of the replay. A symbol
+// listed on several exchanges is priced by the first one by name, so
+// that the same records always give the same total.
+func (r *replay) rates() *fx.Service {
+	keys := make([]string, 0, len(orderbook.Exchanges))
+	for key := range orderbook.Exchanges {
+		keys = append(keys, string(key))
+	}
+	sort.Strings(keys)
+	rates := fx.NewService()
+	priced := make(map[orderbook.Symbol]bool)
+	for _, key := range keys {
+		for symbol, book := range orderbook.Exchanges[orderbook.ExchangeKey(key)].Books {
+			if _, _, ok := book.BBO(); ok && !priced[symbol] {
+				rates.Update(symbol, book)
+				priced[symbol] = true
+			}
+		}
+	}
+	return rates
+}
+
+// Write prints a summary of the report
+func (rep *Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "%d records from %v to %v\n", rep.Records,
+		rep.From.Format(time.RFC3339), rep.To.Format(time.RFC3339))
+
+	fmt.Fprintf(w, "\n%d opportunities\n", len(rep.Opportunities))
+	for _, o := range rep.Opportunities {
+		fmt.Fprintf(w, "  %v %v buy %v on %v at %v, sell %v on %v at %v, profit %v\n",
+			o.Time.Format(time.RFC3339Nano), o.Symbol, o.Qty, o.BuyVenue, o.BuyPrice.StringFixed(8),
+			o.SellSymbol, o.SellVenue, o.SellPrice.StringFixed(8), o.Profit.StringFixed(8))
+	}
+
+	fmt.Fprintf(w, "\n%d profitable loops\n", len(rep.Loops))
+	for _, l := range rep.Loops {
+		fmt.Fprintf(w, "  %v %v: %v USDT -> %v USDT\n",
+			l.Time.Format(time.RFC3339Nano), l.Direction, l.Notional, l.Return.StringFixed(8))
+	}
+
+	keys := make([]string, 0, len(rep.Fills))
+	for key := range rep.Fills {
+		keys = append(keys, string(key))
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fills := rep.Fills[orderbook.ExchangeKey(key)]
+		fmt.Fprintf(w, "\n%d fills on %v\n", len(fills), key)
+		for _, f := range fills {
+			fmt.Fprintf(w, "  %v %v %v %v at %v, fee %v\n",
+				f.Time.Format(time.RFC3339Nano), f.Side, f.Qty, f.Symbol, f.Price.StringFixed(8), f.Fee.StringFixed(8))
+		}
+	}
+	if len(rep.Rejected) > 0 {
+		fmt.Fprintf(w, "\n%d rejected orders\n", len(rep.Rejected))
+		for _, reason := range rep.Rejected {
+			fmt.Fprintf(w, "  %v\n", reason)
+		}
+	}
+
+	assets := make([]string, 0, len(rep.PnL))
+	for asset := range rep.PnL {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+	fmt.Fprintf(w, "\nP&L\n")
+	for _, asset := range assets {
+		fmt.Fprintf(w, "  %v %v\n", asset, rep.PnL[asset])
+	}
+	fmt.Fprintf(w, "  total %v %v\n", rep.Total.StringFixed(8), rep.Reference)
+	if len(rep.Unpriced) > 0 {
+		fmt.Fprintf(w, "  no rate to %v for %v\n", rep.Reference, strings.Join(rep.Unpriced, ", "))
+	}
+}
@@ -0,0 +1,110 @@
+package backtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/recorder"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+var d = decimal.RequireFromString
+
+// record writes the data binance or indodax sent for BTC/USDC
+func record(t *testing.T, r *recorder.Recorder, received time.Time, exchange orderbook.ExchangeKey, kind recorder.Kind, data string) {
+	assert.Nil(t, r.Write(recorder.Record{
+		Received: received,
+		Exchange: exchange,
+		Symbol:   orderbook.BTC_USDC,
+		Kind:     kind,
+		Data:     json.RawMessage(data),
+	}))
+}
+
+func TestRun(t *testing.T) {
+	exchanges, fees := orderbook.Exchanges, orderbook.Fees
+	orderbook.Fees = orderbook.FeeMap{}
+	defer func() { orderbook.Exchanges, orderbook.Fees = exchanges, fees }()
+
+	dir, err := ioutil.TempDir("", "backtest")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2020, 1, 6, 10, 0, 0, 0, time.UTC)
+	r := recorder.New(dir)
+	// the first event is recorded before the snapshot it triggered,
+	// it is applied on top of it
+	record(t, r, start, orderbook.Binance, recorder.Diff,
+		`{"e":"depthUpdate","s":"BTCUSDC","U":101,"u":101,"b":[],"a":[["4000.00","2"]]}`)
+	record(t, r, start.Add(time.Millisecond), orderbook.Binance, recorder.Snapshot,
+		`{"lastUpdateId":100,"bids":[["3990.00","2"]],"asks":[["4000.00","1"],["4010.00","1"]]}`)
+	// 1 bought at 4000 on binance is sold at 4100 on indodax
+	record(t, r, start.Add(time.Second), orderbook.Indodax, recorder.Snapshot,
+		`{"buy":[[4100,"1"]],"sell":[[4200,"1"]]}`)
+	// still open, not reported again
+	record(t, r, start.Add(2*time.Second), orderbook.Binance, recorder.Diff,
+		`{"e":"depthUpdate","s":"BTCUSDC","U":102,"u":102,"b":[],"a":[["4000.00","0"]]}`)
+	record(t, r, start.Add(3*time.Second), orderbook.Indodax, recorder.Snapshot,
+		`{"buy":[[4000,"1"]],"sell":[[4200,"1"]]}`)
+	// open again at 4010, indodax has no BTC left to sell
+	record(t, r, start.Add(4*time.Second), orderbook.Indodax, recorder.Snapshot,
+		`{"buy":[[4100,"1"]],"sell":[[4200,"1"]]}`)
+	assert.Nil(t, r.Close())
+
+	var slept time.Duration
+	report, err := Run(Config{
+		Dir:      dir,
+		Speed:    2,
+		Balances: map[string]decimal.Decimal{"USDC": d("10000"), "BTC": d("1")},
+		Sleep:    func(d time.Duration) { slept += d },
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2*time.Second, slept)
+	assert.Equal(t, 6, report.Records)
+
+	assert.Len(t, report.Opportunities, 2)
+	first, second := report.Opportunities[0], report.Opportunities[1]
+	assert.Equal(t, orderbook.Binance, first.BuyVenue)
+	assert.Equal(t, orderbook.Indodax, first.SellVenue)
+	assert.Equal(t, "100", first.Profit.String())
+	assert.True(t, start.Add(time.Second).Equal(first.Time))
+	assert.Equal(t, "90", second.Profit.String())
+	assert.True(t, start.Add(4*time.Second).Equal(second.Time))
+
+	assert.Len(t, report.Fills[orderbook.Binance], 2)
+	assert.Len(t, report.Fills[orderbook.Indodax], 1)
+	assert.Len(t, report.Rejected, 1)
+	assert.Equal(t, "1990", report.Balances[orderbook.Binance]["USDC"].Free.String())
+	assert.Equal(t, "14100", report.Balances[orderbook.Indodax]["USDC"].Free.String())
+	assert.Equal(t, "-3910", report.PnL["USDC"].String())
+	assert.Equal(t, "1", report.PnL["BTC"].String())
+	// BTC is priced at the mid of the binance book, USDC at par
+	assert.Equal(t, "USDT", report.Reference)
+	assert.Equal(t, "90", report.Total.String())
+	assert.Empty(t, report.Unpriced)
+
+	var out bytes.Buffer
+	report.Write(&out)
+	assert.Contains(t, out.String(), "2 opportunities")
+	assert.Contains(t, out.String(), "1 rejected orders")
+	assert.Contains(t, out.String(), "total 90.00000000 USDT")
+
+	// the same records give the same report
+	again, err := Run(Config{Dir: dir, Balances: map[string]decimal.Decimal{"USDC": d("10000"), "BTC": d("1")}})
+	assert.Nil(t, err)
+	assert.Equal(t, report.Opportunities, again.Opportunities)
+	assert.Equal(t, report.PnL, again.PnL)
+	assert.Equal(t, report.Total, again.Total)
+
+	// nothing converts BTC to IDR
+	idr, err := Run(Config{Dir: dir, Reference: "IDR", Balances: map[string]decimal.Decimal{"USDC": d("10000"), "BTC": d("1")}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"BTC", "USDC"}, idr.Unpriced)
+	assert.True(t, idr.Total.IsZero())
+}
@@ -0,0 +1,91 @@
+// Command replay replays the depth data recorded with RECORD_DIR through
+// the book building, opportunity detection and paper trading of the app,
+// and prints what they would have done:
+//
+//	replay -dir data -speed 10 -balances USDT=10000,ETH=1,IDR=150000000
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/backtest"
+	"github.com/anthonychristian/crypto-arbitrage/logging"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/paper"
+	"github.com/shopspring/decimal"
+)
+
+var log = logging.New("replay")
+
+func main() {
+	var (
+		dir       = flag.String("dir", "", "directory the depth data was recorded to")
+		speed     = flag.Float64("speed", 0, "1 replays at the recorded speed, 10 ten times faster, 0 as fast as possible")
+		from      = flag.String("from", "", "replay the data received from this RFC3339 time")
+		to        = flag.String("to", "", "replay the data received until this RFC3339 time")
+		minProfit = flag.String("min-profit", "0", "profit above which opportunities are traded")
+		notional  = flag.String("notional", "100", "USDT the indodax loop is evaluated for")
+		balances  = flag.String("balances", "", "starting balances of every simulated exchange, e.g. USDT=10000,ETH=1")
+		fees      = flag.String("fees", "", "JSON file overriding the fee schedules")
+		reference = flag.String("reference", backtest.DefaultReference, "currency the P&L is totalled in")
+		asJSON    = flag.Bool("json", false, "print the report as JSON")
+	)
+	flag.Parse()
+	if *dir == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := backtest.Config{
+		Dir:       *dir,
+		Speed:     *speed,
+		From:      parseTime("from", *from),
+		To:        parseTime("to", *to),
+		MinProfit: parseDecimal("min-profit", *minProfit),
+		Notional:  parseDecimal("notional", *notional),
+		Reference: *reference,
+	}
+	var err error
+	if cfg.Balances, err = paper.ParseBalances(*balances); err != nil {
+		log.Fatal("Error parsing balances", "err", err)
+	}
+	if *fees != "" {
+		if err := orderbook.LoadFees(*fees); err != nil {
+			log.Fatal("Error loading fees", "path", *fees, "err", err)
+		}
+	}
+
+	report, err := backtest.Run(cfg)
+	if err != nil {
+		log.Fatal("Error replaying", "dir", *dir, "err", err)
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+		return
+	}
+	report.Write(os.Stdout)
+}
+
+func parseTime(name, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Fatal("Error parsing -"+name, "err", err)
+	}
+	return t
+}
+
+func parseDecimal(name, value string) decimal.Decimal {
+	d, err := decimal.NewFromString(value)
+	if err != nil {
+		log.Fatal("Error parsing -"+name, "err", err)
+	}
+	return d
+}
@@ -0,0 +1,402 @@
+// Package config holds the settings of the app: the exchanges and symbols
+// started, their endpoints, the fees, the conversion rates, the recording,
+// the arbitrage loop, the paper trading and the logs.
+// They are read from a YAML file, see config.example.yaml, and overridden
+// by the environment variables named in the Env* constants.
+package config
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/fx"
+	"github.com/anthonychristian/crypto-arbitrage/indodax"
+	"github.com/anthonychristian/crypto-arbitrage/logging"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/paper"
+	"github.com/shopspring/decimal"
+	"gopkg.in/yaml.v3"
+
+	// the adapters register themselves, their defaults are
+	// the defaults of the configuration
+	_ "github.com/anthonychristian/crypto-arbitrage/websocket"
+)
+
+// Environment variables overriding the file
+const (
+	EnvAddr          = "ADDR"
+	EnvFeesFile      = "FEES_FILE"
+	EnvRecordDir     = "RECORD_DIR"
+	EnvPaperTrading  = "PAPER_TRADING"
+	EnvPaperBalances = "PAPER_BALANCES"
+	EnvLogLevel      = "LOG_LEVEL"
+	EnvLogFormat     = "LOG_FORMAT"
+)
+
+// Environment variables overriding the settings of an exchange,
+// prefixed with its key in upper case, e.g. BINANCE_SYMBOLS
+const (
+	EnvSymbols      = "_SYMBOLS"
+	EnvAPIKey       = "_API_KEY"
+	EnvAPISecret    = "_API_SECRET"
+	EnvPollInterval = "_POLL_INTERVAL"
+)
+
+const (
+	redacted            = "<redacted>"
+	minimumPollInterval = time.Second
+)
+
+// Config is the whole configuration of the app
+type Config struct {
+	Addr      string                                            `yaml:"addr"`           // Address the dashboard is served on
+	Exchanges map[orderbook.ExchangeKey]orderbook.AdapterConfig `yaml:"exchanges"`      // Settings of the adapters, an adapter is started when it lists a symbol
+	Fees      orderbook.FeeMap                                  `yaml:"fees,omitempty"` // Replaces the fee schedules of the exchanges listed
+	FeesFile  string                                            `yaml:"fees_file"`      // JSON file merged over Fees
+	FX        FX                                                `yaml:"fx"`
+	Loop      Loop                                              `yaml:"loop"`
+	RecordDir string                                            `yaml:"record_dir"`
+	Paper     Paper                                             `yaml:"paper"`
+	Log       Log                                               `yaml:"log"`
+}
+
+// FX configures the rates the quote currencies are converted with
+type FX struct {
+	MaxAge    time.Duration                        `yaml:"max_age"`   // How long the rate of a book is used after its last update
+	Fallbacks map[orderbook.Symbol]decimal.Decimal `yaml:"fallbacks"` // Rates used while no live book gives one, 0 disables one
+}
+
+// Loop configures the arbitrage loop evaluated by the indodax worker
+type Loop struct {
+	Notional decimal.Decimal `yaml:"notional"` // USDT put in the loop
+}
+
+// Paper lists the exchanges whose orders are simulated
+type Paper struct {
+	Exchanges []orderbook.ExchangeKey    `yaml:"exchanges"`
+	Balances  map[string]decimal.Decimal `yaml:"balances"` // Starting balances of every simulator
+}
+
+// Log configures the logs, the levels can be changed at runtime
+// on the /admin/log-level endpoint
+type Log struct {
+	Level      string            `yaml:"level"`      // debug, info, warn or error
+	Format     string            `yaml:"format"`     // text or json
+	Components map[string]string `yaml:"components"` // Levels overriding Level, e.g. binance: debug
+}
+
+// Default returns the configuration the app runs with without a file,
+// every registered adapter runs with its defaults
+func Default() Config {
+	return Config{
+		Addr:      ":8080",
+		Exchanges: defaults(),
+		FX: FX{
+			MaxAge: fx.MaxAge,
+			Fallbacks: map[orderbook.Symbol]decimal.Decimal{
+				"USDC/USDT":        decimal.New(1, 0),
+				orderbook.USDT_IDR: decimal.New(15000, 0),
+			},
+		},
+		Loop: Loop{Notional: indodax.LoopNotional},
+		Log:  Log{Level: "info", Format: "text"},
+	}
+}
+
+// defaults returns the defaults of the configurable adapters
+func defaults() map[orderbook.ExchangeKey]orderbook.AdapterConfig {
+	exchanges := make(map[orderbook.ExchangeKey]orderbook.AdapterConfig)
+	for _, a := range orderbook.Adapters() {
+		if c, ok := a.(orderbook.Configurable); ok {
+			exchanges[a.Key()] = c.Defaults()
+		}
+	}
+	return exchanges
+}
+
+// Load reads the file at path over the defaults, an empty path
+// keeping the defaults, then applies the environment and validates
+// the result. The settings an exchange of the file leaves out keep
+// their default.
+func Load(path string) (Config, error) {
+	c := Default()
+	if path != "" {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return c, err
+		}
+		if err := yaml.Unmarshal(contents, &c); err != nil {
+			return c, fmt.Errorf("%v: %v", path, err)
+		}
+		for key, d := range defaults() {
+			if e, ok := c.Exchanges[key]; ok {
+				c.Exchanges[key] = merge(e, d)
+			}
+		}
+	}
+	if err := c.ApplyEnv(os.LookupEnv); err != nil {
+		return c, err
+	}
+	return c, c.Validate()
+}
+
+// merge returns e with the settings it leaves out set to those of d
+func merge(e, d orderbook.AdapterConfig) orderbook.AdapterConfig {
+	if e.Symbols == nil {
+		e.Symbols = d.Symbols
+	}
+	if e.PollInterval == 0 {
+		e.PollInterval = d.PollInterval
+	}
+	urls := make(map[string]string)
+	for name, u := range d.URLs {
+		urls[name] = u
+	}
+	for name, u := range e.URLs {
+		urls[name] = u
+	}
+	if len(urls) > 0 {
+		e.URLs = urls
+	}
+	return e
+}
+
+// ApplyEnv overrides the settings set in the environment
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	str := func(name string, dst *string) {
+		if v, ok := lookup(name); ok {
+			*dst = v
+		}
+	}
+	symbols := func(name string, dst *[]orderbook.Symbol) {
+		if v, ok := lookup(name); ok {
+			*dst = nil
+			for _, s := range split(v) {
+				*dst = append(*dst, orderbook.Symbol(s))
+			}
+		}
+	}
+	str(EnvAddr, &c.Addr)
+	str(EnvFeesFile, &c.FeesFile)
+	str(EnvRecordDir, &c.RecordDir)
+	str(EnvLogLevel, &c.Log.Level)
+	str(EnvLogFormat, &c.Log.Format)
+
+	for key, e := range c.Exchanges {
+		prefix := strings.ToUpper(string(key))
+		symbols(prefix+EnvSymbols, &e.Symbols)
+		str(prefix+EnvAPIKey, &e.APIKey)
+		str(prefix+EnvAPISecret, &e.APISecret)
+		if v, ok := lookup(prefix + EnvPollInterval); ok {
+			interval, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%v: %v", prefix+EnvPollInterval, err)
+			}
+			e.PollInterval = interval
+		}
+		c.Exchanges[key] = e
+	}
+	if v, ok := lookup(EnvPaperTrading); ok {
+		c.Paper.Exchanges = nil
+		for _, key := range split(v) {
+			c.Paper.Exchanges = append(c.Paper.Exchanges, orderbook.ExchangeKey(key))
+		}
+	}
+	if v, ok := lookup(EnvPaperBalances); ok {
+		balances, err := paper.ParseBalances(v)
+		if err != nil {
+			return fmt.Errorf("%v: %v", EnvPaperBalances, err)
+		}
+		c.Paper.Balances = balances
+	}
+	return nil
+}
+
+func split(list string) (values []string) {
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// Errors lists every invalid setting of a configuration
+type Errors []string
+
+func (e Errors) Error() string {
+	return "invalid configuration: " + strings.Join(e, "; ")
+}
+
+// Validate checks every setting, returning Errors when some are invalid
+func (c Config) Validate() error {
+	var errs Errors
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if c.Addr == "" {
+		fail("addr is required")
+	}
+	defaults := defaults()
+	count := 0
+	for key, e := range c.Exchanges {
+		if _, ok := orderbook.GetAdapter(key); !ok {
+			fail("exchange %v has no adapter", key)
+		}
+		seen := make(map[orderbook.Symbol]bool)
+		for _, s := range e.Symbols {
+			parts := strings.Split(string(s), "/")
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.ToUpper(string(s)) != string(s) {
+				fail("%v symbol %q is not BASE/QUOTE", key, s)
+			}
+			if seen[s] {
+				fail("%v symbol %v is listed twice", key, s)
+			}
+			seen[s] = true
+		}
+		count += len(e.Symbols)
+
+		for name, u := range e.URLs {
+			if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				fail("exchanges.%v.urls.%v %q is not an http(s) URL", key, name, u)
+			}
+		}
+		// the adapters polling the depth have a default interval
+		if defaults[key].PollInterval > 0 && e.PollInterval < minimumPollInterval {
+			fail("exchanges.%v.poll_interval %v is below %v", key, e.PollInterval, minimumPollInterval)
+		}
+		if (e.APIKey == "") != (e.APISecret == "") {
+			fail("exchanges.%v.api_key and api_secret go together", key)
+		}
+	}
+	if count == 0 {
+		fail("no symbol to trade")
+	}
+
+	for key, schedule := range c.Fees {
+		for name, rate := range map[string]decimal.Decimal{"maker": schedule.Maker, "taker": schedule.Taker} {
+			if rate.IsNegative() || rate.GreaterThanOrEqual(decimal.New(1, 0)) {
+				fail("fees.%v.%v %v is not in [0, 1)", key, name, rate)
+			}
+		}
+	}
+
+	if c.FX.MaxAge <= 0 {
+		fail("fx.max_age %v is not positive", c.FX.MaxAge)
+	}
+	for symbol, rate := range c.FX.Fallbacks {
+		parts := strings.Split(string(symbol), "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			fail("fx fallback %q is not BASE/QUOTE", symbol)
+		}
+		if rate.IsNegative() {
+			fail("fx fallback of %v is negative", symbol)
+		}
+	}
+
+	if !c.Loop.Notional.IsPositive() {
+		fail("loop.notional %v is not positive", c.Loop.Notional)
+	}
+
+	for _, key := range c.Paper.Exchanges {
+		if len(c.Exchanges[key].Symbols) == 0 {
+			fail("paper exchange %v is not started", key)
+		}
+	}
+	for asset, amount := range c.Paper.Balances {
+		if amount.IsNegative() {
+			fail("paper balance of %v is negative", asset)
+		}
+	}
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		fail("log.level: %v", err)
+	}
+	if _, err := logging.ParseFormat(c.Log.Format); err != nil {
+		fail("log.format: %v", err)
+	}
+	for component, level := range c.Log.Components {
+		if _, err := logging.ParseLevel(level); err != nil {
+			fail("log.components.%v: %v", component, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Apply sets up the packages of the app with the configuration.
+// It must be called before orderbook.InitExchanges.
+func (c Config) Apply() error {
+	keys := make([]string, 0, len(c.Exchanges))
+	for key := range c.Exchanges {
+		keys = append(keys, string(key))
+	}
+	sort.Strings(keys)
+	orderbook.SymbolMap = make(orderbook.Symbols)
+	for _, k := range keys {
+		key := orderbook.ExchangeKey(k)
+		e := c.Exchanges[key]
+		for _, s := range e.Symbols {
+			orderbook.SymbolMap[s] = append(orderbook.SymbolMap[s], key)
+		}
+		a, _ := orderbook.GetAdapter(key)
+		if configurable, ok := a.(orderbook.Configurable); ok {
+			if err := configurable.Configure(e); err != nil {
+				return fmt.Errorf("configuring %v: %v", key, err)
+			}
+		}
+	}
+
+	for key, schedule := range c.Fees {
+		orderbook.Fees[key] = schedule
+	}
+
+	fx.MaxAge = c.FX.MaxAge
+	fx.Fallbacks = make(map[orderbook.Symbol]decimal.Decimal)
+	for symbol, rate := range c.FX.Fallbacks {
+		fx.Fallbacks[symbol] = rate
+	}
+	indodax.LoopNotional = c.Loop.Notional
+
+	level, _ := logging.ParseLevel(c.Log.Level)
+	format, _ := logging.ParseFormat(c.Log.Format)
+	logging.SetLevel(level)
+	logging.SetFormat(format)
+	for component, name := range c.Log.Components {
+		level, _ := logging.ParseLevel(name)
+		logging.SetComponentLevel(component, level)
+	}
+
+	if c.FeesFile != "" {
+		return orderbook.LoadFees(c.FeesFile)
+	}
+	return nil
+}
+
+// Dump writes the configuration as YAML, without the API secrets
+func (c Config) Dump(w io.Writer) error {
+	exchanges := make(map[orderbook.ExchangeKey]orderbook.AdapterConfig, len(c.Exchanges))
+	for key, e := range c.Exchanges {
+		if e.APISecret != "" {
+			e.APISecret = redacted
+		}
+		exchanges[key] = e
+	}
+	c.Exchanges = exchanges
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
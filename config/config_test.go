@@ -0,0 +1,252 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/fx"
+	"github.com/anthonychristian/crypto-arbitrage/indodax"
+	"github.com/anthonychristian/crypto-arbitrage/logging"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/websocket"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+var d = decimal.RequireFromString
+
+// env is an environment for ApplyEnv
+type env map[string]string
+
+func (e env) lookup(name string) (string, bool) {
+	v, ok := e[name]
+	return v, ok
+}
+
+func write(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "config")
+	assert.Nil(t, err)
+	path := filepath.Join(dir, "config.yaml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+func TestDefault(t *testing.T) {
+	c := Default()
+	assert.Nil(t, c.Validate())
+	assert.Equal(t, ":8080", c.Addr)
+	assert.Equal(t, 5*time.Second, c.Exchanges[orderbook.Indodax].PollInterval)
+	assert.Equal(t, []orderbook.Symbol{orderbook.BTC_USDC, orderbook.ETH_USDT}, c.Exchanges[orderbook.Binance].Symbols)
+
+	c, err := Load("")
+	assert.Nil(t, err)
+	assert.Equal(t, Default().Exchanges, c.Exchanges)
+}
+
+func TestLoad(t *testing.T) {
+	path := write(t, `
+addr: ":9090"
+exchanges:
+  Binance:
+    symbols: [BTC/USDC]
+  Indodax:
+    poll_interval: 2s
+    urls:
+      trade: http://localhost/tapi
+fees:
+  Indodax:
+    maker: 0
+    taker: 0.002
+paper:
+  exchanges: [Binance]
+  balances:
+    USDC: 1000
+fx:
+  max_age: 30s
+  fallbacks:
+    USDT/IDR: 16000
+loop:
+  notional: 250
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	c, err := Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, ":9090", c.Addr)
+	assert.Equal(t, []orderbook.Symbol{orderbook.BTC_USDC}, c.Exchanges[orderbook.Binance].Symbols)
+	// not in the file, the default is kept
+	assert.Equal(t, []orderbook.Symbol{orderbook.ETH_IDR, orderbook.USDT_IDR}, c.Exchanges[orderbook.Indodax].Symbols)
+	assert.Equal(t, Default().Exchanges[orderbook.Binance].URLs, c.Exchanges[orderbook.Binance].URLs)
+	assert.Equal(t, map[string]string{
+		indodax.APIEndpoint:   indodax.BaseURL,
+		indodax.TradeEndpoint: "http://localhost/tapi",
+	}, c.Exchanges[orderbook.Indodax].URLs)
+	assert.Equal(t, 2*time.Second, c.Exchanges[orderbook.Indodax].PollInterval)
+	assert.Equal(t, "0.002", c.Fees[orderbook.Indodax].Taker.String())
+	assert.Equal(t, []orderbook.ExchangeKey{orderbook.Binance}, c.Paper.Exchanges)
+	assert.Equal(t, "1000", c.Paper.Balances["USDC"].String())
+	assert.Equal(t, 30*time.Second, c.FX.MaxAge)
+	assert.Equal(t, "16000", c.FX.Fallbacks[orderbook.USDT_IDR].String())
+	assert.Equal(t, "1", c.FX.Fallbacks["USDC/USDT"].String())
+	assert.Equal(t, "250", c.Loop.Notional.String())
+
+	// the environment overrides the file
+	assert.Nil(t, c.ApplyEnv(env{
+		EnvAddr:                 ":7070",
+		"INDODAX_SYMBOLS":       "",
+		"INDODAX_POLL_INTERVAL": "10s",
+		EnvPaperTrading:         "Binance, Indodax",
+		EnvPaperBalances:        "USDT=5",
+		"BINANCE_API_KEY":       "key",
+	}.lookup))
+	assert.Equal(t, ":7070", c.Addr)
+	assert.Empty(t, c.Exchanges[orderbook.Indodax].Symbols)
+	assert.Equal(t, 10*time.Second, c.Exchanges[orderbook.Indodax].PollInterval)
+	assert.Equal(t, "key", c.Exchanges[orderbook.Binance].APIKey)
+	assert.Equal(t, []orderbook.ExchangeKey{orderbook.Binance, orderbook.Indodax}, c.Paper.Exchanges)
+	assert.Equal(t, "5", c.Paper.Balances["USDT"].String())
+
+	assert.NotNil(t, c.ApplyEnv(env{"INDODAX_POLL_INTERVAL": "often"}.lookup))
+
+	invalid := write(t, "addr: [\n")
+	defer os.RemoveAll(filepath.Dir(invalid))
+	_, err = Load(invalid)
+	assert.NotNil(t, err)
+	_, err = Load(filepath.Join(filepath.Dir(path), "missing.yaml"))
+	assert.NotNil(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.Exchanges[orderbook.Binance] = orderbook.AdapterConfig{
+		Symbols: []orderbook.Symbol{"BTCUSDC", orderbook.ETH_USDT, orderbook.ETH_USDT},
+		APIKey:  "key",
+	}
+	c.Exchanges[orderbook.Indodax] = orderbook.AdapterConfig{
+		URLs: map[string]string{indodax.APIEndpoint: "indodax.com"},
+	}
+	c.Exchanges["Kraken"] = orderbook.AdapterConfig{}
+	c.Fees = orderbook.FeeMap{orderbook.Binance: {Maker: d("-0.001"), Taker: d("0.001")}}
+	c.Paper.Exchanges = []orderbook.ExchangeKey{orderbook.Indodax}
+	c.Paper.Balances = map[string]decimal.Decimal{"USDT": d("-1")}
+	c.Log.Level = "verbose"
+	c.FX = FX{Fallbacks: map[orderbook.Symbol]decimal.Decimal{"USDTIDR": d("-1")}}
+	c.Loop.Notional = decimal.Zero
+
+	err := c.Validate()
+	errs, ok := err.(Errors)
+	assert.True(t, ok)
+	assert.Len(t, errs, 14)
+	assert.Contains(t, err.Error(), `Binance symbol "BTCUSDC" is not BASE/QUOTE`)
+	assert.Contains(t, err.Error(), "Binance symbol ETH/USDT is listed twice")
+	assert.Contains(t, err.Error(), `exchanges.Indodax.urls.api "indodax.com" is not an http(s) URL`)
+	assert.Contains(t, err.Error(), "exchanges.Indodax.poll_interval 0s is below 1s")
+	assert.Contains(t, err.Error(), "exchanges.Binance.api_key and api_secret go together")
+	assert.Contains(t, err.Error(), "exchange Kraken has no adapter")
+	assert.Contains(t, err.Error(), "fees.Binance.maker -0.001 is not in [0, 1)")
+	assert.Contains(t, err.Error(), "paper exchange Indodax is not started")
+	assert.Contains(t, err.Error(), "paper balance of USDT is negative")
+	assert.Contains(t, err.Error(), `log.level: unknown log level "verbose"`)
+	assert.Contains(t, err.Error(), "fx.max_age 0s is not positive")
+	assert.Contains(t, err.Error(), `fx fallback "USDTIDR" is not BASE/QUOTE`)
+	assert.Contains(t, err.Error(), "fx fallback of USDTIDR is negative")
+	assert.Contains(t, err.Error(), "loop.notional 0 is not positive")
+
+	c = Default()
+	for key, e := range c.Exchanges {
+		e.Symbols = nil
+		c.Exchanges[key] = e
+	}
+	assert.EqualError(t, c.Validate(), "invalid configuration: no symbol to trade")
+}
+
+func TestApply(t *testing.T) {
+	symbols, fees := orderbook.SymbolMap, orderbook.Fees
+	depthURL, restURL := websocket.BinanceDepthURL, websocket.BinanceRESTURL
+	apiURL, tradeURL, pollInterval := indodax.BaseURL, indodax.TradeURL, indodax.PollInterval
+	maxAge, fallbacks := fx.MaxAge, fx.Fallbacks
+	notional := indodax.LoopNotional
+	orderbook.Fees = orderbook.FeeMap{}
+	defer func() {
+		fx.MaxAge, fx.Fallbacks = maxAge, fallbacks
+		indodax.LoopNotional = notional
+		orderbook.SymbolMap, orderbook.Fees = symbols, fees
+		websocket.BinanceDepthURL, websocket.BinanceRESTURL = depthURL, restURL
+		websocket.BinanceRESTInstance.BaseURL = restURL
+		websocket.BinanceRESTInstance.SetCredentials("", "")
+		if a, ok := orderbook.GetAdapter(orderbook.Indodax); ok {
+			a.(orderbook.Configurable).Configure(orderbook.AdapterConfig{})
+		}
+		indodax.BaseURL, indodax.TradeURL, indodax.PollInterval = apiURL, tradeURL, pollInterval
+		logging.SetLevel(logging.InfoLevel)
+		logging.ResetComponentLevel("binance")
+	}()
+
+	c := Default()
+	c.Exchanges[orderbook.Binance] = merge(orderbook.AdapterConfig{
+		Symbols: []orderbook.Symbol{orderbook.ETH_USDT},
+		URLs:    map[string]string{websocket.DepthEndpoint: "http://localhost/depth"},
+	}, c.Exchanges[orderbook.Binance])
+	c.Exchanges[orderbook.Indodax] = merge(orderbook.AdapterConfig{
+		Symbols:      []orderbook.Symbol{orderbook.ETH_USDT, orderbook.ETH_IDR},
+		URLs:         map[string]string{indodax.APIEndpoint: "http://localhost/api/"},
+		PollInterval: time.Minute,
+		APIKey:       "key",
+		APISecret:    "secret",
+	}, c.Exchanges[orderbook.Indodax])
+	c.Fees = orderbook.FeeMap{orderbook.Indodax: {Taker: d("0.003")}}
+	c.Log = Log{Level: "warn", Components: map[string]string{"binance": "debug"}}
+	c.FX.MaxAge = 10 * time.Second
+	c.Loop.Notional = d("500")
+	assert.Nil(t, c.Apply())
+
+	assert.Equal(t, orderbook.Symbols{
+		orderbook.ETH_USDT: {orderbook.Binance, orderbook.Indodax},
+		orderbook.ETH_IDR:  {orderbook.Indodax},
+	}, orderbook.SymbolMap)
+	assert.Equal(t, "http://localhost/depth", websocket.BinanceDepthURL)
+	assert.Equal(t, "http://localhost/api/", indodax.BaseURL)
+	assert.Equal(t, time.Minute, indodax.PollInterval)
+	// the adapters own their credentials
+	for key, expected := range map[orderbook.ExchangeKey]bool{orderbook.Binance: false, orderbook.Indodax: true} {
+		a, ok := orderbook.GetAdapter(key)
+		assert.True(t, ok)
+		assert.Equal(t, expected, a.(orderbook.Configurable).HasCredentials(), key)
+	}
+	assert.Equal(t, "0.003", orderbook.Fees.Taker(orderbook.Indodax, orderbook.ETH_IDR).String())
+	assert.Equal(t, 10*time.Second, fx.MaxAge)
+	assert.Equal(t, "15000", fx.Fallbacks[orderbook.USDT_IDR].String())
+	assert.Equal(t, "500", indodax.LoopNotional.String())
+	level, components := logging.Levels()
+	assert.Equal(t, logging.WarnLevel, level)
+	assert.Equal(t, map[string]logging.Level{"binance": logging.DebugLevel}, components)
+
+	c.FeesFile = "missing.json"
+	assert.NotNil(t, c.Apply())
+}
+
+func TestDump(t *testing.T) {
+	c := Default()
+	binance := c.Exchanges[orderbook.Binance]
+	binance.APIKey, binance.APISecret = "key", "secret"
+	c.Exchanges[orderbook.Binance] = binance
+
+	var out bytes.Buffer
+	assert.Nil(t, c.Dump(&out))
+	assert.Contains(t, out.String(), "api_key: key")
+	assert.NotContains(t, out.String(), "secret\n")
+	assert.Contains(t, out.String(), "poll_interval: 5s")
+	assert.Equal(t, "secret", c.Exchanges[orderbook.Binance].APISecret)
+
+	// what is dumped loads back
+	path := write(t, out.String())
+	defer os.RemoveAll(filepath.Dir(path))
+	loaded, err := Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, c.Exchanges[orderbook.Binance].Symbols, loaded.Exchanges[orderbook.Binance].Symbols)
+	assert.Equal(t, c.Exchanges[orderbook.Indodax], loaded.Exchanges[orderbook.Indodax])
+}
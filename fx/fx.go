@@ -0,0 +1,246 @@
+// Package fx converts prices between the quote currencies of the
+// exchanges, e.g. IDR on indodax and USDT or USDC on binance
+package fx
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	// Fallbacks are the rates used when no live book gives one,
+	// keyed by pair: one unit of the left currency is worth Rate
+	// units of the right one
+	Fallbacks = map[orderbook.Symbol]decimal.Decimal{
+		"USDC/USDT": decimal.New(1, 0),
+	}
+
+	// MaxAge is how long the rate of a book is used after its last update
+	MaxAge = time.Minute
+)
+
+// Rate is the price of one unit of From in To
+type Rate struct {
+	From, To string
+	Rate     decimal.Decimal
+	Live     bool // Derived from a book, not from Fallbacks
+	Time     time.Time
+}
+
+// Service derives the conversion rates from the mid price of the live
+// books, falling back to Fallbacks when a book is missing or stale
+type Service struct {
+	rates map[orderbook.Symbol]Rate
+	now   func() time.Time
+	mu    sync.RWMutex
+	quit  chan struct{}
+	done  chan struct{}
+}
+
+// NewService creates a service without any live rate yet
+func NewService() *Service {
+	return &Service{
+		rates: make(map[orderbook.Symbol]Rate),
+		now:   time.Now,
+		quit:  make(chan struct{}),
+	}
+}
+
+// Start reads the rates of every book and keeps them updated
+func (s *Service) Start() {
+	updates := orderbook.SubscribeUpdates()
+	for _, ex := range orderbook.Exchanges {
+		for symbol, book := range ex.Books {
+			s.Update(symbol, book)
+		}
+	}
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		defer orderbook.UnsubscribeUpdates(updates)
+		for {
+			select {
+			case update := <-updates:
+				if book, ok := orderbook.Exchanges[update.Exchange].Books[update.Symbol]; ok {
+					s.Update(update.Symbol, book)
+				}
+			case <-s.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops updating the rates
+func (s *Service) Stop() {
+	close(s.quit)
+	<-s.done
+}
+
+// Update sets the rate of a symbol to the mid price of its book,
+// the rate is left to go stale when the book is empty
+func (s *Service) Update(symbol orderbook.Symbol, book *orderbook.OrderBook) {
+	bid, ask, ok := book.BBO()
+	if !ok {
+		return
+	}
+	mid := bid.Price.Add(ask.Price).Div(decimal.New(2, 0))
+	s.SetRate(symbol, mid)
+}
+
+// SetRate sets the live rate of a symbol
+func (s *Service) SetRate(symbol orderbook.Symbol, rate decimal.Decimal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rates[symbol] = Rate{
+		From: orderbook.GetLeftCurrency(string(symbol)),
+		To:   orderbook.GetRightCurrency(string(symbol)),
+		Rate: rate,
+		Live: true,
+		Time: s.now(),
+	}
+}
+
+// Rate returns the price of one unit of from in to, directly from
+// a pair of the two currencies or through a third quote currency
+func (s *Service) Rate(from, to string) (Rate, error) {
+	if from == to {
+		return Rate{From: from, To: to, Rate: decimal.New(1, 0), Live: true, Time: s.now()}, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if r, ok := s.direct(from, to); ok {
+		return r, nil
+	}
+	for _, via := range s.pivots() {
+		if via == from || via == to {
+			continue
+		}
+		first, ok := s.direct(from, via)
+		if !ok {
+			continue
+		}
+		second, ok := s.direct(via, to)
+		if !ok {
+			continue
+		}
+		r := Rate{
+			From: from,
+			To:   to,
+			Rate: first.Rate.Mul(second.Rate),
+			Live: first.Live && second.Live,
+			Time: first.Time,
+		}
+		if second.Time.Before(r.Time) {
+			r.Time = second.Time
+		}
+		return r, nil
+	}
+	return Rate{}, fmt.Errorf("no rate from %v to %v", from, to)
+}
+
+// Convert converts an amount of from to to, directly or through a
+// third quote currency, it can be used as an orderbook.QuoteConverter
+func (s *Service) Convert(amount decimal.Decimal, from, to string) (decimal.Decimal, bool) {
+	if from == to {
+		return amount, true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if converted, ok := s.convert(amount, from, to); ok {
+		return converted, true
+	}
+	for _, via := range s.pivots() {
+		if via == from || via == to {
+			continue
+		}
+		if converted, ok := s.convert(amount, from, via); ok {
+			if converted, ok = s.convert(converted, via, to); ok {
+				return converted, true
+			}
+		}
+	}
+	return decimal.Zero, false
+}
+
+// ConvertOrder returns the order with its price, quoted in the right
+// currency of symbol, converted to the reference currency
+func (s *Service) ConvertOrder(o orderbook.Order, symbol orderbook.Symbol, reference string) (orderbook.Order, error) {
+	quote := orderbook.GetRightCurrency(string(symbol))
+	if quote == reference {
+		return o, nil
+	}
+	price, ok := s.Convert(o.Price, quote, reference)
+	if !ok {
+		return o, fmt.Errorf("no rate from %v to %v", quote, reference)
+	}
+	o.Price = price
+	return o, nil
+}
+
+// convert converts with the from/to or to/from pair, dividing by
+// the rate of the inverse pair rather than multiplying by its inverse
+func (s *Service) convert(amount decimal.Decimal, from, to string) (decimal.Decimal, bool) {
+	if r, ok := s.lookup(orderbook.Symbol(from + "/" + to)); ok {
+		return amount.Mul(r.Rate), true
+	}
+	if r, ok := s.lookup(orderbook.Symbol(to + "/" + from)); ok {
+		return amount.Div(r.Rate), true
+	}
+	return decimal.Zero, false
+}
+
+// direct returns the rate of the from/to or to/from pair
+func (s *Service) direct(from, to string) (Rate, bool) {
+	if r, ok := s.lookup(orderbook.Symbol(from + "/" + to)); ok {
+		return r, true
+	}
+	if r, ok := s.lookup(orderbook.Symbol(to + "/" + from)); ok {
+		return Rate{From: from, To: to, Rate: decimal.New(1, 0).Div(r.Rate), Live: r.Live, Time: r.Time}, true
+	}
+	return Rate{}, false
+}
+
+// lookup returns the live rate of a pair when it is recent enough,
+// its fallback otherwise
+func (s *Service) lookup(symbol orderbook.Symbol) (Rate, bool) {
+	if r, ok := s.rates[symbol]; ok && s.now().Sub(r.Time) <= MaxAge && r.Rate.IsPositive() {
+		return r, true
+	}
+	if rate, ok := Fallbacks[symbol]; ok && rate.IsPositive() {
+		return Rate{
+			From: orderbook.GetLeftCurrency(string(symbol)),
+			To:   orderbook.GetRightCurrency(string(symbol)),
+			Rate: rate,
+			Time: s.now(),
+		}, true
+	}
+	return Rate{}, false
+}
+
+// pivots lists the currencies a conversion may go through: the quote
+// currencies of the pairs with a live rate or a fallback. The assets
+// traded are left out, converting IDR to USDT through ETH would rate
+// it with the very books the detector compares.
+func (s *Service) pivots() (list []string) {
+	seen := make(map[string]bool)
+	add := func(symbol orderbook.Symbol) {
+		if c := orderbook.GetRightCurrency(string(symbol)); !seen[c] {
+			seen[c] = true
+			list = append(list, c)
+		}
+	}
+	for symbol := range s.rates {
+		add(symbol)
+	}
+	for symbol := range Fallbacks {
+		add(symbol)
+	}
+	sort.Strings(list)
+	return list
+}
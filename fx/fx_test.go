@@ -0,0 +1,92 @@
+package fx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+var d = decimal.RequireFromString
+
+func TestConvert(t *testing.T) {
+	s := NewService()
+	book := orderbook.NewOrderBook()
+	book.AddBuy(orderbook.Order{Price: d("14900"), Qty: d("1000")})
+	book.AddSell(orderbook.Order{Price: d("15100"), Qty: d("1000")})
+	s.Update(orderbook.USDT_IDR, book)
+
+	idr, ok := s.Convert(d("2"), "USDT", "IDR")
+	assert.True(t, ok)
+	assert.Equal(t, "30000", idr.String())
+
+	usdt, ok := s.Convert(d("3000000"), "IDR", "USDT")
+	assert.True(t, ok)
+	assert.Equal(t, "200", usdt.String())
+
+	// through USDT, with the USDC/USDT fallback
+	usdc, ok := s.Convert(d("3000000"), "IDR", "USDC")
+	assert.True(t, ok)
+	assert.Equal(t, "200", usdc.String())
+
+	_, ok = s.Convert(d("1"), "IDR", "EUR")
+	assert.False(t, ok)
+
+	o, err := s.ConvertOrder(orderbook.Order{Price: d("3000000"), Qty: d("2")}, orderbook.ETH_IDR, "USDT")
+	assert.Nil(t, err)
+	assert.Equal(t, "200", o.Price.String())
+	assert.Equal(t, "2", o.Qty.String())
+}
+
+func TestStaleRate(t *testing.T) {
+	now := time.Now()
+	s := NewService()
+	s.now = func() time.Time { return now }
+	s.SetRate(orderbook.USDT_IDR, d("15000"))
+	s.SetRate("USDC/USDT", d("1.01"))
+
+	r, err := s.Rate("USDC", "USDT")
+	assert.Nil(t, err)
+	assert.True(t, r.Live)
+	assert.Equal(t, "1.01", r.Rate.String())
+
+	r, err = s.Rate("USDC", "IDR")
+	assert.Nil(t, err)
+	assert.True(t, r.Live)
+	assert.Equal(t, "15150", r.Rate.String())
+
+	// the live rates expire, only the fallback is left
+	now = now.Add(MaxAge + time.Second)
+	r, err = s.Rate("USDC", "USDT")
+	assert.Nil(t, err)
+	assert.False(t, r.Live)
+	assert.Equal(t, "1", r.Rate.String())
+
+	_, err = s.Rate("USDT", "IDR")
+	assert.NotNil(t, err)
+}
+
+func TestNoPivotThroughAssets(t *testing.T) {
+	now := time.Now()
+	s := NewService()
+	s.now = func() time.Time { return now }
+	s.SetRate(orderbook.USDT_IDR, d("15000"))
+	now = now.Add(MaxAge + time.Second)
+	// the books the detector compares don't rate IDR
+	s.SetRate(orderbook.ETH_IDR, d("3150000"))
+	s.SetRate(orderbook.ETH_USDT, d("200"))
+
+	_, err := s.Rate("IDR", "USDT")
+	assert.NotNil(t, err)
+	_, ok := s.Convert(d("3150000"), "IDR", "USDT")
+	assert.False(t, ok)
+
+	// a fallback rates it again
+	Fallbacks[orderbook.USDT_IDR] = d("14000")
+	defer delete(Fallbacks, orderbook.USDT_IDR)
+	usdt, ok := s.Convert(d("3150000"), "IDR", "USDT")
+	assert.True(t, ok)
+	assert.Equal(t, "225", usdt.String())
+}
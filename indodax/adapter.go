@@ -0,0 +1,223 @@
+package indodax
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/metrics"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/orders"
+	"github.com/anthonychristian/crypto-arbitrage/recorder"
+	"github.com/anthonychristian/crypto-arbitrage/rest"
+	"github.com/shopspring/decimal"
+)
+
+// PollInterval is how often the depth of every symbol is polled
+var PollInterval = 5 * time.Second
+
+// Adapter plugs indodax into orderbook.Exchanges.
+// Indodax has no websocket API, so every subscribed symbol
+// is polled from the depth endpoint and pushed to the worker.
+//
+// It is also the orders.Executor of indodax, trading
+// through the trade API, see executor.go.
+type Adapter struct {
+	api        *IndodaxAPI
+	worker     *Worker
+	updates    chan orderbook.BookUpdate
+	quit       chan struct{}
+	polls      sync.WaitGroup
+	executions chan orders.Execution
+	live       map[string]*live // By client_order_id
+	tracking   sync.WaitGroup
+	mu         sync.Mutex
+
+	key, secret string // Credentials of the trade API
+}
+
+func init() {
+	orderbook.RegisterAdapter(NewAdapter())
+}
+
+// NewAdapter creates a new indodax adapter
+func NewAdapter() *Adapter {
+	return &Adapter{
+		updates: make(chan orderbook.BookUpdate, 256),
+	}
+}
+
+func (a *Adapter) Key() orderbook.ExchangeKey {
+	return orderbook.Indodax
+}
+
+// Names of the endpoints of the configuration
+const (
+	APIEndpoint   = "api"   // Base URL of the public API, BaseURL
+	TradeEndpoint = "trade" // URL of the private trade API, TradeURL
+)
+
+// Defaults returns the symbols, the endpoints and the poll
+// interval indodax runs with by default
+func (a *Adapter) Defaults() orderbook.AdapterConfig {
+	return orderbook.AdapterConfig{
+		Symbols: []orderbook.Symbol{orderbook.ETH_IDR, orderbook.USDT_IDR},
+		URLs: map[string]string{
+			APIEndpoint:   BaseURL,
+			TradeEndpoint: TradeURL,
+		},
+		PollInterval: PollInterval,
+	}
+}
+
+// Configure sets the endpoints, the poll interval, and the
+// credentials of the trade API, set on the API once connected
+func (a *Adapter) Configure(cfg orderbook.AdapterConfig) error {
+	if u, ok := cfg.URLs[APIEndpoint]; ok {
+		BaseURL = u
+	}
+	if u, ok := cfg.URLs[TradeEndpoint]; ok {
+		TradeURL = u
+	}
+	if cfg.PollInterval > 0 {
+		PollInterval = cfg.PollInterval
+	}
+	a.key, a.secret = cfg.APIKey, cfg.APISecret
+	return nil
+}
+
+// HasCredentials tells whether the trade API is given credentials
+func (a *Adapter) HasCredentials() bool {
+	return a.key != "" && a.secret != ""
+}
+
+// Connect initializes the API gateway, the worker applying the depths
+// and the polls of the executions of the orders
+func (a *Adapter) Connect() error {
+	a.quit = make(chan struct{})
+	a.api = InitIndodax()
+	a.api.SetCredentials(a.key, a.secret)
+	a.worker = InitWorker()
+	a.executions = make(chan orders.Execution, 256)
+	a.live = make(map[string]*live)
+	a.tracking.Add(1)
+	go a.track()
+	a.worker.updates = a.updates
+	loops := a.worker.loops
+	go logLoops(loops)
+	metrics.SetQueue("indodax updates", func() int { return len(a.updates) })
+	metrics.SetQueue("indodax loops", func() int { return len(loops) })
+	return nil
+}
+
+// logLoops logs the profitable loops until the worker is stopped
+func logLoops(loops <-chan Loop) {
+	for l := range loops {
+		if !l.Filled || !l.Profit.IsPositive() {
+			continue
+		}
+		log.Info("Profitable arbitrage loop",
+			"direction", l.Direction,
+			"notional", l.Notional,
+			"rate", l.Rate,
+			"profit", l.Profit,
+		)
+	}
+}
+
+// Subscribe starts polling the depth of every symbol
+func (a *Adapter) Subscribe(symbols ...orderbook.Symbol) error {
+	for _, symbol := range symbols {
+		a.polls.Add(1)
+		go a.poll(symbol)
+	}
+	return nil
+}
+
+// poll pushes the depth of a symbol to the worker every PollInterval.
+// After a failure the polls are skipped for the delay of the Retry
+// policy, and stopped for good when indodax doesn't list the symbol.
+func (a *Adapter) poll(symbol orderbook.Symbol) {
+	defer a.polls.Done()
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+	var (
+		failures int
+		resume   time.Time // Polls are skipped until then
+	)
+	for {
+		select {
+		case <-ticker.C:
+			start := time.Now()
+			if start.Before(resume) {
+				continue
+			}
+			d, err := a.api.GetDepth(pairName(symbol))
+			metrics.PollDuration.WithLabelValues(string(orderbook.Indodax), string(symbol)).Observe(time.Since(start).Seconds())
+			if _, invalid := err.(*rest.InvalidSymbol); invalid {
+				log.Error("stopping the polls", "symbol", symbol, "err", err)
+				return
+			}
+			if err != nil {
+				failures++
+				delay, retry := a.api.Retry.Delay(err, failures)
+				if retry {
+					resume = start.Add(delay)
+				}
+				log.Warn("depth poll failed", "symbol", symbol, "failures", failures, "delay", delay, "err", err)
+				continue
+			}
+			failures = 0
+			if !d.IsEmpty() {
+				metrics.DepthEvents.WithLabelValues(string(orderbook.Indodax), string(symbol)).Inc()
+				recorder.Save(orderbook.Indodax, symbol, recorder.Snapshot, d)
+			}
+			a.worker.PushDepthUpdate(symbol, d)
+		case <-a.quit:
+			return
+		}
+	}
+}
+
+func (a *Adapter) Updates() <-chan orderbook.BookUpdate {
+	return a.updates
+}
+
+// Snapshot fetches the current depth of a symbol, retrying
+// with the Retry policy of the API
+func (a *Adapter) Snapshot(symbol orderbook.Symbol) (orderbook.BookUpdate, error) {
+	var d Depth
+	err := a.api.Retry.Do(context.Background(), func() (err error) {
+		d, err = a.api.GetDepth(pairName(symbol))
+		return err
+	})
+	if err != nil {
+		return orderbook.BookUpdate{}, err
+	}
+	return depthUpdate(symbol, d)
+}
+
+// Fee returns the taker rate of a symbol
+func (a *Adapter) Fee(symbol orderbook.Symbol) decimal.Decimal {
+	return orderbook.Fees.Taker(orderbook.Indodax, symbol)
+}
+
+// Close stops polling every symbol and the executions, then stops
+// the worker once the depths polled have been applied. It does
+// nothing when the adapter was never connected or is closed already.
+func (a *Adapter) Close() error {
+	if a.quit == nil {
+		return nil
+	}
+	select {
+	case <-a.quit:
+		return nil
+	default:
+	}
+	close(a.quit)
+	a.polls.Wait()
+	a.tracking.Wait()
+	close(a.executions)
+	a.worker.stop()
+	return nil
+}
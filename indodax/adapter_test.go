@@ -0,0 +1,62 @@
+package indodax
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/lifecycle"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdapterClose(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/eth_idr/depth", r.URL.Path)
+		w.Write([]byte(`{"buy":[[3000000,"1"]],"sell":[[3010000,"1"]]}`))
+	}))
+	exchanges, baseURL, interval := orderbook.Exchanges, BaseURL, PollInterval
+	orderbook.Exchanges = orderbook.ExchangeMap{orderbook.Indodax: {
+		Books: orderbook.OrderBookMap{orderbook.ETH_IDR: orderbook.NewOrderBook()},
+	}}
+	BaseURL, PollInterval = server.URL+"/", 10*time.Millisecond
+	defer func() { orderbook.Exchanges, BaseURL, PollInterval = exchanges, baseURL, interval }()
+
+	goroutines := runtime.NumGoroutine()
+	a := NewAdapter()
+	assert.Nil(t, a.Connect())
+	assert.Nil(t, a.Subscribe(orderbook.ETH_IDR))
+	update := <-a.Updates()
+	assert.Equal(t, orderbook.ETH_IDR, update.Symbol)
+
+	assert.Nil(t, a.Close())
+	// the worker closes its loops once stopped
+	for range a.worker.Loops() {
+	}
+	assert.Nil(t, a.Close())
+	server.Close()
+	assert.Zero(t, lifecycle.Leaked(goroutines, time.Second))
+}
+
+func TestCloseNotConnected(t *testing.T) {
+	assert.Nil(t, NewAdapter().Close())
+}
+
+func TestPollInvalidSymbol(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error": "invalid_pair", "error_description": "Invalid Pair"}`))
+	}))
+	defer server.Close()
+	baseURL, interval := BaseURL, PollInterval
+	BaseURL, PollInterval = server.URL+"/", time.Millisecond
+	defer func() { BaseURL, PollInterval = baseURL, interval }()
+
+	a := NewAdapter()
+	assert.Nil(t, a.Connect())
+	defer a.Close()
+	assert.Nil(t, a.Subscribe("ABC/IDR"))
+	// the poll stops by itself
+	a.polls.Wait()
+}
@@ -1,8 +1,17 @@
 package indodax
 
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Depth is the response of the depth endpoint, every level is a
+// [price, qty] pair kept as json.Number so no precision is lost
 type Depth struct {
-	Buy  [][]interface{} `json:"buy"`
-	Sell [][]interface{} `json:"sell"`
+	Buy  [][]json.Number `json:"buy"`
+	Sell [][]json.Number `json:"sell"`
 }
 
 type DepthPair struct {
@@ -15,3 +24,67 @@ type DepthPair struct {
 func (d *Depth) IsEmpty() bool {
 	return len(d.Buy) == 0 && len(d.Sell) == 0
 }
+
+// Info is the account information returned by getInfo
+type Info struct {
+	ServerTime  int64                      `json:"server_time"`
+	Balance     map[string]decimal.Decimal `json:"balance"`      // Available balance of each currency
+	BalanceHold map[string]decimal.Decimal `json:"balance_hold"` // Balance held by the open orders
+	UserID      string                     `json:"user_id"`
+	Name        string                     `json:"name"`
+	Email       string                     `json:"email"`
+}
+
+// TradeRequest are the parameters of trade
+type TradeRequest struct {
+	Pair          string          // e.g. eth_idr
+	Type          string          // buy or sell
+	Price         decimal.Decimal // Limit price, unused by market orders
+	Amount        decimal.Decimal // IDR to spend when buying, coins to sell when selling
+	OrderType     string          // limit when empty, or market
+	ClientOrderID string
+}
+
+// TradeResult is the response of trade
+type TradeResult struct {
+	OrderID string
+	Receive decimal.Decimal // Received right away, in the currency bought
+	Spend   decimal.Decimal // Spent right away, in the currency sold
+	Remain  decimal.Decimal // Left on the book, in the currency sold
+	Fee     decimal.Decimal
+	Balance map[string]decimal.Decimal
+}
+
+// CancelResult is the response of cancelOrder
+type CancelResult struct {
+	OrderID string                     `json:"-"`
+	Type    string                     `json:"type"`
+	Pair    string                     `json:"pair"`
+	Balance map[string]decimal.Decimal `json:"balance"`
+}
+
+// OrderInfo is an order returned by openOrders and orderHistory
+type OrderInfo struct {
+	OrderID       string
+	ClientOrderID string
+	Type          string // buy or sell
+	Price         decimal.Decimal
+	Currency      string          // Currency of Amount and Remain, IDR for buys and the coin for sells
+	Amount        decimal.Decimal // Amount of the order
+	Remain        decimal.Decimal // Amount left on the book
+	Status        string          // open, filled or cancelled, only set by orderHistory
+	SubmitTime    time.Time
+	FinishTime    time.Time
+}
+
+// Trade is a fill returned by tradeHistory
+type Trade struct {
+	TradeID       string
+	OrderID       string
+	ClientOrderID string
+	Type          string
+	Price         decimal.Decimal
+	Qty           decimal.Decimal // Coins traded
+	Fee           decimal.Decimal
+	Time          time.Time
+}
@@ -0,0 +1,191 @@
+package indodax
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/orders"
+	"github.com/shopspring/decimal"
+)
+
+// ExecutionPollInterval is how often the open orders
+// and the trades of the live orders are polled
+var ExecutionPollInterval = 2 * time.Second
+
+// tradeHistoryCount is how many of the last trades of a pair are polled
+const tradeHistoryCount = 100
+
+var _ orders.Executor = (*Adapter)(nil)
+
+// live is an order sent through the trade API
+// and the trades already reported for it
+type live struct {
+	order  orders.Order
+	id     string // Order id given by indodax
+	pair   string
+	filled decimal.Decimal
+	trades map[string]bool
+}
+
+// Submit sends an order with the trade API. Indodax only has limit
+// orders resting until cancelled and market orders, so the other
+// types and times in force are refused.
+func (a *Adapter) Submit(o orders.Order) (string, error) {
+	r := TradeRequest{
+		Pair:          pairName(o.Symbol),
+		Type:          string(o.Side),
+		ClientOrderID: o.ClientOrderID,
+	}
+	switch o.Type {
+	case orders.Limit:
+		if o.TimeInForce != orders.GTC {
+			return "", fmt.Errorf("indodax does not support %v limit orders", o.TimeInForce)
+		}
+		r.Price = *o.LimitPrice
+	case orders.Market:
+		r.OrderType = "market"
+	default:
+		return "", fmt.Errorf("indodax does not support %v orders", o.Type)
+	}
+
+	// buys spend the quote currency, sells the coins
+	r.Amount = o.Qty
+	if o.Side == orders.Buy {
+		notional, err := a.notional(o)
+		if err != nil {
+			return "", err
+		}
+		r.Amount = notional
+	}
+
+	result, err := a.api.Trade(r)
+	if err != nil {
+		return "", err
+	}
+	a.mu.Lock()
+	a.live[o.ClientOrderID] = &live{
+		order:  o,
+		id:     result.OrderID,
+		pair:   r.Pair,
+		trades: make(map[string]bool),
+	}
+	a.mu.Unlock()
+	return result.OrderID, nil
+}
+
+// notional is the quote currency a buy order spends: its limit
+// price times its quantity, or the cost of filling it now
+func (a *Adapter) notional(o orders.Order) (decimal.Decimal, error) {
+	if o.LimitPrice != nil {
+		return o.LimitPrice.Mul(o.Qty), nil
+	}
+	var book *orderbook.OrderBook
+	if exchange, ok := orderbook.Exchanges[orderbook.Indodax]; ok {
+		book = exchange.Books[o.Symbol]
+	}
+	if book == nil {
+		return decimal.Zero, fmt.Errorf("no %v book to price the order with", o.Symbol)
+	}
+	fill := book.FillBuy(o.Qty)
+	if !fill.Filled() {
+		return decimal.Zero, fmt.Errorf("not enough liquidity to price the order")
+	}
+	return fill.Notional, nil
+}
+
+// Cancel asks indodax to cancel an order, its cancellation
+// is reported once it leaves the open orders
+func (a *Adapter) Cancel(o orders.Order) error {
+	_, err := a.api.CancelOrder(pairName(o.Symbol), o.ExchangeOrderID, string(o.Side))
+	return err
+}
+
+// Executions reports the trades of the orders sent by Submit, and
+// their cancellation when they leave the open orders unfilled
+func (a *Adapter) Executions() <-chan orders.Execution {
+	return a.executions
+}
+
+// track polls the executions of the live orders every ExecutionPollInterval
+func (a *Adapter) track() {
+	defer a.tracking.Done()
+	ticker := time.NewTicker(ExecutionPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.pollExecutions()
+		case <-a.quit:
+			return
+		}
+	}
+}
+
+// pollExecutions reports the new trades of the live orders, then
+// cancels those that left the open orders before being filled. The
+// open orders are polled first, so that the trades of an order filled
+// in between are in the trades polled after.
+func (a *Adapter) pollExecutions() {
+	pairs := make(map[string][]*live)
+	a.mu.Lock()
+	for _, l := range a.live {
+		pairs[l.pair] = append(pairs[l.pair], l)
+	}
+	a.mu.Unlock()
+
+	for pair, lives := range pairs {
+		open, err := a.api.OpenOrders(pair)
+		if err != nil {
+			log.Warn("open orders poll failed", "pair", pair, "err", err)
+			continue
+		}
+		trades, err := a.api.TradeHistory(pair, tradeHistoryCount)
+		if err != nil {
+			log.Warn("trade history poll failed", "pair", pair, "err", err)
+			continue
+		}
+		isOpen := make(map[string]bool, len(open))
+		for _, o := range open {
+			isOpen[o.OrderID] = true
+		}
+		for _, l := range lives {
+			// the last trades come first
+			for i := len(trades) - 1; i >= 0; i-- {
+				t := trades[i]
+				if t.OrderID != l.id || l.trades[t.TradeID] {
+					continue
+				}
+				l.trades[t.TradeID] = true
+				l.filled = l.filled.Add(t.Qty)
+				a.report(orders.Execution{
+					ClientOrderID: l.order.ClientOrderID,
+					Qty:           t.Qty,
+					Price:         t.Price,
+					Time:          t.Time,
+				})
+			}
+			if isOpen[l.id] {
+				continue
+			}
+			if l.filled.LessThan(l.order.Qty) {
+				a.report(orders.Execution{
+					ClientOrderID: l.order.ClientOrderID,
+					Status:        orders.Cancelled,
+					Time:          time.Now(),
+				})
+			}
+			a.mu.Lock()
+			delete(a.live, l.order.ClientOrderID)
+			a.mu.Unlock()
+		}
+	}
+}
+
+// report sends an execution unless the adapter is closing
+func (a *Adapter) report(e orders.Execution) {
+	select {
+	case a.executions <- e:
+	case <-a.quit:
+	}
+}
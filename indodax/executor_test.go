@@ -0,0 +1,97 @@
+package indodax
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/orders"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecutor(t *testing.T) {
+	responses := map[string]string{
+		"trade": `{"success": 1, "return": {
+			"receive_eth": "0", "spend_rp": 0, "fee": 0, "remain_rp": "1500000",
+			"order_id": 11560, "balance": {"idr": "3500000"}
+		}}`,
+		"openOrders": `{"success": 1, "return": {"orders": [{
+			"order_id": "11560", "client_order_id": "a", "submit_time": "1578304294",
+			"price": "3000000", "type": "buy", "order_idr": "1500000", "remain_idr": "1200000"
+		}]}}`,
+		"tradeHistory": `{"success": 1, "return": {"trades": [{
+			"trade_id": "43", "order_id": "11561", "type": "sell", "eth": "1",
+			"price": "3010000", "fee": "0", "trade_time": "1578304290"
+		}, {
+			"trade_id": "42", "order_id": "11560", "type": "buy", "eth": "0.1",
+			"price": "3000000", "fee": "900", "trade_time": "1578304295"
+		}]}}`,
+		"cancelOrder": `{"success": 1, "return": {"order_id": 11560, "type": "buy", "pair": "eth_idr", "balance": {"idr": "4700000"}}}`,
+	}
+	server, requests := tapiServer(t, responses)
+	defer server.Close()
+	tradeURL, interval := TradeURL, ExecutionPollInterval
+	TradeURL, ExecutionPollInterval = server.URL, time.Hour
+	defer func() { TradeURL, ExecutionPollInterval = tradeURL, interval }()
+
+	a := NewAdapter()
+	assert.Nil(t, a.Connect())
+	a.api.SetCredentials("key", "secret")
+
+	price := d("3000000")
+	o := orders.Order{Request: orders.Request{
+		ClientOrderID: "a",
+		Symbol:        orderbook.ETH_IDR,
+		Exchange:      orderbook.Indodax,
+		Qty:           d("0.5"),
+		Side:          orders.Buy,
+		Type:          orders.Limit,
+		TimeInForce:   orders.GTC,
+		LimitPrice:    &price,
+	}}
+	id, err := a.Submit(o)
+	assert.Nil(t, err)
+	assert.Equal(t, "11560", id)
+	sent := (*requests)[0]
+	assert.Equal(t, "trade", sent.Get("method"))
+	assert.Equal(t, "buy", sent.Get("type"))
+	assert.Equal(t, "1500000", sent.Get("idr"))
+	assert.Equal(t, "3000000", sent.Get("price"))
+	assert.Equal(t, "a", sent.Get("client_order_id"))
+
+	// the trades of the other orders are not reported, nor twice
+	a.pollExecutions()
+	a.pollExecutions()
+	assert.Len(t, a.executions, 1)
+	fill := <-a.Executions()
+	assert.Equal(t, "a", fill.ClientOrderID)
+	assert.Equal(t, "0.1", fill.Qty.String())
+	assert.Equal(t, "3000000", fill.Price.String())
+	assert.Equal(t, int64(1578304295), fill.Time.Unix())
+
+	o.ExchangeOrderID = id
+	assert.Nil(t, a.Cancel(o))
+	cancel := (*requests)[len(*requests)-1]
+	assert.Equal(t, "cancelOrder", cancel.Get("method"))
+	assert.Equal(t, "11560", cancel.Get("order_id"))
+	assert.Equal(t, "buy", cancel.Get("type"))
+
+	// the order left the open orders unfilled
+	responses["openOrders"] = `{"success": 1, "return": {"orders": []}}`
+	a.pollExecutions()
+	cancelled := <-a.Executions()
+	assert.Equal(t, orders.Cancelled, cancelled.Status)
+	assert.Empty(t, a.live)
+
+	// orders indodax doesn't have
+	o.ClientOrderID, o.TimeInForce = "b", orders.IOC
+	_, err = a.Submit(o)
+	assert.EqualError(t, err, "indodax does not support ioc limit orders")
+	o.Type, o.TimeInForce, o.StopPrice = orders.Stop, orders.GTC, &price
+	_, err = a.Submit(o)
+	assert.EqualError(t, err, "indodax does not support stop orders")
+
+	assert.Nil(t, a.Close())
+	_, open := <-a.Executions()
+	assert.False(t, open)
+}
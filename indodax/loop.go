@@ -0,0 +1,126 @@
+package indodax
+
+import (
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/shopspring/decimal"
+)
+
+// LoopNotional is the amount of USDT the loop is evaluated for,
+// read when the worker starts
+var LoopNotional = decimal.New(100, 0)
+
+// Loop directions, both starting and ending in USDT
+const (
+	// Buy ETH on binance, sell it for IDR on indodax, buy back USDT on indodax
+	EthIdrUsdt = "USDT -> ETH -> IDR -> USDT"
+	// Sell USDT for IDR on indodax, buy ETH on indodax, sell it on binance
+	IdrEthUsdt = "USDT -> IDR -> ETH -> USDT"
+)
+
+// Loop is the evaluation of one direction of the arbitrage loop
+type Loop struct {
+	Direction string
+	Notional  decimal.Decimal // USDT put in the loop
+	Return    decimal.Decimal // USDT out of the loop, net of fees
+	Rate      decimal.Decimal // Return / Notional
+	Profit    decimal.Decimal // Return - Notional
+	Filled    bool            // False when a book was too thin for the notional
+	Time      time.Time
+}
+
+// legs holds the depths of the books making up the loop
+type legs struct {
+	ethUsdt orderbook.Depth // Binance ETH/USDT
+	ethIdr  orderbook.Depth // Indodax ETH/IDR
+	usdtIdr orderbook.Depth // Indodax USDT/IDR
+}
+
+// loopLegs reads the legs from orderbook.Exchanges,
+// returning false when one of them is not maintained yet
+func loopLegs() (l legs, ok bool) {
+	ethUsdt := orderbook.Exchanges[orderbook.Binance].Books[orderbook.ETH_USDT]
+	ethIdr := orderbook.Exchanges[orderbook.Indodax].Books[orderbook.ETH_IDR]
+	usdtIdr := orderbook.Exchanges[orderbook.Indodax].Books[orderbook.USDT_IDR]
+	if ethUsdt == nil || ethIdr == nil || usdtIdr == nil {
+		return l, false
+	}
+	l.ethUsdt, l.ethIdr, l.usdtIdr = ethUsdt.Depth(0), ethIdr.Depth(0), usdtIdr.Depth(0)
+	for _, d := range []orderbook.Depth{l.ethUsdt, l.ethIdr, l.usdtIdr} {
+		if len(d.Bids) == 0 || len(d.Asks) == 0 {
+			return l, false
+		}
+	}
+	return l, true
+}
+
+// EvaluateLoops evaluates the loop on the books of orderbook.Exchanges,
+// returning false when one of its legs is not available yet
+func EvaluateLoops(notional decimal.Decimal, now time.Time) ([]Loop, bool) {
+	l, ok := loopLegs()
+	if !ok {
+		return nil, false
+	}
+	return evaluateLoops(l, notional, now), true
+}
+
+// evaluateLoops converts notional USDT around the loop in both directions,
+// the taker fees are those of the levels' FillCost
+func evaluateLoops(l legs, notional decimal.Decimal, now time.Time) []Loop {
+	// USDT -> ETH on binance asks, withdrawn to indodax, ETH -> IDR
+	// on indodax bids, IDR -> USDT on indodax asks, withdrawn to binance
+	eth, ok1 := buyWith(l.ethUsdt, notional)
+	eth = withdraw(orderbook.Binance, "ETH", eth)
+	idr, ok2 := sell(l.ethIdr, eth)
+	usdt, ok3 := buyWith(l.usdtIdr, idr)
+	usdt = withdraw(orderbook.Indodax, "USDT", usdt)
+	forward := newLoop(EthIdrUsdt, notional, usdt, ok1 && ok2 && ok3, now)
+
+	// USDT -> IDR on indodax bids, IDR -> ETH on indodax asks, withdrawn
+	// to binance, ETH -> USDT on binance bids, withdrawn to indodax
+	idr, ok1 = sell(l.usdtIdr, notional)
+	eth, ok2 = buyWith(l.ethIdr, idr)
+	eth = withdraw(orderbook.Indodax, "ETH", eth)
+	usdt, ok3 = sell(l.ethUsdt, eth)
+	usdt = withdraw(orderbook.Binance, "USDT", usdt)
+	backward := newLoop(IdrEthUsdt, notional, usdt, ok1 && ok2 && ok3, now)
+
+	return []Loop{forward, backward}
+}
+
+func newLoop(direction string, notional, ret decimal.Decimal, filled bool, now time.Time) Loop {
+	return Loop{
+		Direction: direction,
+		Notional:  notional,
+		Return:    ret,
+		Rate:      ret.Div(notional),
+		Profit:    ret.Sub(notional),
+		Filled:    filled,
+		Time:      now,
+	}
+}
+
+// buyWith spends quote on the asks, fees included, returning the base
+// bought and whether the book was deep enough to spend all of it
+func buyWith(d orderbook.Depth, quote decimal.Decimal) (base decimal.Decimal, filled bool) {
+	f := d.FillBuyQuote(quote)
+	return f.Qty, f.Filled()
+}
+
+// sell sells base on the bids, returning the quote received net of fees
+// and whether the book was deep enough to sell all of it
+func sell(d orderbook.Depth, base decimal.Decimal) (quote decimal.Decimal, filled bool) {
+	f := d.FillSell(base)
+	return f.Notional.Sub(f.Fees), f.Filled()
+}
+
+// withdraw returns what is left of amount once withdrawn from an
+// exchange over the asset's cheapest network
+func withdraw(key orderbook.ExchangeKey, asset string, amount decimal.Decimal) decimal.Decimal {
+	fee, ok := orderbook.Fees.Withdrawal(key, asset, "")
+	if !ok {
+		return amount
+	}
+	return decimal.Max(amount.Sub(fee), decimal.Zero)
+}
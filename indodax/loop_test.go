@@ -0,0 +1,106 @@
+package indodax
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+var d = decimal.RequireFromString
+
+func TestEvaluateLoops(t *testing.T) {
+	fees := orderbook.Fees
+	orderbook.Fees = orderbook.FeeMap{}
+	defer func() { orderbook.Fees = fees }()
+
+	ethUsdt, ethIdr, usdtIdr := orderbook.NewOrderBook(), orderbook.NewOrderBook(), orderbook.NewOrderBook()
+	ethUsdt.AddBuy(orderbook.Order{Price: d("190"), Qty: d("10")})
+	ethUsdt.AddSell(orderbook.Order{Price: d("200"), Qty: d("0.25")})
+	ethUsdt.AddSell(orderbook.Order{Price: d("250"), Qty: d("10")})
+	ethIdr.AddBuy(orderbook.Order{Price: d("3000000"), Qty: d("10")})
+	ethIdr.AddSell(orderbook.Order{Price: d("3100000"), Qty: d("10")})
+	usdtIdr.AddBuy(orderbook.Order{Price: d("14000"), Qty: d("100000")})
+	usdtIdr.AddSell(orderbook.Order{Price: d("15000"), Qty: d("100000")})
+
+	l := legs{ethUsdt.Depth(0), ethIdr.Depth(0), usdtIdr.Depth(0)}
+	loops := evaluateLoops(l, d("100"), time.Now())
+	assert.Len(t, loops, 2)
+
+	// 50 USDT buys 0.25 ETH @ 200, the other 50 buy 0.2 ETH @ 250,
+	// 0.45 ETH sells for 1350000 IDR, buying back 90 USDT
+	forward := loops[0]
+	assert.Equal(t, EthIdrUsdt, forward.Direction)
+	assert.True(t, forward.Filled)
+	assert.Equal(t, "90", forward.Return.String())
+	assert.Equal(t, "-10", forward.Profit.String())
+
+	// 100 USDT sells for 1400000 IDR, buying 0.4516 ETH sold @ 190
+	backward := loops[1]
+	assert.Equal(t, IdrEthUsdt, backward.Direction)
+	assert.True(t, backward.Filled)
+	assert.True(t, d("1400000").Div(d("3100000")).Mul(d("190")).Equal(backward.Return))
+}
+
+func TestEvaluateLoopsThinBook(t *testing.T) {
+	ethUsdt, ethIdr, usdtIdr := orderbook.NewOrderBook(), orderbook.NewOrderBook(), orderbook.NewOrderBook()
+	ethUsdt.AddBuy(orderbook.Order{Price: d("190"), Qty: d("10")})
+	ethUsdt.AddSell(orderbook.Order{Price: d("200"), Qty: d("0.1")})
+	ethIdr.AddBuy(orderbook.Order{Price: d("3000000"), Qty: d("10")})
+	ethIdr.AddSell(orderbook.Order{Price: d("3100000"), Qty: d("10")})
+	usdtIdr.AddBuy(orderbook.Order{Price: d("14000"), Qty: d("100000")})
+	usdtIdr.AddSell(orderbook.Order{Price: d("15000"), Qty: d("100000")})
+
+	l := legs{ethUsdt.Depth(0), ethIdr.Depth(0), usdtIdr.Depth(0)}
+	loops := evaluateLoops(l, d("100"), time.Now())
+	assert.False(t, loops[0].Filled)
+	assert.True(t, loops[1].Filled)
+}
+
+func TestEvaluateLoopsWithdrawals(t *testing.T) {
+	fees := orderbook.Fees
+	orderbook.Fees = orderbook.FeeMap{
+		orderbook.Binance: {
+			Withdrawals: map[string]map[string]decimal.Decimal{
+				"ETH": {"ETH": d("0.05"), "BSC": d("0.1")},
+			},
+		},
+	}
+	defer func() { orderbook.Fees = fees }()
+
+	ethUsdt, ethIdr, usdtIdr := orderbook.NewOrderBook(), orderbook.NewOrderBook(), orderbook.NewOrderBook()
+	ethUsdt.AddBuy(orderbook.Order{Price: d("190"), Qty: d("10")})
+	ethUsdt.AddSell(orderbook.Order{Price: d("200"), Qty: d("0.25")})
+	ethUsdt.AddSell(orderbook.Order{Price: d("250"), Qty: d("10")})
+	ethIdr.AddBuy(orderbook.Order{Price: d("3000000"), Qty: d("10")})
+	ethIdr.AddSell(orderbook.Order{Price: d("3100000"), Qty: d("10")})
+	usdtIdr.AddBuy(orderbook.Order{Price: d("14000"), Qty: d("100000")})
+	usdtIdr.AddSell(orderbook.Order{Price: d("15000"), Qty: d("100000")})
+
+	// 0.45 ETH bought on binance, 0.4 are left to sell on indodax
+	l := legs{ethUsdt.Depth(0), ethIdr.Depth(0), usdtIdr.Depth(0)}
+	forward := evaluateLoops(l, d("100"), time.Now())[0]
+	assert.Equal(t, "80", forward.Return.String())
+}
+
+func TestEvaluateLoopsFees(t *testing.T) {
+	fees := orderbook.Fees
+	orderbook.Fees = orderbook.FeeMap{}
+	defer func() { orderbook.Fees = fees }()
+
+	ethUsdt, ethIdr, usdtIdr := orderbook.NewOrderBook(), orderbook.NewOrderBook(), orderbook.NewOrderBook()
+	ethUsdt.AddBuy(orderbook.Order{Price: d("190"), Qty: d("10")})
+	ethUsdt.AddSell(orderbook.Order{Price: d("200"), Qty: d("10"), FillCost: 1.25})
+	ethIdr.AddBuy(orderbook.Order{Price: d("3000000"), Qty: d("10"), FillCost: 1.5})
+	ethIdr.AddSell(orderbook.Order{Price: d("3100000"), Qty: d("10")})
+	usdtIdr.AddBuy(orderbook.Order{Price: d("14000"), Qty: d("100000")})
+	usdtIdr.AddSell(orderbook.Order{Price: d("15000"), Qty: d("100000")})
+
+	// 100 USDT buy 0.4 ETH @ 250 fees included, selling for 1200000 IDR
+	// of which 600000 are left net of fees, buying back 40 USDT
+	l := legs{ethUsdt.Depth(0), ethIdr.Depth(0), usdtIdr.Depth(0)}
+	forward := evaluateLoops(l, d("100"), time.Now())[0]
+	assert.Equal(t, "40", forward.Return.String())
+}
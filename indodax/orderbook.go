@@ -1,17 +1,19 @@
 package indodax
 
 import (
+	"strings"
+
 	"github.com/anthonychristian/crypto-arbitrage/orderbook"
 )
 
-var idxOrderBook *orderbook.OrderBook
-
-func InitOrderBook() {
-	if idxOrderBook == nil {
-		idxOrderBook = orderbook.NewOrderBook()
-	}
+// GetOB returns indodax's orderbook for the given symbol
+func GetOB(symbol orderbook.Symbol) *orderbook.OrderBook {
+	return orderbook.Exchanges[orderbook.Indodax].Books[symbol]
 }
 
-func GetOB() *orderbook.OrderBook {
-	return idxOrderBook
+// pairName converts a symbol to indodax's pair name, e.g. ETH/IDR -> eth_idr
+func pairName(symbol orderbook.Symbol) string {
+	left := orderbook.GetLeftCurrency(string(symbol))
+	right := orderbook.GetRightCurrency(string(symbol))
+	return strings.ToLower(left + "_" + right)
 }
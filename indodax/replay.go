@@ -0,0 +1,27 @@
+package indodax
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/recorder"
+)
+
+// ReplayDepth applies a recorded depth to the book of its symbol in
+// orderbook.Exchanges, the same way the polled depths are applied
+func ReplayDepth(rec recorder.Record) (orderbook.BookUpdate, error) {
+	book := GetOB(rec.Symbol)
+	if book == nil {
+		return orderbook.BookUpdate{}, fmt.Errorf("no indodax book for %v", rec.Symbol)
+	}
+	var d Depth
+	if err := json.Unmarshal(rec.Data, &d); err != nil {
+		return orderbook.BookUpdate{}, fmt.Errorf("indodax %v depth: %v", rec.Symbol, err)
+	}
+	update, err := updateDepth(rec.Symbol, book, d)
+	if err != nil {
+		return update, fmt.Errorf("indodax %v depth: %v", rec.Symbol, err)
+	}
+	return update, nil
+}
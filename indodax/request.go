@@ -5,47 +5,81 @@ package indodax
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
+	"net/http"
 
+	"github.com/anthonychristian/crypto-arbitrage/metrics"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/rest"
 	"github.com/parnurzeal/gorequest"
 )
 
-// base URL for the api gateway
-const (
-	baseURL  = "https://indodax.com/api/"
-	endpoint = "/depth"
+const endpoint = "/depth"
+
+var (
+	// BaseURL is the default base URL of the public API
+	BaseURL = "https://indodax.com/api/"
+	// TradeURL is the default URL of the private trade API
+	TradeURL = "https://indodax.com/tapi"
 )
 
-// IndodaxAPI serves the app for interacting with HTTP endpoints
-// req <- the request object
-// add some more functionalities in the future(maybe retries,
-// error handling, etc)
+// IndodaxAPI serves the app for interacting with HTTP endpoints.
+// Every request is built with its own agent, the symbols are
+// polled concurrently.
 type IndodaxAPI struct {
-	req *gorequest.SuperAgent
+	BaseURL  string      // Base URL of the public API
+	TradeURL string      // URL of the private trade API
+	Retry    rest.Policy // Policy of the requests safe to send again
+
+	key, secret string
+	nonce       int64 // Last nonce sent to the trade API
 }
 
 var IndodaxInstance *IndodaxAPI
 
 func InitIndodax() *IndodaxAPI {
 	IndodaxInstance = &IndodaxAPI{
-		req: gorequest.New(),
+		BaseURL:  BaseURL,
+		TradeURL: TradeURL,
+		Retry:    rest.DefaultPolicy,
 	}
 	return IndodaxInstance
 }
 
-func (i *IndodaxAPI) GetDepth(symbol string) (dat Depth) {
-	// check if symbol is valid
-	// build request
-	req, body, errs := i.req.Get(baseURL + symbol + endpoint).
+// GetDepth fetches the depth of a pair, e.g. eth_idr. The errors are
+// typed, see package rest: indodax answers unknown pairs with an
+// invalid_pair error, and is unavailable during its maintenances.
+func (i *IndodaxAPI) GetDepth(pair string) (Depth, error) {
+	var dat Depth
+	res, body, errs := gorequest.New().Get(i.BaseURL + pair + endpoint).
 		End()
-	if errs != nil || req.StatusCode != 200 {
-		//error handling here
-		return dat
+	if len(errs) > 0 {
+		metrics.HTTPErrors.WithLabelValues(string(orderbook.Indodax), "depth").Inc()
+		return dat, &rest.ExchangeUnavailable{Exchange: orderbook.Indodax, Endpoint: "depth", Err: errs[0]}
+	}
+	if res.StatusCode != http.StatusOK {
+		metrics.HTTPErrors.WithLabelValues(string(orderbook.Indodax), "depth").Inc()
+		if err := rest.StatusError(orderbook.Indodax, "depth", res); err != nil {
+			return dat, err
+		}
+		if res.StatusCode == http.StatusNotFound {
+			return dat, &rest.InvalidSymbol{Exchange: orderbook.Indodax, Symbol: pair}
+		}
+		return dat, &rest.ExchangeUnavailable{Exchange: orderbook.Indodax, Endpoint: "depth", Status: res.StatusCode}
+	}
+
+	var failure struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if json.Unmarshal([]byte(body), &failure) == nil && failure.Error != "" {
+		if errorCode(failure.Error, failure.Description) == CodeInvalidPair {
+			return dat, &rest.InvalidSymbol{Exchange: orderbook.Indodax, Symbol: pair}
+		}
+		return dat, &rest.ExchangeUnavailable{Exchange: orderbook.Indodax, Endpoint: "depth", Err: errors.New(failure.Error)}
 	}
-	err := json.Unmarshal([]byte(body), &dat)
-	if err != nil {
-		fmt.Println("error when unmarshalling the body")
-		return dat
+	if err := json.Unmarshal([]byte(body), &dat); err != nil {
+		return dat, &rest.DecodeError{Exchange: orderbook.Indodax, Endpoint: "depth", Err: err}
 	}
-	return dat
+	return dat, nil
 }
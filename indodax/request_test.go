@@ -1,8 +1,15 @@
 package indodax
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/rest"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -15,8 +22,8 @@ func (suite *RequestTestSuite) SetupTest() {
 }
 
 func (suite *RequestTestSuite) TestGetDepth() {
-	d := IndodaxInstance.getDepth("eth_idr")
-	if d.IsEmpty() {
+	d, err := IndodaxInstance.GetDepth("eth_idr")
+	if err != nil || d.IsEmpty() {
 		suite.T().Fail()
 		return
 	}
@@ -25,3 +32,62 @@ func (suite *RequestTestSuite) TestGetDepth() {
 func TestRequestTestSuite(t *testing.T) {
 	suite.Run(t, new(RequestTestSuite))
 }
+
+func TestGetDepthErrors(t *testing.T) {
+	var status int
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	api := InitIndodax()
+	api.BaseURL = server.URL + "/"
+
+	status, body = http.StatusTooManyRequests, ""
+	_, err := api.GetDepth("eth_idr")
+	assert.Equal(t, &rest.RateLimited{Exchange: orderbook.Indodax, Endpoint: "depth", RetryAfter: 30 * time.Second}, err)
+
+	status, body = http.StatusServiceUnavailable, "maintenance"
+	_, err = api.GetDepth("eth_idr")
+	assert.Equal(t, &rest.ExchangeUnavailable{Exchange: orderbook.Indodax, Endpoint: "depth", Status: 503}, err)
+
+	status, body = http.StatusOK, `{"error": "invalid_pair", "error_description": "Invalid Pair"}`
+	_, err = api.GetDepth("abc_idr")
+	assert.Equal(t, &rest.InvalidSymbol{Exchange: orderbook.Indodax, Symbol: "abc_idr"}, err)
+
+	status, body = http.StatusOK, `<html>`
+	_, err = api.GetDepth("eth_idr")
+	assert.IsType(t, &rest.DecodeError{}, err)
+
+	status, body = http.StatusOK, `{"buy": [[3000000, "1"]], "sell": []}`
+	d, err := api.GetDepth("eth_idr")
+	assert.Nil(t, err)
+	assert.Len(t, d.Buy, 1)
+}
+
+func TestGetDepthConcurrent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the price tells which pair was requested
+		price := map[string]string{"/eth_idr/depth": "3000000", "/usdt_idr/depth": "14000"}[r.URL.Path]
+		w.Write([]byte(`{"buy": [[` + price + `, "1"]], "sell": []}`))
+	}))
+	defer server.Close()
+	api := InitIndodax()
+	api.BaseURL = server.URL + "/"
+
+	var wg sync.WaitGroup
+	for pair, price := range map[string]string{"eth_idr": "3000000", "usdt_idr": "14000"} {
+		wg.Add(1)
+		go func(pair, price string) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				d, err := api.GetDepth(pair)
+				assert.Nil(t, err)
+				assert.Equal(t, price, d.Buy[0][0].String())
+			}
+		}(pair, price)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,343 @@
+package indodax
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/metrics"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/rest"
+	"github.com/parnurzeal/gorequest"
+	"github.com/shopspring/decimal"
+)
+
+// Error codes of the trade API
+const (
+	CodeInvalidCredentials  = "invalid_credentials"
+	CodeInvalidNonce        = "invalid_nonce"
+	CodeInsufficientBalance = "insufficient_balance"
+	CodeInvalidPair         = "invalid_pair"
+	CodeOrderNotFound       = "order_not_found"
+)
+
+// ErrNoCredentials is returned by the trade API methods
+// until SetCredentials is called
+var ErrNoCredentials = errors.New("indodax: no API credentials")
+
+// APIError is an error returned by the trade API
+type APIError struct {
+	Method  string
+	Code    string // One of the Code constants when known
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("indodax %v: %v (%v)", e.Method, e.Message, e.Code)
+}
+
+// errorCodes maps the messages of the errors returned
+// without an error_code to their code
+var errorCodes = map[string]string{
+	"invalid credentials":  CodeInvalidCredentials,
+	"nonce":                CodeInvalidNonce,
+	"insufficient balance": CodeInsufficientBalance,
+	"invalid pair":         CodeInvalidPair,
+	"order not found":      CodeOrderNotFound,
+}
+
+// SetCredentials sets the API key and secret of the trade API
+func (i *IndodaxAPI) SetCredentials(key, secret string) {
+	i.key, i.secret = key, secret
+}
+
+// GetInfo returns the balances of the account
+func (i *IndodaxAPI) GetInfo() (info Info, err error) {
+	err = i.call("getInfo", url.Values{}, &info)
+	return info, err
+}
+
+// Trade places an order
+func (i *IndodaxAPI) Trade(r TradeRequest) (result TradeResult, err error) {
+	params := url.Values{
+		"pair": {r.Pair},
+		"type": {r.Type},
+	}
+	if r.OrderType != "" {
+		params.Set("order_type", r.OrderType)
+	}
+	if r.OrderType != "market" {
+		params.Set("price", r.Price.String())
+	}
+	// buys are sized in the right currency, sells in the left one
+	currencies := strings.Split(r.Pair, "_")
+	if r.Type == "buy" {
+		params.Set(currencies[len(currencies)-1], r.Amount.String())
+	} else {
+		params.Set(currencies[0], r.Amount.String())
+	}
+	if r.ClientOrderID != "" {
+		params.Set("client_order_id", r.ClientOrderID)
+	}
+
+	var f fields
+	if err = i.call("trade", params, &f); err != nil {
+		return result, err
+	}
+	result.OrderID = f.id("order_id")
+	result.Receive = f.prefixed("receive_")
+	result.Spend = f.prefixed("spend_")
+	result.Remain = f.prefixed("remain_")
+	result.Fee = f.decimal("fee")
+	f.decode("balance", &result.Balance)
+	return result, nil
+}
+
+// CancelOrder cancels an open order, side is buy or sell
+func (i *IndodaxAPI) CancelOrder(pair, orderID, side string) (result CancelResult, err error) {
+	params := url.Values{
+		"pair":     {pair},
+		"order_id": {orderID},
+		"type":     {side},
+	}
+	var f fields
+	if err = i.call("cancelOrder", params, &f); err != nil {
+		return result, err
+	}
+	result.OrderID = f.id("order_id")
+	f.decode("type", &result.Type)
+	f.decode("pair", &result.Pair)
+	f.decode("balance", &result.Balance)
+	return result, nil
+}
+
+// OpenOrders returns the open orders of a pair
+func (i *IndodaxAPI) OpenOrders(pair string) ([]OrderInfo, error) {
+	return i.orders("openOrders", url.Values{"pair": {pair}})
+}
+
+// OrderHistory returns up to count of the last orders of a pair
+func (i *IndodaxAPI) OrderHistory(pair string, count int) ([]OrderInfo, error) {
+	params := url.Values{"pair": {pair}}
+	if count > 0 {
+		params.Set("count", strconv.Itoa(count))
+	}
+	return i.orders("orderHistory", params)
+}
+
+func (i *IndodaxAPI) orders(method string, params url.Values) ([]OrderInfo, error) {
+	var response struct {
+		Orders []fields `json:"orders"`
+	}
+	if err := i.call(method, params, &response); err != nil {
+		return nil, err
+	}
+	orders := make([]OrderInfo, 0, len(response.Orders))
+	for _, f := range response.Orders {
+		o := OrderInfo{
+			OrderID:       f.id("order_id"),
+			ClientOrderID: f.id("client_order_id"),
+			Price:         f.decimal("price"),
+			SubmitTime:    f.unixTime("submit_time"),
+			FinishTime:    f.unixTime("finish_time"),
+		}
+		f.decode("type", &o.Type)
+		f.decode("status", &o.Status)
+		for key := range f {
+			if strings.HasPrefix(key, "order_") && key != "order_id" && key != "order_type" {
+				o.Currency = strings.ToUpper(strings.TrimPrefix(key, "order_"))
+				o.Amount = f.decimal(key)
+			}
+		}
+		o.Remain = f.prefixed("remain_")
+		orders = append(orders, o)
+	}
+	return orders, nil
+}
+
+// TradeHistory returns up to count of the last trades of a pair
+func (i *IndodaxAPI) TradeHistory(pair string, count int) ([]Trade, error) {
+	params := url.Values{"pair": {pair}}
+	if count > 0 {
+		params.Set("count", strconv.Itoa(count))
+	}
+	var response struct {
+		Trades []fields `json:"trades"`
+	}
+	if err := i.call("tradeHistory", params, &response); err != nil {
+		return nil, err
+	}
+	coin := strings.Split(pair, "_")[0]
+	trades := make([]Trade, 0, len(response.Trades))
+	for _, f := range response.Trades {
+		t := Trade{
+			TradeID:       f.id("trade_id"),
+			OrderID:       f.id("order_id"),
+			ClientOrderID: f.id("client_order_id"),
+			Price:         f.decimal("price"),
+			Qty:           f.decimal(coin),
+			Fee:           f.decimal("fee"),
+			Time:          f.unixTime("trade_time"),
+		}
+		f.decode("type", &t.Type)
+		trades = append(trades, t)
+	}
+	return trades, nil
+}
+
+// call calls a method of the trade API, retrying once when the nonce
+// was not accepted. The methods reading the account are retried with
+// the Retry policy, trade and cancelOrder may have gone through.
+func (i *IndodaxAPI) call(method string, params url.Values, result interface{}) error {
+	policy := i.Retry
+	if method == "trade" || method == "cancelOrder" {
+		policy.Attempts = 1
+	}
+	return policy.Do(context.Background(), func() error {
+		err := i.post(method, params, result)
+		if apiErr, ok := err.(*APIError); ok && apiErr.Code == CodeInvalidNonce {
+			return i.post(method, params, result)
+		}
+		return err
+	})
+}
+
+// post sends a signed request: the form encoded body is signed
+// with HMAC-SHA512 using the API secret
+func (i *IndodaxAPI) post(method string, params url.Values, result interface{}) error {
+	if i.key == "" || i.secret == "" {
+		return ErrNoCredentials
+	}
+	values := url.Values{}
+	for k, v := range params {
+		values[k] = v
+	}
+	values.Set("method", method)
+	values.Set("nonce", strconv.FormatInt(i.nextNonce(), 10))
+	body := values.Encode()
+
+	res, resBody, errs := gorequest.New().
+		Post(i.TradeURL).
+		Set("Key", i.key).
+		Set("Sign", sign(body, i.secret)).
+		Type("form").
+		SendString(body).
+		End()
+	if len(errs) > 0 {
+		metrics.HTTPErrors.WithLabelValues(string(orderbook.Indodax), method).Inc()
+		return &rest.ExchangeUnavailable{Exchange: orderbook.Indodax, Endpoint: method, Err: errs[0]}
+	}
+	if res.StatusCode != 200 {
+		metrics.HTTPErrors.WithLabelValues(string(orderbook.Indodax), method).Inc()
+		if err := rest.StatusError(orderbook.Indodax, method, res); err != nil {
+			return err
+		}
+		return &rest.ExchangeUnavailable{Exchange: orderbook.Indodax, Endpoint: method, Status: res.StatusCode}
+	}
+
+	var envelope struct {
+		Success   int             `json:"success"`
+		Return    json.RawMessage `json:"return"`
+		Error     string          `json:"error"`
+		ErrorCode string          `json:"error_code"`
+	}
+	if err := json.Unmarshal([]byte(resBody), &envelope); err != nil {
+		return &rest.DecodeError{Exchange: orderbook.Indodax, Endpoint: method, Err: err}
+	}
+	if envelope.Success != 1 {
+		code := errorCode(envelope.ErrorCode, envelope.Error)
+		if code == CodeInvalidPair {
+			return &rest.InvalidSymbol{Exchange: orderbook.Indodax, Symbol: params.Get("pair")}
+		}
+		return &APIError{Method: method, Code: code, Message: envelope.Error}
+	}
+	if result == nil || len(envelope.Return) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(envelope.Return, result); err != nil {
+		return &rest.DecodeError{Exchange: orderbook.Indodax, Endpoint: method, Err: err}
+	}
+	return nil
+}
+
+// nextNonce returns a nonce greater than the previous one,
+// the current time in milliseconds unless requests come faster
+func (i *IndodaxAPI) nextNonce() int64 {
+	for {
+		last := atomic.LoadInt64(&i.nonce)
+		next := time.Now().UnixNano() / int64(time.Millisecond)
+		if next <= last {
+			next = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&i.nonce, last, next) {
+			return next
+		}
+	}
+}
+
+func sign(body, secret string) string {
+	mac := hmac.New(sha512.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func errorCode(code, message string) string {
+	if code != "" {
+		return code
+	}
+	lower := strings.ToLower(message)
+	for text, code := range errorCodes {
+		if strings.Contains(lower, text) {
+			return code
+		}
+	}
+	return ""
+}
+
+// fields holds a response object whose keys depend on the pair,
+// e.g. receive_eth or remain_idr
+type fields map[string]json.RawMessage
+
+func (f fields) decode(key string, v interface{}) {
+	if raw, ok := f[key]; ok {
+		json.Unmarshal(raw, v)
+	}
+}
+
+// id returns a field that may be a string or a number
+func (f fields) id(key string) string {
+	return strings.Trim(string(f[key]), `"`)
+}
+
+func (f fields) decimal(key string) (d decimal.Decimal) {
+	f.decode(key, &d)
+	return d
+}
+
+// prefixed returns the first field starting with prefix
+func (f fields) prefixed(prefix string) decimal.Decimal {
+	for key := range f {
+		if strings.HasPrefix(key, prefix) {
+			return f.decimal(key)
+		}
+	}
+	return decimal.Zero
+}
+
+// unixTime returns a field holding a unix time in seconds
+func (f fields) unixTime(key string) time.Time {
+	seconds, err := strconv.ParseInt(f.id(key), 10, 64)
+	if err != nil || seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
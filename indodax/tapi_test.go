@@ -0,0 +1,156 @@
+package indodax
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/rest"
+	"github.com/stretchr/testify/assert"
+)
+
+// tapiServer stands in for the trade API, checking the signature
+// of every request and answering with the response of its method
+func tapiServer(t *testing.T, responses map[string]string) (*httptest.Server, *[]url.Values) {
+	var requests []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, "key", r.Header.Get("Key"))
+		assert.Equal(t, sign(string(body), "secret"), r.Header.Get("Sign"))
+		values, err := url.ParseQuery(string(body))
+		assert.Nil(t, err)
+		requests = append(requests, values)
+		w.Write([]byte(responses[values.Get("method")]))
+	}))
+	return server, &requests
+}
+
+func newTestAPI(server *httptest.Server) *IndodaxAPI {
+	api := InitIndodax()
+	api.TradeURL = server.URL
+	api.SetCredentials("key", "secret")
+	return api
+}
+
+func TestTradeAPI(t *testing.T) {
+	server, requests := tapiServer(t, map[string]string{
+		"getInfo": `{"success": 1, "return": {
+			"server_time": 1578304294, "user_id": "1",
+			"balance": {"idr": "5000000", "eth": "0.25"},
+			"balance_hold": {"idr": "100000", "eth": "0"}
+		}}`,
+		"trade": `{"success": 1, "return": {
+			"receive_eth": "0.1", "spend_rp": 300000, "fee": 900, "remain_rp": "700000",
+			"order_id": 11560, "balance": {"idr": "4000000"}
+		}}`,
+		"openOrders": `{"success": 1, "return": {"orders": [{
+			"order_id": "11560", "client_order_id": "a", "submit_time": "1578304294",
+			"price": "3000000", "type": "buy", "order_idr": "1000000", "remain_idr": "700000"
+		}]}}`,
+		"tradeHistory": `{"success": 1, "return": {"trades": [{
+			"trade_id": "42", "order_id": "11560", "type": "buy", "eth": "0.1",
+			"price": "3000000", "fee": "900", "trade_time": "1578304295"
+		}]}}`,
+		"cancelOrder": `{"success": 1, "return": {"order_id": 11560, "type": "buy", "pair": "eth_idr", "balance": {"idr": "4700000"}}}`,
+	})
+	defer server.Close()
+	api := newTestAPI(server)
+
+	info, err := api.GetInfo()
+	assert.Nil(t, err)
+	assert.Equal(t, "5000000", info.Balance["idr"].String())
+	assert.Equal(t, "0.25", info.Balance["eth"].String())
+
+	trade, err := api.Trade(TradeRequest{Pair: "eth_idr", Type: "buy", Price: d("3000000"), Amount: d("1000000"), ClientOrderID: "a"})
+	assert.Nil(t, err)
+	assert.Equal(t, "11560", trade.OrderID)
+	assert.Equal(t, "0.1", trade.Receive.String())
+	assert.Equal(t, "700000", trade.Remain.String())
+	sent := (*requests)[1]
+	assert.Equal(t, "1000000", sent.Get("idr"))
+	assert.Equal(t, "3000000", sent.Get("price"))
+	assert.Equal(t, "a", sent.Get("client_order_id"))
+
+	orders, err := api.OpenOrders("eth_idr")
+	assert.Nil(t, err)
+	assert.Len(t, orders, 1)
+	assert.Equal(t, "IDR", orders[0].Currency)
+	assert.Equal(t, "1000000", orders[0].Amount.String())
+	assert.Equal(t, "700000", orders[0].Remain.String())
+	assert.Equal(t, int64(1578304294), orders[0].SubmitTime.Unix())
+
+	trades, err := api.TradeHistory("eth_idr", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, "0.1", trades[0].Qty.String())
+	assert.Equal(t, "10", (*requests)[3].Get("count"))
+
+	cancelled, err := api.CancelOrder("eth_idr", "11560", "buy")
+	assert.Nil(t, err)
+	assert.Equal(t, "11560", cancelled.OrderID)
+	assert.Equal(t, "4700000", cancelled.Balance["idr"].String())
+
+	// every request carries a greater nonce
+	var last int64
+	for _, r := range *requests {
+		nonce, err := strconv.ParseInt(r.Get("nonce"), 10, 64)
+		assert.Nil(t, err)
+		assert.True(t, nonce > last)
+		last = nonce
+	}
+}
+
+func TestTradeAPIErrors(t *testing.T) {
+	server, requests := tapiServer(t, map[string]string{
+		"getInfo": `{"success": 0, "error": "Invalid nonce. Nonce must be greater than 1578304294000."}`,
+		"trade":   `{"success": 0, "error": "Insufficient balance.", "error_code": ""}`,
+		"openOrders": `{"success": 0, "error": "Invalid credentials. API not found or session has expired.",
+			"error_code": "invalid_credentials"}`,
+	})
+	defer server.Close()
+	api := newTestAPI(server)
+
+	// the nonce is retried once
+	_, err := api.GetInfo()
+	assert.Equal(t, CodeInvalidNonce, err.(*APIError).Code)
+	assert.Len(t, *requests, 2)
+
+	_, err = api.Trade(TradeRequest{Pair: "eth_idr", Type: "sell", Price: d("3000000"), Amount: d("1")})
+	assert.Equal(t, CodeInsufficientBalance, err.(*APIError).Code)
+	assert.Equal(t, "1", (*requests)[2].Get("eth"))
+
+	_, err = api.OpenOrders("eth_idr")
+	assert.Equal(t, CodeInvalidCredentials, err.(*APIError).Code)
+
+	_, err = InitIndodax().GetInfo()
+	assert.Equal(t, ErrNoCredentials, err)
+}
+
+func TestTradeAPIRetries(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests%2 == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"success": 1, "return": {"order_id": 11560}}`))
+	}))
+	defer server.Close()
+	api := newTestAPI(server)
+	api.Retry = rest.Policy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	// reading the account is retried
+	_, err := api.GetInfo()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, requests)
+
+	// an order may have been placed, it is not
+	_, err = api.Trade(TradeRequest{Pair: "eth_idr", Type: "buy", Price: d("3000000"), Amount: d("1000000")})
+	assert.Equal(t, &rest.ExchangeUnavailable{Exchange: orderbook.Indodax, Endpoint: "trade", Status: http.StatusBadGateway}, err)
+	assert.Equal(t, 3, requests)
+}
@@ -2,16 +2,36 @@
 package indodax
 
 import (
-	"strconv"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
 
+	"github.com/anthonychristian/crypto-arbitrage/logging"
 	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/shopspring/decimal"
 )
 
+var log = logging.New("indodax").With("exchange", orderbook.Indodax)
+
 // Worker is the main engine for making order decisions
 // and continuing arbitrage loop ETH -> IDR -> USDT
 type Worker struct {
-	depth chan Depth
-	halt  bool
+	depth    chan symbolDepth
+	updates  chan orderbook.BookUpdate
+	legs     <-chan orderbook.BookUpdate // Updates of the other exchanges' legs
+	loops    chan Loop
+	notional decimal.Decimal // USDT put in the loop, see LoopNotional
+	halt     bool
+	mu       sync.RWMutex
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+// symbolDepth is a depth polled for a symbol
+type symbolDepth struct {
+	symbol orderbook.Symbol
+	depth  Depth
 }
 
 var WorkerInstance *Worker
@@ -19,7 +39,12 @@ var WorkerInstance *Worker
 // InitWorker instances
 func InitWorker() *Worker {
 	newWorker := &Worker{
-		depth: make(chan Depth),
+		depth:    make(chan symbolDepth),
+		legs:     orderbook.SubscribeUpdates(),
+		loops:    make(chan Loop, 16),
+		notional: LoopNotional,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
 	}
 	WorkerInstance = newWorker
 	go WorkerInstance.work()
@@ -28,53 +53,155 @@ func InitWorker() *Worker {
 
 // Halt to halt the worker from doing actions
 func (w *Worker) Halt() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	w.halt = true
 }
 
 // Start to start the worker to do actions
 func (w *Worker) Start() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	w.halt = false
 }
 
-func (w *Worker) PushDepthUpdate(d Depth) {
-	w.depth <- d
+// Loops returns the channel the loop evaluations are reported to.
+// Evaluations are dropped when nobody keeps up with the channel.
+func (w *Worker) Loops() <-chan Loop {
+	return w.loops
+}
+
+// PushDepthUpdate hands a polled depth to the worker,
+// it is dropped when the worker is stopped
+func (w *Worker) PushDepthUpdate(symbol orderbook.Symbol, d Depth) {
+	select {
+	case w.depth <- symbolDepth{symbol, d}:
+	case <-w.quit:
+	}
+}
+
+// stop stops the worker and waits for it to return,
+// closing the Loops channel
+func (w *Worker) stop() {
+	close(w.quit)
+	<-w.done
 }
 
 func (w *Worker) work() {
-	// infinite loop to keep doing actions
+	defer close(w.done)
+	defer close(w.loops)
+	defer orderbook.UnsubscribeUpdates(w.legs)
+	// loop doing actions until the worker is stopped
 	for {
 		select {
+		case <-w.quit:
+			return
 		case d := <-w.depth:
 			// add depth to orderbook
-			updateDepth(d)
+			book := GetOB(d.symbol)
+			if book == nil || d.depth.IsEmpty() {
+				continue
+			}
+			update, err := updateDepth(d.symbol, book, d.depth)
+			if err != nil {
+				log.Warn("dropping the depth", "symbol", d.symbol, "err", err)
+				continue
+			}
+			w.publish(update)
+			w.evaluateLoop()
+		case u := <-w.legs:
+			if u.Exchange == orderbook.Binance && u.Symbol == orderbook.ETH_USDT {
+				w.evaluateLoop()
+			}
 		}
 	}
 }
 
-func updateDepth(d Depth) {
-	// TODO update the indodax's orderbook (ETHEREUM)
-	for _, elem := range d.Buy {
-		q, err := strconv.ParseFloat(elem[1].(string), 64)
-		if err != nil {
-			panic(err)
-		}
-		newOrder := orderbook.Order{
-			Price:       elem[0].(float64),
-			Qty:         q,
-			ExchangeKey: orderbook.Indodax,
+// evaluateLoop reports the profitability of the arbitrage loop,
+// unless the worker is halted or a leg is not available yet
+func (w *Worker) evaluateLoop() {
+	w.mu.RLock()
+	halt := w.halt
+	w.mu.RUnlock()
+	if halt {
+		return
+	}
+	loops, ok := EvaluateLoops(w.notional, time.Now())
+	if !ok {
+		return
+	}
+	for _, loop := range loops {
+		select {
+		case w.loops <- loop:
+		default:
 		}
-		idxOrderBook.AddBuy(newOrder)
 	}
-	for _, elem := range d.Sell {
-		q, err := strconv.ParseFloat(elem[1].(string), 64)
+}
+
+// publish hands the update to the adapter's consumers,
+// dropping it when nobody keeps up with the channel
+func (w *Worker) publish(update orderbook.BookUpdate) {
+	if w.updates == nil {
+		return
+	}
+	select {
+	case w.updates <- update:
+	default:
+	}
+}
+
+// updateDepth replaces the book with the depth, indodax only
+// publishes full snapshots so levels missing from it are gone.
+// The book is left untouched when the depth is malformed.
+func updateDepth(symbol orderbook.Symbol, book *orderbook.OrderBook, d Depth) (orderbook.BookUpdate, error) {
+	update, err := depthUpdate(symbol, d)
+	if err != nil {
+		return update, err
+	}
+	book.Replace(update.Bids, update.Asks)
+	return update, nil
+}
+
+// depthUpdate converts the depth of a symbol to a snapshot update
+func depthUpdate(symbol orderbook.Symbol, d Depth) (orderbook.BookUpdate, error) {
+	bids, err := toOrders(symbol, d.Buy)
+	if err != nil {
+		return orderbook.BookUpdate{}, fmt.Errorf("buy side: %v", err)
+	}
+	asks, err := toOrders(symbol, d.Sell)
+	if err != nil {
+		return orderbook.BookUpdate{}, fmt.Errorf("sell side: %v", err)
+	}
+	return orderbook.BookUpdate{
+		Exchange: orderbook.Indodax,
+		Symbol:   symbol,
+		Bids:     bids,
+		Asks:     asks,
+		Snapshot: true,
+	}, nil
+}
+
+// toOrders parses the [price, qty] pairs of a depth side
+func toOrders(symbol orderbook.Symbol, levels [][]json.Number) ([]orderbook.Order, error) {
+	orders := make([]orderbook.Order, 0, len(levels))
+	for _, elem := range levels {
+		if len(elem) < 2 {
+			return nil, fmt.Errorf("malformed level %v", elem)
+		}
+		p, err := decimal.NewFromString(elem[0].String())
+		if err != nil {
+			return nil, fmt.Errorf("price of level %v: %v", elem, err)
+		}
+		q, err := decimal.NewFromString(elem[1].String())
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("quantity of level %v: %v", elem, err)
 		}
-		newOrder := orderbook.Order{
-			Price:       elem[0].(float64),
+		orders = append(orders, orderbook.Order{
+			Price:       p,
 			Qty:         q,
+			FillCost:    orderbook.Fees.FillCost(orderbook.Indodax, symbol),
 			ExchangeKey: orderbook.Indodax,
-		}
-		idxOrderBook.AddSell(newOrder)
+		})
 	}
+	return orders, nil
 }
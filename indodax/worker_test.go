@@ -0,0 +1,51 @@
+package indodax
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/skiplist"
+	"github.com/stretchr/testify/assert"
+)
+
+func levels(it skiplist.Iterator) (prices []string) {
+	for it.Next() {
+		prices = append(prices, it.Value().(orderbook.Order).Price.String())
+	}
+	return prices
+}
+
+func TestUpdateDepthReplacesSnapshot(t *testing.T) {
+	var first, second Depth
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"buy": [[3000000, "1.5"], [2990000, "2"], [2980000, "3"]],
+		"sell": [[3010000, "0.5"], [3020000, "1"]]
+	}`), &first))
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"buy": [[2990000, "2.5"], [2970000, "1"]],
+		"sell": [[3020000, "1"], [3030000, "4"]]
+	}`), &second))
+
+	book := orderbook.NewOrderBook()
+	_, err := updateDepth(orderbook.ETH_IDR, book, first)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"3000000", "2990000", "2980000"}, levels(book.IteratorBuySide()))
+	assert.Equal(t, []string{"3010000", "3020000"}, levels(book.IteratorSellSide()))
+
+	update, err := updateDepth(orderbook.ETH_IDR, book, second)
+	assert.Nil(t, err)
+	assert.True(t, update.Snapshot)
+	assert.Equal(t, orderbook.ETH_IDR, update.Symbol)
+	assert.Equal(t, 1.003, update.Bids[0].FillCost)
+	assert.Equal(t, []string{"2990000", "2970000"}, levels(book.IteratorBuySide()))
+	assert.Equal(t, []string{"3020000", "3030000"}, levels(book.IteratorSellSide()))
+	assert.Equal(t, "2.5", book.TopPriceBuySide().Qty.String())
+
+	// a malformed depth leaves the book untouched
+	var malformed Depth
+	assert.Nil(t, json.Unmarshal([]byte(`{"buy": [[2990000]], "sell": [[3020000, "1"]]}`), &malformed))
+	_, err = updateDepth(orderbook.ETH_IDR, book, malformed)
+	assert.EqualError(t, err, "buy side: malformed level [2990000]")
+	assert.Equal(t, []string{"2990000", "2970000"}, levels(book.IteratorBuySide()))
+}
@@ -0,0 +1,154 @@
+// Package lifecycle starts the components of the app in order, and
+// stops them in the reverse order so each one drains into components
+// that are still running
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/logging"
+)
+
+var log = logging.New("lifecycle")
+
+// Component is a part of the app running in the background
+type Component interface {
+	// Start starts the component, ctx bounds the startup
+	Start(ctx context.Context) error
+	// Stop stops the component and waits for its goroutines to
+	// return, giving up with ctx.Err() when ctx is done first
+	Stop(ctx context.Context) error
+}
+
+// Hook is a Component made of functions, either may be nil
+type Hook struct {
+	OnStart func(ctx context.Context) error
+	OnStop  func(ctx context.Context) error
+}
+
+// Start calls OnStart
+func (h Hook) Start(ctx context.Context) error {
+	if h.OnStart == nil {
+		return nil
+	}
+	return h.OnStart(ctx)
+}
+
+// Stop calls OnStop
+func (h Hook) Stop(ctx context.Context) error {
+	if h.OnStop == nil {
+		return nil
+	}
+	return h.OnStop(ctx)
+}
+
+// Service adapts the Start and Stop methods of the services of the app,
+// Stop returning once the service's goroutines have returned
+func Service(start, stop func()) Hook {
+	return Hook{
+		OnStart: func(context.Context) error {
+			start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return Wait(ctx, stop)
+		},
+	}
+}
+
+// Wait runs f, returning ctx.Err() when ctx is done before f returns
+func Wait(ctx context.Context, f func()) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Leaked waits up to timeout for the number of goroutines to go back to
+// before, returning how many are left above it. Tests use it to check
+// that stopping a component returns every goroutine it started.
+func Leaked(before int, timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		n := runtime.NumGoroutine() - before
+		if n <= 0 {
+			return 0
+		}
+		if time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Group runs components in the order they were appended
+type Group struct {
+	names      []string
+	components []Component
+	started    int // Number of components started
+	mu         sync.Mutex
+}
+
+// Append adds a component, started after the ones already appended
+func (g *Group) Append(name string, c Component) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.names = append(g.names, name)
+	g.components = append(g.components, c)
+}
+
+// Start starts every component in order. When one fails the
+// components already started are stopped, and its error returned.
+func (g *Group) Start(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.started < len(g.components) {
+		name := g.names[g.started]
+		if err := g.components[g.started].Start(ctx); err != nil {
+			g.stop(ctx)
+			return fmt.Errorf("starting %v: %v", name, err)
+		}
+		log.Info("started", "name", name)
+		g.started++
+	}
+	return nil
+}
+
+// Stop stops the components started, in the reverse order. Every
+// component is asked to stop even when ctx is done, the errors
+// are returned together.
+func (g *Group) Stop(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stop(ctx)
+}
+
+func (g *Group) stop(ctx context.Context) error {
+	var errs []string
+	for ; g.started > 0; g.started-- {
+		name := g.names[g.started-1]
+		if err := g.components[g.started-1].Stop(ctx); err != nil {
+			log.Error("error stopping", "name", name, "err", err)
+			errs = append(errs, fmt.Sprintf("stopping %v: %v", name, err))
+			continue
+		}
+		log.Info("stopped", "name", name)
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
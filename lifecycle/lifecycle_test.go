@@ -0,0 +1,108 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/arbitrage"
+	"github.com/anthonychristian/crypto-arbitrage/fx"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/orders"
+	"github.com/anthonychristian/crypto-arbitrage/paper"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+// recording appends the name of the components started and stopped
+func recording(calls *[]string, name string, startErr, stopErr error) Hook {
+	return Hook{
+		OnStart: func(context.Context) error {
+			*calls = append(*calls, "start "+name)
+			return startErr
+		},
+		OnStop: func(context.Context) error {
+			*calls = append(*calls, "stop "+name)
+			return stopErr
+		},
+	}
+}
+
+func TestGroup(t *testing.T) {
+	var calls []string
+	var g Group
+	g.Append("feeds", recording(&calls, "feeds", nil, nil))
+	g.Append("worker", recording(&calls, "worker", nil, errors.New("stuck")))
+	g.Append("server", recording(&calls, "server", nil, nil))
+
+	assert.Nil(t, g.Start(context.Background()))
+	assert.EqualError(t, g.Stop(context.Background()), "stopping worker: stuck")
+	assert.Equal(t, []string{
+		"start feeds", "start worker", "start server",
+		"stop server", "stop worker", "stop feeds",
+	}, calls)
+
+	// stopped twice, nothing is running anymore
+	calls = nil
+	assert.Nil(t, g.Stop(context.Background()))
+	assert.Empty(t, calls)
+}
+
+func TestGroupStartFails(t *testing.T) {
+	var calls []string
+	var g Group
+	g.Append("feeds", recording(&calls, "feeds", nil, nil))
+	g.Append("worker", recording(&calls, "worker", errors.New("no books"), nil))
+	g.Append("server", recording(&calls, "server", nil, nil))
+
+	assert.EqualError(t, g.Start(context.Background()), "starting worker: no books")
+	assert.Equal(t, []string{"start feeds", "start worker", "stop feeds"}, calls)
+}
+
+func TestService(t *testing.T) {
+	stopped := make(chan struct{})
+	s := Service(func() {}, func() { <-stopped })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Nil(t, s.Start(ctx))
+	assert.Equal(t, context.DeadlineExceeded, s.Stop(ctx))
+
+	close(stopped)
+	assert.Nil(t, s.Stop(context.Background()))
+}
+
+func TestNoLeaks(t *testing.T) {
+	exchanges := orderbook.Exchanges
+	orderbook.Exchanges = orderbook.ExchangeMap{orderbook.Binance: {
+		Books: orderbook.OrderBookMap{orderbook.ETH_USDT: orderbook.NewOrderBook()},
+	}}
+	defer func() { orderbook.Exchanges = exchanges }()
+
+	goroutines := runtime.NumGoroutine()
+	detector := arbitrage.NewDetector(decimal.Zero)
+	opportunities := detector.Subscribe()
+	rates := fx.NewService()
+	consolidated := orderbook.NewConsolidatedBook("ETH", "USDT")
+	simulator := paper.New(orderbook.Binance, nil)
+	manager := orders.NewManager()
+
+	var g Group
+	g.Append("rates", Service(rates.Start, rates.Stop))
+	g.Append("detector", Service(detector.Start, detector.Stop))
+	g.Append("consolidated", Service(consolidated.Start, consolidated.Stop))
+	g.Append("paper", Service(func() {
+		simulator.Start()
+		// the executions are forwarded until the simulator stops
+		manager.Register(simulator)
+	}, simulator.Stop))
+	assert.Nil(t, g.Start(context.Background()))
+	assert.True(t, runtime.NumGoroutine() > goroutines)
+
+	assert.Nil(t, g.Stop(context.Background()))
+	_, open := <-opportunities
+	assert.False(t, open)
+	assert.Zero(t, Leaked(goroutines, time.Second))
+}
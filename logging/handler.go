@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// levels is the body of the requests and responses of Handler
+type levels struct {
+	Component  string            `json:"component,omitempty"`
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components,omitempty"`
+}
+
+// Handler serves the levels. GET returns them, PUT and POST set the
+// level of a component, or of every component when none is given:
+//
+//	{"component": "binance", "level": "debug"}
+//
+// An empty level makes the component use the level of every component.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var req levels
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if req.Component != "" && req.Level == "" {
+				ResetComponentLevel(req.Component)
+				break
+			}
+			level, err := ParseLevel(req.Level)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if req.Component == "" {
+				SetLevel(level)
+			} else {
+				SetComponentLevel(req.Component, level)
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		level, components := Levels()
+		res := levels{Level: level.String(), Components: make(map[string]string)}
+		for c, l := range components {
+			res.Components[c] = l.String()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
+}
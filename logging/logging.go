@@ -0,0 +1,297 @@
+// Package logging writes levelled, structured log entries. An entry
+// carries the fields of its logger, e.g. component, exchange and symbol,
+// then its own key/value pairs, written as logfmt text or JSON lines.
+//
+// Every package logs through its own logger:
+//
+//	var log = logging.New("binance").With("exchange", orderbook.Binance)
+//
+// The level is set for every component, or overridden for one of them,
+// at startup and at runtime through Handler.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of an entry
+type Level int32
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses the name of a level, e.g. "debug"
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("unknown log level %q", s)
+}
+
+// Format is how the entries are written
+type Format int
+
+const (
+	// Text writes logfmt lines: time=... level=info msg="..." key=value
+	Text Format = iota
+	// JSON writes one JSON object per line
+	JSON
+)
+
+// ParseFormat parses "text" or "json"
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "text", "":
+		return Text, nil
+	case "json":
+		return JSON, nil
+	}
+	return Text, fmt.Errorf("unknown log format %q", s)
+}
+
+// output holds the settings shared by every logger
+var output = struct {
+	w          io.Writer
+	format     Format
+	level      Level
+	components map[string]Level // Levels overriding level
+	now        func() time.Time
+	mu         sync.RWMutex
+}{
+	w:          os.Stderr,
+	level:      InfoLevel,
+	components: make(map[string]Level),
+	now:        time.Now,
+}
+
+// SetOutput sets where the entries are written, os.Stderr by default
+func SetOutput(w io.Writer) {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+	output.w = w
+}
+
+// SetFormat sets how the entries are written, Text by default
+func SetFormat(f Format) {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+	output.format = f
+}
+
+// SetLevel sets the level of the components without one of their own
+func SetLevel(l Level) {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+	output.level = l
+}
+
+// SetComponentLevel sets the level of a component
+func SetComponentLevel(component string, l Level) {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+	output.components[component] = l
+}
+
+// ResetComponentLevel makes a component use the level of SetLevel again
+func ResetComponentLevel(component string) {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+	delete(output.components, component)
+}
+
+// Levels returns the level of SetLevel and those of the components
+func Levels() (Level, map[string]Level) {
+	output.mu.RLock()
+	defer output.mu.RUnlock()
+	components := make(map[string]Level, len(output.components))
+	for c, l := range output.components {
+		components[c] = l
+	}
+	return output.level, components
+}
+
+// Logger writes the entries of a component
+type Logger struct {
+	component string
+	fields    []interface{}
+	sampler   *sampler
+}
+
+// New returns the logger of a component
+func New(component string) *Logger {
+	return &Logger{component: component}
+}
+
+// With returns a logger adding key/value pairs to every entry
+func (l *Logger) With(kv ...interface{}) *Logger {
+	c := *l
+	c.fields = append(append([]interface{}(nil), l.fields...), kv...)
+	return &c
+}
+
+// Sampled returns a logger for high-rate events: every second it writes
+// the first entries of a message, then one out of thereafter, or none
+// when thereafter is 0. The logger and those it creates share the counts.
+func (l *Logger) Sampled(first, thereafter int) *Logger {
+	c := *l
+	c.sampler = &sampler{first: first, thereafter: thereafter, tick: time.Second, counts: make(map[string]int)}
+	return &c
+}
+
+// Enabled tells whether entries of level are written
+func (l *Logger) Enabled(level Level) bool {
+	output.mu.RLock()
+	defer output.mu.RUnlock()
+	min, ok := output.components[l.component]
+	if !ok {
+		min = output.level
+	}
+	return level >= min
+}
+
+// Debug writes an entry about what the component is doing
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.log(DebugLevel, msg, kv) }
+
+// Info writes an entry about a change of state
+func (l *Logger) Info(msg string, kv ...interface{}) { l.log(InfoLevel, msg, kv) }
+
+// Warn writes an entry about an error the component recovers from
+func (l *Logger) Warn(msg string, kv ...interface{}) { l.log(WarnLevel, msg, kv) }
+
+// Error writes an entry about an error the component can't recover from
+func (l *Logger) Error(msg string, kv ...interface{}) { l.log(ErrorLevel, msg, kv) }
+
+// Fatal writes an entry whatever the level, and exits
+func (l *Logger) Fatal(msg string, kv ...interface{}) {
+	l.write(ErrorLevel, msg, kv)
+	os.Exit(1)
+}
+
+func (l *Logger) log(level Level, msg string, kv []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	if l.sampler != nil && !l.sampler.allow(level.String()+msg, output.now()) {
+		return
+	}
+	l.write(level, msg, kv)
+}
+
+func (l *Logger) write(level Level, msg string, kv []interface{}) {
+	output.mu.RLock()
+	defer output.mu.RUnlock()
+	e := encoder{format: output.format}
+	e.add("time", output.now().UTC().Format(time.RFC3339Nano))
+	e.add("level", level.String())
+	if l.component != "" {
+		e.add("component", l.component)
+	}
+	e.add("msg", msg)
+	e.addPairs(l.fields)
+	e.addPairs(kv)
+	output.w.Write(e.bytes())
+}
+
+// sampler counts the entries of every message over a tick
+type sampler struct {
+	first, thereafter int
+	tick              time.Duration
+	counts            map[string]int
+	reset             time.Time
+	mu                sync.Mutex
+}
+
+func (s *sampler) allow(key string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.reset) >= s.tick {
+		s.counts = make(map[string]int)
+		s.reset = now
+	}
+	s.counts[key]++
+	n := s.counts[key]
+	return n <= s.first || (s.thereafter > 0 && (n-s.first)%s.thereafter == 0)
+}
+
+// encoder builds the line of an entry
+type encoder struct {
+	format Format
+	buf    bytes.Buffer
+}
+
+func (e *encoder) addPairs(kv []interface{}) {
+	for i := 0; i < len(kv); i += 2 {
+		if i+1 == len(kv) {
+			e.add("extra", kv[i])
+			return
+		}
+		e.add(fmt.Sprint(kv[i]), kv[i+1])
+	}
+}
+
+func (e *encoder) add(key string, value interface{}) {
+	if err, ok := value.(error); ok && err != nil {
+		value = err.Error()
+	}
+	if e.format == JSON {
+		if e.buf.Len() == 0 {
+			e.buf.WriteByte('{')
+		} else {
+			e.buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		e.buf.Write(k)
+		e.buf.WriteByte(':')
+		v, err := json.Marshal(value)
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(value))
+		}
+		e.buf.Write(v)
+		return
+	}
+	if e.buf.Len() > 0 {
+		e.buf.WriteByte(' ')
+	}
+	e.buf.WriteString(quote(key))
+	e.buf.WriteByte('=')
+	e.buf.WriteString(quote(fmt.Sprint(value)))
+}
+
+func (e *encoder) bytes() []byte {
+	if e.format == JSON {
+		e.buf.WriteByte('}')
+	}
+	e.buf.WriteByte('\n')
+	return e.buf.Bytes()
+}
+
+// quote quotes the logfmt values that need it
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
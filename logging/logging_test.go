@@ -0,0 +1,136 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// capture writes the entries to a buffer at a fixed time,
+// restoring the settings when the test is done
+func capture(t *testing.T) *bytes.Buffer {
+	var out bytes.Buffer
+	now := time.Date(2020, 1, 6, 10, 0, 0, 0, time.UTC)
+	output.mu.Lock()
+	w, format, level, nowFunc := output.w, output.format, output.level, output.now
+	output.w, output.now = &out, func() time.Time { return now }
+	output.mu.Unlock()
+	t.Cleanup(func() {
+		output.mu.Lock()
+		defer output.mu.Unlock()
+		output.w, output.format, output.level, output.now = w, format, level, nowFunc
+		output.components = make(map[string]Level)
+	})
+	return &out
+}
+
+func TestText(t *testing.T) {
+	out := capture(t)
+	log := New("binance").With("exchange", "Binance", "symbol", "BTC/USDC")
+	log.Info("Binance depth gap, resyncing", "expected", 101, "err", errors.New("no route to host"))
+	log.Debug("depth event applied")
+
+	assert.Equal(t, `time=2020-01-06T10:00:00Z level=info component=binance msg="Binance depth gap, resyncing" `+
+		`exchange=Binance symbol=BTC/USDC expected=101 err="no route to host"`+"\n", out.String())
+}
+
+func TestJSON(t *testing.T) {
+	out := capture(t)
+	SetFormat(JSON)
+	New("indodax").Warn("depth request failed", "pair", "eth_idr", "status", 502, "odd")
+
+	var entry map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &entry))
+	assert.Equal(t, map[string]interface{}{
+		"time":      "2020-01-06T10:00:00Z",
+		"level":     "warn",
+		"component": "indodax",
+		"msg":       "depth request failed",
+		"pair":      "eth_idr",
+		"status":    502.0,
+		"extra":     "odd",
+	}, entry)
+}
+
+func TestLevels(t *testing.T) {
+	out := capture(t)
+	binance, indodax := New("binance"), New("indodax")
+	SetLevel(WarnLevel)
+	SetComponentLevel("binance", DebugLevel)
+
+	binance.Debug("written")
+	indodax.Info("dropped")
+	indodax.Error("written")
+	assert.Equal(t, 2, strings.Count(out.String(), "msg=written"))
+	assert.NotContains(t, out.String(), "dropped")
+
+	ResetComponentLevel("binance")
+	assert.False(t, binance.Enabled(InfoLevel))
+	assert.True(t, binance.Enabled(WarnLevel))
+
+	_, err := ParseLevel("verbose")
+	assert.EqualError(t, err, `unknown log level "verbose"`)
+}
+
+func TestSampled(t *testing.T) {
+	out := capture(t)
+	log := New("binance").Sampled(2, 10)
+	for i := 0; i < 30; i++ {
+		log.Info("depth event applied")
+		log.Info("other")
+	}
+	// the first two, then the 10th and 20th after them
+	assert.Equal(t, 4, strings.Count(out.String(), `msg="depth event applied"`))
+	assert.Equal(t, 4, strings.Count(out.String(), "msg=other"))
+
+	// the counts are reset every second
+	now := output.now().Add(time.Second)
+	output.now = func() time.Time { return now }
+	log.Info("other")
+	assert.Equal(t, 5, strings.Count(out.String(), "msg=other"))
+}
+
+func TestHandler(t *testing.T) {
+	capture(t)
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	put := func(body string) (int, levels) {
+		req, _ := http.NewRequest(http.MethodPut, server.URL, strings.NewReader(body))
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		var l levels
+		json.NewDecoder(res.Body).Decode(&l)
+		return res.StatusCode, l
+	}
+
+	status, l := put(`{"component": "binance", "level": "debug"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, levels{Level: "info", Components: map[string]string{"binance": "debug"}}, l)
+
+	status, l = put(`{"level": "error"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "error", l.Level)
+
+	status, _ = put(`{"level": "verbose"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, l = put(`{"component": "binance"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Empty(t, l.Components)
+
+	res, err := http.Get(server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res, err = http.Head(server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+}
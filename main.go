@@ -1,120 +1,323 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/alpacahq/gopaca/log"
-	"github.com/anthonychristian/crypto-arbitrage/indodax"
+	"github.com/anthonychristian/crypto-arbitrage/arbitrage"
+	"github.com/anthonychristian/crypto-arbitrage/config"
+	"github.com/anthonychristian/crypto-arbitrage/fx"
+	_ "github.com/anthonychristian/crypto-arbitrage/indodax"
+	"github.com/anthonychristian/crypto-arbitrage/lifecycle"
+	"github.com/anthonychristian/crypto-arbitrage/logging"
+	"github.com/anthonychristian/crypto-arbitrage/metrics"
 	"github.com/anthonychristian/crypto-arbitrage/orderbook"
-	"github.com/anthonychristian/crypto-arbitrage/websocket"
+	"github.com/anthonychristian/crypto-arbitrage/orders"
+	"github.com/anthonychristian/crypto-arbitrage/paper"
+	"github.com/anthonychristian/crypto-arbitrage/recorder"
+	_ "github.com/anthonychristian/crypto-arbitrage/websocket"
 	"github.com/joho/godotenv"
 	"github.com/kataras/iris"
 	irisWs "github.com/kataras/iris/websocket"
+	"github.com/shopspring/decimal"
 )
 
-func init() {
-	orderbook.Exchanges[orderbook.Binance] = orderbook.Exchange{Books: make(orderbook.OrderBookMap)}
-	initExchanges()
+var log = logging.New("main")
 
-	// initialize API gateway
-	_ = indodax.InitIndodax()
+// shutdownTimeout bounds the time the components have to stop
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	var (
+		configFile = flag.String("config", os.Getenv("CONFIG_FILE"), "YAML file of the settings, see config.example.yaml")
+		dumpConfig = flag.Bool("dump-config", false, "print the settings the app would run with, and exit")
+	)
+	flag.Parse()
+
+	// .env is optional, the settings may come from the environment
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		log.Fatal("Error loading .env file", "err", err)
+	}
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatal("Error loading configuration", "path", *configFile, "err", err)
+	}
+	if *dumpConfig {
+		if err := cfg.Dump(os.Stdout); err != nil {
+			log.Fatal("Error dumping configuration", "err", err)
+		}
+		return
+	}
+	if err := cfg.Apply(); err != nil {
+		log.Fatal("Error applying configuration", "err", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	app := components(cfg)
+	if err := app.Start(ctx); err != nil {
+		log.Fatal("Error starting", "err", err)
+	}
+	<-ctx.Done()
+	log.Info("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := app.Stop(ctx); err != nil {
+		log.Fatal("Error shutting down", "err", err)
+	}
 }
 
-func initExchanges() {
-	for k := range orderbook.SymbolMap {
-		for _, ex := range orderbook.SymbolMap[k] {
-			orderbook.Exchanges[ex].Books[orderbook.Symbol(k)] = orderbook.NewOrderBook()
+// components returns the parts of the app in the order they start:
+// each one only uses the ones before it, so stopping them in the
+// reverse order drains every feed before what it feeds is stopped
+func components(cfg config.Config) *lifecycle.Group {
+	var g lifecycle.Group
+
+	// Record the raw depth data of the exchanges, see package recorder
+	if cfg.RecordDir != "" {
+		var r *recorder.Recorder
+		g.Append("recorder", lifecycle.Hook{
+			OnStart: func(context.Context) error {
+				r = recorder.New(cfg.RecordDir)
+				recorder.SetDefault(r)
+				return nil
+			},
+			OnStop: func(context.Context) error {
+				recorder.SetDefault(nil)
+				return r.Close()
+			},
+		})
+	}
+
+	// Every exchange adapter registered by the indodax and
+	// websocket packages that has symbols configured
+	g.Append("exchanges", lifecycle.Hook{
+		OnStart: func(context.Context) error {
+			if err := orderbook.InitExchanges(); err != nil {
+				return err
+			}
+			registerLiveExecutors(cfg)
+			return nil
+		},
+		OnStop: orderbook.CloseExchanges,
+	})
+
+	// The orders of the exchanges listed in the paper settings
+	// go to simulators starting with their balances
+	for _, key := range cfg.Paper.Exchanges {
+		var simulator *paper.Exchange
+		key := key
+		g.Append("paper "+string(key), lifecycle.Hook{
+			OnStart: func(context.Context) error {
+				simulator = paper.New(key, cfg.Paper.Balances)
+				simulator.Start()
+				orders.RegisterExecutor(simulator)
+				log.Info("paper trading", "exchange", key, "balances", cfg.Paper.Balances)
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				return lifecycle.Wait(ctx, simulator.Stop)
+			},
+		})
+	}
+
+	rates = fx.NewService()
+	g.Append("rates", lifecycle.Service(rates.Start, rates.Stop))
+
+	detector := arbitrage.NewDetector(decimal.Zero)
+	detector.Convert = rates.Convert
+	g.Append("detector", lifecycle.Service(func() {
+		go logOpportunities(detector.Subscribe())
+		detector.Start()
+	}, detector.Stop))
+
+	consolidated = orderbook.NewConsolidatedBook("ETH", "USDT")
+	consolidated.Convert = rates.Convert
+	g.Append("consolidated book", lifecycle.Service(consolidated.Start, consolidated.Stop))
+
+	g.Append("server", newServer(cfg.Addr))
+	return &g
+}
+
+// registerLiveExecutors routes the orders of the exchanges whose adapter
+// has API credentials to it, except those of the paper exchanges
+func registerLiveExecutors(cfg config.Config) {
+	simulated := make(map[orderbook.ExchangeKey]bool)
+	for _, key := range cfg.Paper.Exchanges {
+		simulated[key] = true
+	}
+	for key, exchange := range orderbook.Exchanges {
+		executor, ok := exchange.Adapter.(orders.Executor)
+		configurable, configured := exchange.Adapter.(orderbook.Configurable)
+		if !ok || !configured || !configurable.HasCredentials() || simulated[key] {
+			continue
 		}
+		orders.RegisterExecutor(executor)
+		log.Info("live trading", "exchange", key)
 	}
-	indodax.InitOrderBook()
-	initOrderbookWebsocket()
 }
 
-func main() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+// referenceCurrency is the currency profits are reported in
+const referenceCurrency = "USDT"
+
+// rates converts the quote currencies of the exchanges to referenceCurrency
+var rates *fx.Service
+
+func logOpportunities(opportunities <-chan arbitrage.Opportunity) {
+	for o := range opportunities {
+		quote := orderbook.GetRightCurrency(string(o.Symbol))
+		profit, _ := rates.Convert(o.Profit, quote, referenceCurrency)
+		log.Info("Arbitrage opportunity",
+			"symbol", o.Symbol,
+			"sell_symbol", o.SellSymbol,
+			"buy", o.BuyVenue,
+			"sell", o.SellVenue,
+			"qty", o.Qty,
+			"profit", o.Profit,
+			"profit_"+strings.ToLower(referenceCurrency), profit,
+		)
 	}
+}
+
+// server serves the dashboard and the orders API
+type server struct {
+	app  *iris.Application
+	addr string
+
+	// the websocket clients stream the books until quit is closed
+	quit    chan struct{}
+	clients sync.WaitGroup
+}
 
-	app := iris.New()
-	app.Get("/", func(ctx iris.Context) {
+func newServer(addr string) *server {
+	s := &server{
+		app:  iris.New(),
+		addr: addr,
+		quit: make(chan struct{}),
+	}
+	s.app.Get("/", func(ctx iris.Context) {
 		ctx.ServeFile("view/websockets.html", false)
 	})
 
-	go updateDepthToWorker()
+	// Orders posted by the create_order form of the dashboard
+	ordersHandler := iris.FromStd(orders.Handler(orders.DefaultManager))
+	s.app.Any(orders.Prefix, ordersHandler)
+	s.app.Any(orders.Prefix+"/{client_order_id}", ordersHandler)
+
+	// Health of the feeds, the books and the detection, see package metrics
+	s.app.Get("/metrics", iris.FromStd(metrics.Handler()))
+
+	// Levels of the logs, changed at runtime, see package logging
+	s.app.Any("/admin/log-level", iris.FromStd(logging.Handler()))
 
 	// Using iris websocket to show orderbook updates (for testing purposes)
-	// Open Localhost 8080 to start orderbook websocket
-	setupWebsocket(app)
-	app.Run(iris.Addr(":8080"))
+	// Open the configured address to start orderbook websocket
+	s.setupWebsocket()
+	return s
 }
 
-func updateDepthToWorker() {
-	worker := indodax.InitWorker()
-	ticker := time.NewTicker(5 * time.Second)
+// Start listens on the address in the background, the
+// signals are handled by main rather than by iris
+func (s *server) Start(context.Context) error {
 	go func() {
-		for range ticker.C {
-			d := indodax.IndodaxInstance.GetDepth("eth_idr")
-			worker.PushDepthUpdate(d)
+		err := s.app.Run(iris.Addr(s.addr), iris.WithoutInterruptHandler, iris.WithoutServerError(iris.ErrServerClosed))
+		if err != nil {
+			log.Fatal("Error serving", "addr", s.addr, "err", err)
 		}
 	}()
+	return nil
 }
 
-func initOrderbookWebsocket() {
-	go websocket.InitBinanceHandler()
+// Stop stops accepting requests, waits for the ones in flight,
+// and disconnects the websocket clients
+func (s *server) Stop(ctx context.Context) error {
+	err := s.app.Shutdown(ctx)
+	close(s.quit)
+	if waitErr := lifecycle.Wait(ctx, s.clients.Wait); err == nil {
+		err = waitErr
+	}
+	return err
 }
 
-func setupWebsocket(app *iris.Application) {
+func (s *server) setupWebsocket() {
 	// create our echo websocket server
 	ws := irisWs.New(irisWs.Config{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
 	})
-	ws.OnConnection(handleConnection)
+	ws.OnConnection(s.handleConnection)
 	// register the server on an endpoint.
 	// see the inline javascript code in the websockets.html,
 	// this endpoint is used to connect to the server.
-	app.Get("/echo", ws.Handler())
+	s.app.Get("/echo", ws.Handler())
 	// serve the javascript built'n client-side library,
 	// see websockets.html script tags, this path is used.
-	app.Any("/iris-ws.js", irisWs.ClientHandler())
+	s.app.Any("/iris-ws.js", irisWs.ClientHandler())
+}
+
+// consolidated merges the ETH books of every exchange for the dashboard
+var consolidated *orderbook.ConsolidatedBook
+
+// weightedQty is the base quantity the dashboard averages the books over
+var weightedQty = decimal.New(1, 0)
+
+// weightedPrice is the simulated fill of weightedQty on a book
+type weightedPrice struct {
+	Exchange orderbook.ExchangeKey
+	Symbol   orderbook.Symbol
+	orderbook.Fill
+}
+
+// bookTop is the best level of a side of a book,
+// the books of an exchange share the same events
+type bookTop struct {
+	Symbol orderbook.Symbol
+	orderbook.Order
 }
 
-func handleConnection(c irisWs.Connection) {
+// handleConnection streams the books to a client every second,
+// until it disconnects or the server stops
+func (s *server) handleConnection(c irisWs.Connection) {
+	disconnected := make(chan struct{})
+	c.OnDisconnect(func() { close(disconnected) })
 	ticker := time.NewTicker(1 * time.Second)
-	binOrderBook := orderbook.Exchanges[orderbook.Binance].Books[orderbook.BTC_USDC]
-	idxOrderBook := indodax.GetOB()
+	s.clients.Add(1)
+	metrics.WebsocketClients.Inc()
 	go func() {
-		for range ticker.C {
-			if !binOrderBook.Empty() {
-				// bids := make(map[int64]float64)
-				// bidIter := binOrderBook.IteratorBuySide()
-				// asks := make(map[int64]float64)
-				// askIter := binOrderBook.IteratorSellSide()
-
-				// okBid := bidIter.Next()
-				// for okBid {
-				// 	o := bidIter.Value().(orderbook.Order)
-				// 	bids[int64(o.Price*1000000)] = o.Qty
-				// 	okBid = bidIter.Next()
-				// }
-				// okAsk := askIter.Next()
-				// for okAsk {
-				// 	o := askIter.Value().(orderbook.Order)
-				// 	asks[int64(o.Price*1000000)] = o.Qty
-				// 	okAsk = askIter.Next()
-				// }
-				// c.Emit("bin_orderbook", map[string]map[int64]float64{
-				// 	"Bids": bids,
-				// 	"Asks": asks,
-				// })
-				c.Emit("bin_orderbook_buy", binOrderBook.TopPriceBuySide())
-				c.Emit("bin_orderbook_sell", binOrderBook.LowPriceSellSide())
+		defer s.clients.Done()
+		defer metrics.WebsocketClients.Dec()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-disconnected:
+				return
+			case <-s.quit:
+				c.Disconnect()
+				return
+			case <-ticker.C:
 			}
-			if !idxOrderBook.Empty() {
-				c.Emit("idx_orderbook_buy", idxOrderBook.TopPriceBuySide())
-				c.Emit("idx_orderbook_sell", idxOrderBook.LowPriceSellSide())
+			for key, ex := range orderbook.Exchanges {
+				prefix := strings.ToLower(string(key))
+				for symbol, book := range ex.Books {
+					bid, ask, ok := book.BBO()
+					if !ok {
+						continue
+					}
+					c.Emit(prefix+"_orderbook_buy", bookTop{symbol, bid})
+					c.Emit(prefix+"_orderbook_sell", bookTop{symbol, ask})
+					c.Emit("bestBid", weightedPrice{key, symbol, book.FillSell(weightedQty)})
+					c.Emit("bestAsk", weightedPrice{key, symbol, book.FillBuy(weightedQty)})
+				}
 			}
+			c.Emit("consolidated_top_10_buy", consolidated.Bids(10))
+			c.Emit("consolidated_top_10_sell", consolidated.Asks(10))
 		}
 	}()
 }
@@ -0,0 +1,154 @@
+// Package metrics exposes the health of the feeds, the books and the
+// detection to Prometheus. The feeds update the metrics below as they
+// go, the state of the books and the queues is read at every scrape.
+package metrics
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "arbitrage"
+
+var (
+	// DepthEvents counts the depth events streamed or polled per book
+	DepthEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "depth_events_total",
+		Help:      "Depth events received, streamed or polled.",
+	}, []string{"exchange", "symbol"})
+
+	// DepthGaps counts the events breaking the update ID sequence of a book
+	DepthGaps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "depth_gaps_total",
+		Help:      "Depth events breaking the update ID sequence of the book.",
+	}, []string{"exchange", "symbol"})
+
+	// Resyncs counts the books rebuilt from a snapshot after a gap
+	Resyncs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "resyncs_total",
+		Help:      "Books rebuilt from a snapshot after a gap in the depth stream.",
+	}, []string{"exchange", "symbol"})
+
+	// PollDuration times the depth requests of the polled exchanges
+	PollDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "poll_duration_seconds",
+		Help:      "Latency of the depth polls.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 8),
+	}, []string{"exchange", "symbol"})
+
+	// HTTPErrors counts the requests to the exchanges that failed
+	// to get a response or got an error status
+	HTTPErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_errors_total",
+		Help:      "Requests to the exchanges failing or answered with an error status.",
+	}, []string{"exchange", "endpoint"})
+
+	// Opportunities counts the evaluations finding an opportunity
+	Opportunities = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "opportunities_total",
+		Help:      "Book evaluations finding an arbitrage opportunity.",
+	}, []string{"symbol", "buy", "sell", "sell_symbol"})
+
+	// OpportunityProfit is the simulated profit of the last evaluation
+	// of every route, zero once it is not profitable anymore
+	OpportunityProfit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "opportunity_profit",
+		Help:      "Simulated profit of the opportunity, in the quote currency of symbol.",
+	}, []string{"symbol", "buy", "sell", "sell_symbol"})
+
+	// WebsocketClients is the number of dashboards connected
+	WebsocketClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_clients",
+		Help:      "Websocket clients of the dashboard.",
+	})
+)
+
+var (
+	bookLevels = prometheus.NewDesc(namespace+"_book_levels",
+		"Price levels of a side of the book.", []string{"exchange", "symbol", "side"}, nil)
+	bookSpread = prometheus.NewDesc(namespace+"_book_spread",
+		"Best ask minus best bid, in the quote currency.", []string{"exchange", "symbol"}, nil)
+	bookAge = prometheus.NewDesc(namespace+"_book_last_update_age_seconds",
+		"Time since the book was last changed.", []string{"exchange", "symbol"}, nil)
+	queueLength = prometheus.NewDesc(namespace+"_queue_length",
+		"Items waiting in a queue of the workers.", []string{"queue"}, nil)
+)
+
+var (
+	queues   = make(map[string]func() int)
+	queuesMu sync.RWMutex
+)
+
+// SetQueue reports the length of a queue, replacing the queue
+// reported under the same name
+func SetQueue(name string, length func() int) {
+	queuesMu.Lock()
+	defer queuesMu.Unlock()
+	queues[name] = length
+}
+
+func init() {
+	prometheus.MustRegister(collector{now: time.Now})
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// collector reads the books of orderbook.Exchanges and the queues
+type collector struct {
+	now func() time.Time
+}
+
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bookLevels
+	ch <- bookSpread
+	ch <- bookAge
+	ch <- queueLength
+}
+
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	now := c.now()
+	for key, ex := range orderbook.Exchanges {
+		for symbol, book := range ex.Books {
+			exchange, symbol := string(key), string(symbol)
+			bids, asks := book.Levels()
+			ch <- prometheus.MustNewConstMetric(bookLevels, prometheus.GaugeValue, float64(bids), exchange, symbol, "bid")
+			ch <- prometheus.MustNewConstMetric(bookLevels, prometheus.GaugeValue, float64(asks), exchange, symbol, "ask")
+			if bid, ask, ok := book.BBO(); ok {
+				spread, _ := ask.Price.Sub(bid.Price).Float64()
+				ch <- prometheus.MustNewConstMetric(bookSpread, prometheus.GaugeValue, spread, exchange, symbol)
+			}
+			if updated := book.Updated(); !updated.IsZero() {
+				ch <- prometheus.MustNewConstMetric(bookAge, prometheus.GaugeValue, now.Sub(updated).Seconds(), exchange, symbol)
+			}
+		}
+	}
+
+	queuesMu.RLock()
+	defer queuesMu.RUnlock()
+	names := make([]string, 0, len(queues))
+	for name := range queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ch <- prometheus.MustNewConstMetric(queueLength, prometheus.GaugeValue, float64(queues[name]()), name)
+	}
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+var d = decimal.RequireFromString
+
+func TestHandler(t *testing.T) {
+	exchanges := orderbook.Exchanges
+	defer func() { orderbook.Exchanges = exchanges }()
+	book := orderbook.NewOrderBook()
+	book.Apply([]orderbook.Order{
+		{Price: d("100"), Qty: d("1")},
+		{Price: d("99"), Qty: d("1")},
+	}, []orderbook.Order{
+		{Price: d("100.5"), Qty: d("1")},
+	})
+	orderbook.Exchanges = orderbook.ExchangeMap{
+		orderbook.Binance: {Books: orderbook.OrderBookMap{
+			orderbook.BTC_USDC: book,
+			orderbook.ETH_USDT: orderbook.NewOrderBook(),
+		}},
+	}
+	queue := make(chan int, 4)
+	queue <- 1
+	SetQueue("test", func() int { return len(queue) })
+	DepthEvents.WithLabelValues("Binance", "BTC/USDC").Inc()
+
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+	res, err := server.Client().Get(server.URL)
+	assert.Nil(t, err)
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Nil(t, err)
+
+	out := string(body)
+	assert.Contains(t, out, `arbitrage_depth_events_total{exchange="Binance",symbol="BTC/USDC"} 1`)
+	assert.Contains(t, out, `arbitrage_book_levels{exchange="Binance",side="bid",symbol="BTC/USDC"} 2`)
+	assert.Contains(t, out, `arbitrage_book_levels{exchange="Binance",side="ask",symbol="BTC/USDC"} 1`)
+	assert.Contains(t, out, `arbitrage_book_spread{exchange="Binance",symbol="BTC/USDC"} 0.5`)
+	assert.Contains(t, out, `arbitrage_book_last_update_age_seconds{exchange="Binance",symbol="BTC/USDC"}`)
+	assert.Contains(t, out, `arbitrage_queue_length{queue="test"} 1`)
+	// an empty book has no spread, and was never updated
+	assert.Contains(t, out, `arbitrage_book_levels{exchange="Binance",side="bid",symbol="ETH/USDT"} 0`)
+	assert.NotContains(t, out, `arbitrage_book_spread{exchange="Binance",symbol="ETH/USDT"}`)
+	assert.NotContains(t, out, `arbitrage_book_last_update_age_seconds{exchange="Binance",symbol="ETH/USDT"}`)
+}
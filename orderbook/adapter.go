@@ -0,0 +1,221 @@
+package orderbook
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/logging"
+	"github.com/shopspring/decimal"
+)
+
+var log = logging.New("orderbook")
+
+// offTickLog reports the levels priced off their tick size
+var offTickLog = log.Sampled(1, 1000)
+
+// BookUpdate is emitted by an Adapter every time it has applied
+// a change to one of its books. Bids and Asks only carry the levels
+// touched by the change, a zero Qty meaning the level was removed.
+type BookUpdate struct {
+	Exchange ExchangeKey
+	Symbol   Symbol
+	Bids     []Order
+	Asks     []Order
+	Snapshot bool // The update replaced the whole book
+}
+
+// Adapter is the common interface every exchange integration satisfies.
+// An adapter maintains the books registered for its exchange in
+// Exchanges, and streams a BookUpdate after each change it applies.
+type Adapter interface {
+	// Key returns the exchange the adapter is for
+	Key() ExchangeKey
+	// Connect opens the connections needed to talk to the exchange
+	Connect() error
+	// Subscribe starts maintaining the books for the given symbols
+	Subscribe(symbols ...Symbol) error
+	// Updates streams the changes applied to the books
+	Updates() <-chan BookUpdate
+	// Snapshot fetches the full current depth for a symbol
+	Snapshot(symbol Symbol) (BookUpdate, error)
+	// Fee returns the fill cost multiplier for trading a symbol, e.g. 1.001
+	Fee(symbol Symbol) decimal.Decimal
+	// Close stops all the feeds and releases the connections
+	Close() error
+}
+
+// AdapterConfig is the configuration of an exchange adapter
+type AdapterConfig struct {
+	Symbols      []Symbol          `yaml:"symbols"`                 // Books maintained, the adapter is not started without any
+	URLs         map[string]string `yaml:"urls,omitempty"`          // Endpoints by name, each adapter documents its names
+	PollInterval time.Duration     `yaml:"poll_interval,omitempty"` // How often the adapters polling the depth poll it
+	APIKey       string            `yaml:"api_key"`
+	APISecret    string            `yaml:"api_secret"`
+}
+
+// Configurable is implemented by the adapters taking a configuration
+type Configurable interface {
+	// Defaults returns the configuration the adapter runs with
+	// when it is not configured
+	Defaults() AdapterConfig
+	// Configure applies a configuration, before the adapter connects
+	Configure(cfg AdapterConfig) error
+	// HasCredentials tells whether the adapter was configured
+	// with the API credentials its exchange trades with
+	HasCredentials() bool
+}
+
+var (
+	adapters = make(map[ExchangeKey]Adapter)
+
+	// subscribers receive the updates of every adapter
+	subscribers   = make(map[chan BookUpdate]struct{})
+	subscribersMu sync.RWMutex
+
+	// forwarders relay the updates of the adapters to the subscribers
+	// until quitForwarding is closed by CloseExchanges
+	forwarders     sync.WaitGroup
+	quitForwarding = make(chan struct{})
+)
+
+// RegisterAdapter makes an exchange adapter available to InitExchanges.
+// It is meant to be called from the init function of the adapter's package.
+func RegisterAdapter(a Adapter) {
+	adapters[a.Key()] = a
+}
+
+// GetAdapter returns the registered adapter for an exchange
+func GetAdapter(key ExchangeKey) (Adapter, bool) {
+	a, ok := adapters[key]
+	return a, ok
+}
+
+// Adapters returns the registered adapters, ordered by exchange
+func Adapters() []Adapter {
+	keys := make([]string, 0, len(adapters))
+	for key := range adapters {
+		keys = append(keys, string(key))
+	}
+	sort.Strings(keys)
+	list := make([]Adapter, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, adapters[ExchangeKey(k)])
+	}
+	return list
+}
+
+// InitExchanges populates Exchanges from the registered adapters,
+// creating a book for every symbol SymbolMap lists for the exchange,
+// then connects and subscribes each adapter to its symbols. Adapters
+// without any symbol in SymbolMap are not started.
+func InitExchanges() error {
+	for _, a := range Adapters() {
+		symbols := SymbolMap.SymbolsForExchange(a.Key())
+		if len(symbols) == 0 {
+			continue
+		}
+		ex := Exchange{
+			Books:   make(OrderBookMap),
+			Adapter: a,
+		}
+		for _, symbol := range symbols {
+			ex.Books[symbol] = NewOrderBookWithTick(TickSize(a.Key(), symbol))
+		}
+		Exchanges[a.Key()] = ex
+
+		if err := a.Connect(); err != nil {
+			return fmt.Errorf("connecting to %v: %v", a.Key(), err)
+		}
+		if err := a.Subscribe(symbols...); err != nil {
+			return fmt.Errorf("subscribing to %v: %v", a.Key(), err)
+		}
+		forwarders.Add(1)
+		go forwardUpdates(a.Updates(), quitForwarding)
+	}
+	return nil
+}
+
+// SubscribeUpdates returns a channel receiving the BookUpdates of every
+// exchange. Updates are dropped for subscribers that do not keep up,
+// the books themselves always hold the latest state.
+func SubscribeUpdates() <-chan BookUpdate {
+	ch := make(chan BookUpdate, 256)
+	subscribersMu.Lock()
+	subscribers[ch] = struct{}{}
+	subscribersMu.Unlock()
+	return ch
+}
+
+// UnsubscribeUpdates stops sending updates to a channel
+// returned by SubscribeUpdates, and closes it
+func UnsubscribeUpdates(updates <-chan BookUpdate) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	for ch := range subscribers {
+		if ch == updates {
+			delete(subscribers, ch)
+			close(ch)
+			return
+		}
+	}
+}
+
+func forwardUpdates(updates <-chan BookUpdate, quit <-chan struct{}) {
+	defer forwarders.Done()
+	for {
+		var update BookUpdate
+		select {
+		case update = <-updates:
+		case <-quit:
+			return
+		}
+		subscribersMu.RLock()
+		for ch := range subscribers {
+			select {
+			case ch <- update:
+			default:
+			}
+		}
+		subscribersMu.RUnlock()
+	}
+}
+
+// CloseExchanges closes every adapter behind Exchanges in the order
+// they were started, then stops forwarding their updates. It returns
+// ctx.Err() when ctx is done before every adapter has closed.
+func CloseExchanges(ctx context.Context) error {
+	keys := make([]string, 0, len(Exchanges))
+	for key := range Exchanges {
+		keys = append(keys, string(key))
+	}
+	sort.Strings(keys)
+	var started []Adapter
+	for _, k := range keys {
+		if a := Exchanges[ExchangeKey(k)].Adapter; a != nil {
+			started = append(started, a)
+		}
+	}
+
+	quit := quitForwarding
+	quitForwarding = make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, a := range started {
+			if err := a.Close(); err != nil {
+				log.Error("error closing adapter", "exchange", a.Key(), "err", err)
+			}
+		}
+		close(quit)
+		forwarders.Wait()
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
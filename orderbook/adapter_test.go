@@ -0,0 +1,92 @@
+package orderbook
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/lifecycle"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAdapter struct {
+	key        ExchangeKey
+	connected  bool
+	subscribed []Symbol
+	updates    chan BookUpdate
+	closed     bool
+	block      chan struct{} // Close waits for it when set
+}
+
+func (f *fakeAdapter) Key() ExchangeKey                    { return f.key }
+func (f *fakeAdapter) Connect() error                      { f.connected = true; return nil }
+func (f *fakeAdapter) Updates() <-chan BookUpdate          { return f.updates }
+func (f *fakeAdapter) Fee(symbol Symbol) decimal.Decimal   { return decimal.Zero }
+func (f *fakeAdapter) Snapshot(Symbol) (BookUpdate, error) { return BookUpdate{}, nil }
+func (f *fakeAdapter) Subscribe(symbols ...Symbol) error {
+	f.subscribed = append(f.subscribed, symbols...)
+	return nil
+}
+func (f *fakeAdapter) Close() error {
+	if f.block != nil {
+		<-f.block
+	}
+	f.closed = true
+	return nil
+}
+
+func TestInitExchanges(t *testing.T) {
+	fake := &fakeAdapter{key: "Fake"}
+	idle := &fakeAdapter{key: "Idle"}
+	adapters = map[ExchangeKey]Adapter{fake.key: fake, idle.key: idle}
+	SymbolMap[BTC_ETH] = []ExchangeKey{fake.key}
+	defer func() {
+		delete(SymbolMap, BTC_ETH)
+		delete(Exchanges, fake.key)
+		adapters = make(map[ExchangeKey]Adapter)
+	}()
+
+	assert.Nil(t, InitExchanges())
+	assert.True(t, fake.connected)
+	assert.Equal(t, []Symbol{BTC_ETH}, fake.subscribed)
+	assert.NotNil(t, Exchanges[fake.key].Books[BTC_ETH])
+	assert.Equal(t, fake, Exchanges[fake.key].Adapter)
+
+	// no symbol is listed for idle
+	assert.False(t, idle.connected)
+	_, ok := Exchanges[idle.key]
+	assert.False(t, ok)
+}
+
+func TestCloseExchanges(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	fake := &fakeAdapter{key: "Fake", updates: make(chan BookUpdate)}
+	adapters = map[ExchangeKey]Adapter{fake.key: fake}
+	SymbolMap[BTC_ETH] = []ExchangeKey{fake.key}
+	defer func() {
+		delete(SymbolMap, BTC_ETH)
+		delete(Exchanges, fake.key)
+		adapters = make(map[ExchangeKey]Adapter)
+	}()
+
+	updates := SubscribeUpdates()
+	defer UnsubscribeUpdates(updates)
+	assert.Nil(t, InitExchanges())
+	fake.updates <- BookUpdate{Exchange: fake.key, Symbol: BTC_ETH}
+	assert.Equal(t, BTC_ETH, (<-updates).Symbol)
+
+	assert.Nil(t, CloseExchanges(context.Background()))
+	assert.True(t, fake.closed)
+	// the updates are not forwarded anymore
+	assert.Zero(t, lifecycle.Leaked(goroutines, time.Second))
+
+	// an adapter that does not close in time
+	fake.block = make(chan struct{})
+	assert.Nil(t, InitExchanges())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, CloseExchanges(ctx))
+	close(fake.block)
+}
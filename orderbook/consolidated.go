@@ -0,0 +1,406 @@
+package orderbook
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// QuoteConverter converts an amount of the from currency
+// to the to currency, ok is false when the rate is unknown
+type QuoteConverter func(amount decimal.Decimal, from, to string) (converted decimal.Decimal, ok bool)
+
+// ConvertWithBooks is the default QuoteConverter, it converts
+// at the mid price of any book trading the two currencies
+func ConvertWithBooks(amount decimal.Decimal, from, to string) (decimal.Decimal, bool) {
+	if from == to {
+		return amount, true
+	}
+	two := decimal.New(2, 0)
+	for _, ex := range Exchanges {
+		for symbol, book := range ex.Books {
+			left, right := GetLeftCurrency(string(symbol)), GetRightCurrency(string(symbol))
+			if (left != from || right != to) && (left != to || right != from) {
+				continue
+			}
+			bid, ask, ok := book.BBO()
+			if !ok {
+				continue
+			}
+			mid := bid.Price.Add(ask.Price).Div(two)
+			if left == to {
+				return amount.Div(mid), true
+			}
+			return amount.Mul(mid), true
+		}
+	}
+	return decimal.Zero, false
+}
+
+// ConsolidatedLevel is a level of a ConsolidatedBook. Its Price is
+// converted to the quote currency of the book and includes the fees
+// of its exchange, the level as listed by the exchange is kept in Raw.
+type ConsolidatedLevel struct {
+	Order
+	Symbol Symbol
+	Raw    decimal.Decimal // Price on the exchange, in the quote currency of Symbol
+}
+
+type source struct {
+	Exchange ExchangeKey
+	Symbol   Symbol
+}
+
+// ConsolidatedBook merges the books of every exchange trading Base,
+// whatever their quote currency, into a single ordered view quoted in
+// Quote. Each exchange keeps its own levels, so that every level of
+// the view can be attributed to the exchange it comes from.
+//
+// The view is kept up to date level by level from the BookUpdates,
+// the levels of a book are only all converted again when the book is
+// replaced or the rate of its quote currency moves.
+type ConsolidatedBook struct {
+	Base, Quote string
+	Convert     QuoteConverter
+
+	bids, asks *consolidatedSide
+	mu         sync.RWMutex
+	quit       chan struct{}
+	done       chan struct{}
+}
+
+// NewConsolidatedBook creates a book of base quoted in quote,
+// converting the other quote currencies with ConvertWithBooks
+func NewConsolidatedBook(base, quote string) *ConsolidatedBook {
+	return &ConsolidatedBook{
+		Base:    base,
+		Quote:   quote,
+		Convert: ConvertWithBooks,
+		bids:    newConsolidatedSide(true),
+		asks:    newConsolidatedSide(false),
+		quit:    make(chan struct{}),
+	}
+}
+
+// Start builds the book from every exchange and keeps it updated
+func (cb *ConsolidatedBook) Start() {
+	updates := SubscribeUpdates()
+	cb.RefreshAll()
+	cb.done = make(chan struct{})
+	go func() {
+		defer close(cb.done)
+		defer UnsubscribeUpdates(updates)
+		for {
+			select {
+			case update := <-updates:
+				cb.Apply(update)
+			case <-cb.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops updating the book
+func (cb *ConsolidatedBook) Stop() {
+	close(cb.quit)
+	<-cb.done
+}
+
+// Apply applies an update to the view: the levels it carries when it
+// is for a book trading Base, the new rate of the books converted
+// through it when it trades their quote currency. Other updates don't
+// affect the book.
+func (cb *ConsolidatedBook) Apply(update BookUpdate) {
+	left := GetLeftCurrency(string(update.Symbol))
+	right := GetRightCurrency(string(update.Symbol))
+	if left == cb.Base {
+		src := source{update.Exchange, update.Symbol}
+		cb.mu.RLock()
+		known := cb.bids.has(src) || cb.asks.has(src)
+		cb.mu.RUnlock()
+		switch {
+		case update.Snapshot:
+			cb.replace(src, update.Bids, update.Asks)
+		case !known:
+			cb.Refresh(update.Exchange, update.Symbol)
+		default:
+			cb.applyLevels(src, update)
+		}
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	for _, src := range cb.sources() {
+		quote := GetRightCurrency(string(src.Symbol))
+		if quote != cb.Quote && (quote == left || quote == right) {
+			cb.reprice(src)
+		}
+	}
+}
+
+// RefreshAll rebuilds the levels of every book trading Base
+func (cb *ConsolidatedBook) RefreshAll() {
+	for key, ex := range Exchanges {
+		for symbol := range ex.Books {
+			if GetLeftCurrency(string(symbol)) == cb.Base {
+				cb.Refresh(key, symbol)
+			}
+		}
+	}
+}
+
+// Refresh rebuilds the levels of one book from its whole depth. They
+// are dropped when the book is gone or its quote currency can't be
+// converted.
+func (cb *ConsolidatedBook) Refresh(key ExchangeKey, symbol Symbol) {
+	book, found := Exchanges[key].Books[symbol]
+	if !found {
+		cb.mu.Lock()
+		defer cb.mu.Unlock()
+		cb.drop(source{key, symbol})
+		return
+	}
+	depth := book.Depth(0)
+	cb.replace(source{key, symbol}, depth.Bids, depth.Asks)
+}
+
+// replace replaces the levels of a book
+func (cb *ConsolidatedBook) replace(src source, bids, asks []Order) {
+	bidLevels, ok := cb.normalise(bids, src, false)
+	var askLevels []ConsolidatedLevel
+	if ok {
+		askLevels, ok = cb.normalise(asks, src, true)
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if !ok {
+		cb.drop(src)
+		return
+	}
+	cb.bids.replace(src, bidLevels)
+	cb.asks.replace(src, askLevels)
+}
+
+// applyLevels sets the levels an update touched
+func (cb *ConsolidatedBook) applyLevels(src source, update BookUpdate) {
+	bids, ok := cb.normalise(update.Bids, src, false)
+	var asks []ConsolidatedLevel
+	if ok {
+		asks, ok = cb.normalise(update.Asks, src, true)
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if !ok {
+		cb.drop(src)
+		return
+	}
+	for _, l := range bids {
+		cb.bids.set(l)
+	}
+	for _, l := range asks {
+		cb.asks.set(l)
+	}
+}
+
+// reprice converts the levels of a book again, dropping
+// them when its quote currency can't be converted anymore
+func (cb *ConsolidatedBook) reprice(src source) {
+	bids, ok := cb.normalise(cb.bids.raw(src), src, false)
+	var asks []ConsolidatedLevel
+	if ok {
+		asks, ok = cb.normalise(cb.asks.raw(src), src, true)
+	}
+	if !ok {
+		cb.drop(src)
+		return
+	}
+	cb.bids.replace(src, bids)
+	cb.asks.replace(src, asks)
+}
+
+func (cb *ConsolidatedBook) drop(src source) {
+	cb.bids.replace(src, nil)
+	cb.asks.replace(src, nil)
+}
+
+// sources returns the books in the view
+func (cb *ConsolidatedBook) sources() []source {
+	seen := make(map[source]bool)
+	var sources []source
+	for _, side := range []*consolidatedSide{cb.bids, cb.asks} {
+		for src := range side.levels {
+			if !seen[src] {
+				seen[src] = true
+				sources = append(sources, src)
+			}
+		}
+	}
+	return sources
+}
+
+// Bids returns up to levels of the best bids, every bid when levels is 0
+func (cb *ConsolidatedBook) Bids(levels int) []ConsolidatedLevel {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	return top(cb.bids.view, levels)
+}
+
+// Asks returns up to levels of the best asks, every ask when levels is 0
+func (cb *ConsolidatedBook) Asks(levels int) []ConsolidatedLevel {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	return top(cb.asks.view, levels)
+}
+
+func top(levels []ConsolidatedLevel, n int) []ConsolidatedLevel {
+	if n <= 0 || n > len(levels) {
+		n = len(levels)
+	}
+	return append([]ConsolidatedLevel(nil), levels[:n]...)
+}
+
+// normalise converts the prices of a side to the quote currency,
+// adding the fees to the asks and removing them from the bids
+func (cb *ConsolidatedBook) normalise(orders []Order, src source, ask bool) ([]ConsolidatedLevel, bool) {
+	from := GetRightCurrency(string(src.Symbol))
+	levels := make([]ConsolidatedLevel, 0, len(orders))
+	for _, o := range orders {
+		fee := fillCostRate(o.FillCost)
+		if !ask {
+			fee = fee.Neg()
+		}
+		price, ok := cb.Convert(o.Price.Mul(decimal.New(1, 0).Add(fee)), from, cb.Quote)
+		if !ok {
+			return nil, false
+		}
+		level := ConsolidatedLevel{Order: o, Symbol: src.Symbol, Raw: o.Price}
+		level.Price = price
+		level.ExchangeKey = src.Exchange
+		levels = append(levels, level)
+	}
+	return levels, true
+}
+
+// consolidatedSide is the bids or the asks of a ConsolidatedBook
+type consolidatedSide struct {
+	bids   bool
+	levels map[source]map[string]ConsolidatedLevel // Levels of each book, by raw price
+	view   []ConsolidatedLevel                     // Levels of every book, best price first
+}
+
+func newConsolidatedSide(bids bool) *consolidatedSide {
+	return &consolidatedSide{
+		bids:   bids,
+		levels: make(map[source]map[string]ConsolidatedLevel),
+	}
+}
+
+func (s *consolidatedSide) has(src source) bool {
+	_, ok := s.levels[src]
+	return ok
+}
+
+// raw returns the levels of a book as listed by its exchange
+func (s *consolidatedSide) raw(src source) []Order {
+	orders := make([]Order, 0, len(s.levels[src]))
+	for _, l := range s.levels[src] {
+		o := l.Order
+		o.Price = l.Raw
+		orders = append(orders, o)
+	}
+	return orders
+}
+
+// set adds, moves or removes, when its quantity is zero, a level of a book
+func (s *consolidatedSide) set(l ConsolidatedLevel) {
+	src := source{l.ExchangeKey, l.Symbol}
+	key := l.Raw.String()
+	if old, ok := s.levels[src][key]; ok {
+		i := s.index(old)
+		s.view = append(s.view[:i], s.view[i+1:]...)
+		delete(s.levels[src], key)
+	}
+	if !l.Qty.IsPositive() {
+		return
+	}
+	i := s.index(l)
+	s.view = append(s.view, ConsolidatedLevel{})
+	copy(s.view[i+1:], s.view[i:])
+	s.view[i] = l
+	if s.levels[src] == nil {
+		s.levels[src] = make(map[string]ConsolidatedLevel)
+	}
+	s.levels[src][key] = l
+}
+
+// replace replaces the levels of a book, dropping the book when levels is nil
+func (s *consolidatedSide) replace(src source, levels []ConsolidatedLevel) {
+	kept := s.view[:0]
+	for _, l := range s.view {
+		if l.ExchangeKey != src.Exchange || l.Symbol != src.Symbol {
+			kept = append(kept, l)
+		}
+	}
+	if levels == nil {
+		s.view = kept
+		delete(s.levels, src)
+		return
+	}
+
+	byPrice := make(map[string]ConsolidatedLevel, len(levels))
+	added := make([]ConsolidatedLevel, 0, len(levels))
+	for _, l := range levels {
+		if l.Qty.IsPositive() {
+			byPrice[l.Raw.String()] = l
+			added = append(added, l)
+		}
+	}
+	s.levels[src] = byPrice
+	sort.Slice(added, func(i, j int) bool { return s.less(added[i], added[j]) })
+
+	// merge the sorted levels of the book into the others
+	view := make([]ConsolidatedLevel, 0, len(kept)+len(added))
+	i, j := 0, 0
+	for i < len(kept) && j < len(added) {
+		if s.less(added[j], kept[i]) {
+			view = append(view, added[j])
+			j++
+		} else {
+			view = append(view, kept[i])
+			i++
+		}
+	}
+	view = append(view, kept[i:]...)
+	s.view = append(view, added[j:]...)
+}
+
+// index returns where a level is, or would be, in the view
+func (s *consolidatedSide) index(l ConsolidatedLevel) int {
+	return sort.Search(len(s.view), func(i int) bool {
+		return !s.less(s.view[i], l)
+	})
+}
+
+// less orders the levels best price first, then by exchange and symbol.
+// The levels of a book converted to the same price keep the order of
+// their raw prices, so that every level has a single place in the view.
+func (s *consolidatedSide) less(a, b ConsolidatedLevel) bool {
+	if c := a.Price.Cmp(b.Price); c != 0 {
+		return (c > 0) == s.bids
+	}
+	if a.ExchangeKey != b.ExchangeKey {
+		return a.ExchangeKey < b.ExchangeKey
+	}
+	if a.Symbol != b.Symbol {
+		return a.Symbol < b.Symbol
+	}
+	if c := a.Raw.Cmp(b.Raw); c != 0 {
+		return (c > 0) == s.bids
+	}
+	return false
+}
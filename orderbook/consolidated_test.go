@@ -0,0 +1,81 @@
+package orderbook
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsolidatedBook(t *testing.T) {
+	ethUsdt, ethIdr, usdtIdr := NewOrderBook(), NewOrderBook(), NewOrderBook()
+	ethUsdt.AddBuy(Order{Price: d("199"), Qty: d("1"), FillCost: 1.001})
+	ethUsdt.AddSell(Order{Price: d("200"), Qty: d("2"), FillCost: 1.001})
+	ethIdr.AddBuy(Order{Price: d("3000000"), Qty: d("3")})
+	ethIdr.AddBuy(Order{Price: d("2970000"), Qty: d("4")})
+	ethIdr.AddSell(Order{Price: d("3030000"), Qty: d("5")})
+	usdtIdr.AddBuy(Order{Price: d("14900"), Qty: d("1000")})
+	usdtIdr.AddSell(Order{Price: d("15100"), Qty: d("1000")})
+
+	Exchanges[Binance] = Exchange{Books: OrderBookMap{ETH_USDT: ethUsdt}}
+	Exchanges[Indodax] = Exchange{Books: OrderBookMap{ETH_IDR: ethIdr, USDT_IDR: usdtIdr}}
+	defer delete(Exchanges, Binance)
+	defer delete(Exchanges, Indodax)
+
+	cb := NewConsolidatedBook("ETH", "USDT")
+	cb.RefreshAll()
+
+	// indodax converts at 15000 IDR per USDT, binance pays 0.1% fees
+	bids := cb.Bids(0)
+	assert.Len(t, bids, 3)
+	assert.Equal(t, Indodax, bids[0].ExchangeKey)
+	assert.Equal(t, ETH_IDR, bids[0].Symbol)
+	assert.Equal(t, "200", bids[0].Price.String())
+	assert.Equal(t, "3000000", bids[0].Raw.String())
+	assert.Equal(t, Binance, bids[1].ExchangeKey)
+	assert.Equal(t, "198.801", bids[1].Price.String())
+	assert.Equal(t, "198", bids[2].Price.String())
+
+	asks := cb.Asks(1)
+	assert.Len(t, asks, 1)
+	assert.Equal(t, Binance, asks[0].ExchangeKey)
+	assert.Equal(t, "200.2", asks[0].Price.String())
+
+	// binance's ask is taken, only the levels of the update are applied
+	cb.Apply(BookUpdate{Exchange: Binance, Symbol: ETH_USDT, Asks: []Order{{Price: d("200"), Qty: d("0"), FillCost: 1.001}}})
+	asks = cb.Asks(0)
+	assert.Len(t, asks, 1)
+	assert.Equal(t, Indodax, asks[0].ExchangeKey)
+	assert.Equal(t, "202", asks[0].Price.String())
+
+	cb.Apply(BookUpdate{Exchange: Indodax, Symbol: ETH_IDR, Bids: []Order{
+		{Price: d("2985000"), Qty: d("1")},
+		{Price: d("3000000"), Qty: d("2")},
+	}})
+	bids = cb.Bids(0)
+	assert.Len(t, bids, 4)
+	assert.Equal(t, "2", bids[0].Qty.String())
+	assert.Equal(t, "199", bids[1].Price.String())
+	assert.Equal(t, "1", bids[1].Qty.String())
+
+	// the books of other currencies don't move any level
+	Exchanges[Binance].Books[BTC_USDC] = NewOrderBook()
+	cb.Apply(BookUpdate{Exchange: Binance, Symbol: BTC_USDC, Bids: []Order{{Price: d("7000"), Qty: d("1")}}})
+	assert.Equal(t, bids, cb.Bids(0))
+
+	// a snapshot replaces the levels of its book
+	cb.Apply(BookUpdate{Exchange: Indodax, Symbol: ETH_IDR, Snapshot: true,
+		Bids: []Order{{Price: d("3000000"), Qty: d("3")}, {Price: d("2970000"), Qty: d("4")}},
+		Asks: []Order{{Price: d("3030000"), Qty: d("5")}},
+	})
+	assert.Len(t, cb.Bids(0), 3)
+
+	// the rupiah weakens, moving every indodax level
+	usdtIdr.Replace(
+		[]Order{{Price: d("29900"), Qty: d("1000")}},
+		[]Order{{Price: d("30100"), Qty: d("1000")}},
+	)
+	cb.Apply(BookUpdate{Exchange: Indodax, Symbol: USDT_IDR})
+	bids = cb.Bids(0)
+	assert.Equal(t, Binance, bids[0].ExchangeKey)
+	assert.Equal(t, "100", bids[1].Price.String())
+}
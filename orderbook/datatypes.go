@@ -1,8 +1,10 @@
 package orderbook
 
 import (
+	"sync"
+	"time"
+
 	"github.com/anthonychristian/crypto-arbitrage/skiplist"
-	// "github.com/alpacahq/gopaca/log"
 	"github.com/shopspring/decimal"
 )
 
@@ -10,9 +12,6 @@ type ExchangeMap map[ExchangeKey]Exchange
 
 var (
 	Exchanges = make(ExchangeMap)
-	ExFeeMap  = map[ExchangeKey]float64{ // Key: Exchange, Val: Fee
-		Binance: 1.001,
-	}
 )
 
 type ExchangeKey string
@@ -23,58 +22,202 @@ const (
 )
 
 type Exchange struct {
-	Books OrderBookMap // The key is the trading pair, e.g. "BTC/USDC"
+	Books   OrderBookMap // The key is the trading pair, e.g. "BTC/USDC"
+	Adapter Adapter      // The integration maintaining the books
 }
 
 type Order struct {
-	Price       float64 // Actual price on exchange
-	Qty         float64
+	Price       decimal.Decimal // Actual price on exchange
+	Qty         decimal.Decimal
 	FillCost    float64 // Percentage of the price for exchange trade cost, fees, etc
 	ExchangeKey ExchangeKey
 }
 
 type OrderBookMap map[Symbol]*OrderBook // Key is the currency pair, e.g. BTC/USDC
 
+// OrderBook is safe for concurrent use. Every write holds the book's
+// lock, so readers going through BBO or Depth never see half of a
+// depth event applied. The iterators walk the live sides without
+// the lock, they are not guaranteed to be consistent.
 type OrderBook struct {
 	buyside, sellside *skiplist.SkipList
+	tick              decimal.Decimal // Prices off it are logged, unless zero
+	updated           time.Time       // Last time a level was written
+	mu                sync.RWMutex
+}
+
+// Depth is a consistent copy of the levels of a book, best prices first
+type Depth struct {
+	Bids []Order
+	Asks []Order
 }
 
 func NewOrderBook() *OrderBook {
 	return &OrderBook{
-		skiplist.NewDecimalMapReverse(),
-		skiplist.NewDecimalMap(),
+		buyside:  skiplist.NewDecimalMapReverse(),
+		sellside: skiplist.NewDecimalMap(),
 	}
 }
 
+// NewOrderBookWithTick creates a book logging the levels priced
+// off the given tick size. The levels keep the exchange's price,
+// rounding them would merge distinct levels into one.
+func NewOrderBookWithTick(tick decimal.Decimal) *OrderBook {
+	ob := NewOrderBook()
+	ob.tick = tick
+	return ob
+}
+
+func (ob *OrderBook) buys() *skiplist.SkipList {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return ob.buyside
+}
+
+func (ob *OrderBook) sells() *skiplist.SkipList {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return ob.sellside
+}
+
 func (ob *OrderBook) AddBuy(order Order) {
-	add(order, ob.buyside)
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.updated = time.Now()
+	ob.add(order, ob.buyside)
 }
 
 func (ob *OrderBook) AddSell(order Order) {
-	add(order, ob.sellside)
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.updated = time.Now()
+	ob.add(order, ob.sellside)
+}
+
+// Apply atomically applies the levels of a depth event to both sides,
+// a zero Qty removing the level
+func (ob *OrderBook) Apply(bids, asks []Order) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.updated = time.Now()
+	for _, order := range bids {
+		ob.add(order, ob.buyside)
+	}
+	for _, order := range asks {
+		ob.add(order, ob.sellside)
+	}
+}
+
+// BBO returns the best bid and ask read at the same point in time,
+// ok is false when one of the sides is empty
+func (ob *OrderBook) BBO() (bid, ask Order, ok bool) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	if ob.buyside.Len() == 0 || ob.sellside.Len() == 0 {
+		return bid, ask, false
+	}
+	return topPrice(ob.buyside), topPrice(ob.sellside), true
+}
+
+// Depth returns a consistent copy of up to levels price levels
+// of each side, or of every level when levels is 0
+func (ob *OrderBook) Depth(levels int) Depth {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return Depth{
+		Bids: copyLevels(ob.buyside, levels),
+		Asks: copyLevels(ob.sellside, levels),
+	}
 }
 
-func add(order Order, book *skiplist.SkipList) {
-	priceKey := decimal.NewFromFloat(order.Price)
+// Levels returns the number of price levels of each side
+func (ob *OrderBook) Levels() (bids, asks int) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return ob.buyside.Len(), ob.sellside.Len()
+}
+
+// Updated returns the last time the book was written to,
+// zero when it never was
+func (ob *OrderBook) Updated() time.Time {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return ob.updated
+}
+
+func copyLevels(book *skiplist.SkipList, levels int) []Order {
+	n := book.Len()
+	if levels > 0 && levels < n {
+		n = levels
+	}
+	orders := make([]Order, 0, n)
+	for it := book.Iterator(); len(orders) < n && it.Next(); {
+		orders = append(orders, it.Value().(Order))
+	}
+	return orders
+}
+
+// Replace atomically replaces both sides of the book with the given
+// levels, dropping every level that is not part of the new set.
+// Used for exchanges that only publish full snapshots.
+func (ob *OrderBook) Replace(bids, asks []Order) {
+	buyside := ob.newSide(skiplist.NewDecimalMapReverse(), bids)
+	sellside := ob.newSide(skiplist.NewDecimalMap(), asks)
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.updated = time.Now()
+	ob.buyside, ob.sellside = buyside, sellside
+}
+
+// ReplaceBuySide atomically replaces the bids of the book
+func (ob *OrderBook) ReplaceBuySide(bids []Order) {
+	buyside := ob.newSide(skiplist.NewDecimalMapReverse(), bids)
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.updated = time.Now()
+	ob.buyside = buyside
+}
+
+// ReplaceSellSide atomically replaces the asks of the book
+func (ob *OrderBook) ReplaceSellSide(asks []Order) {
+	sellside := ob.newSide(skiplist.NewDecimalMap(), asks)
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.updated = time.Now()
+	ob.sellside = sellside
+}
+
+func (ob *OrderBook) newSide(book *skiplist.SkipList, orders []Order) *skiplist.SkipList {
+	for _, order := range orders {
+		ob.add(order, book)
+	}
+	return book
+}
+
+func (ob *OrderBook) add(order Order, book *skiplist.SkipList) {
+	if !ob.tick.IsZero() && !order.Price.Equal(RoundToTick(order.Price, ob.tick)) {
+		offTickLog.Warn("level priced off the tick", "price", order.Price, "tick", ob.tick)
+	}
+	priceKey := order.Price
 	if _, ok := book.Get(priceKey); ok { // Existing price level, append order
-		if order.Qty == 0 {
+		if order.Qty.IsZero() {
 			book.Delete(priceKey)
 			return
 		}
 		// ol := val.(Order)
 		// order.Qty = ol.Qty + order.Qty
 		book.Set(priceKey, order)
-	} else if order.Qty != 0 {
+	} else if !order.Qty.IsZero() {
 		book.Set(priceKey, order) // New price level
 	}
 }
 
 func (ob *OrderBook) IteratorBuySide() skiplist.Iterator {
-	return iterator(ob.buyside)
+	return iterator(ob.buys())
 }
 
 func (ob *OrderBook) IteratorSellSide() skiplist.Iterator {
-	return iterator(ob.sellside)
+	return iterator(ob.sells())
 }
 
 func iterator(book *skiplist.SkipList) skiplist.Iterator {
@@ -82,19 +225,21 @@ func iterator(book *skiplist.SkipList) skiplist.Iterator {
 }
 
 func (ob *OrderBook) TopPriceSellSide() Order {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
 	return lowPrice(ob.sellside)
 }
 
 func (ob *OrderBook) TopPriceBuySide() Order {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
 	return topPrice(ob.buyside)
 }
 
 func (ob *OrderBook) Empty() bool {
-	iterBuy := ob.buyside.Iterator()
-	okBuy := iterBuy.Next()
-	iterSell := ob.sellside.Iterator()
-	okSell := iterSell.Next()
-	return !okBuy || !okSell
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return ob.buyside.Len() == 0 || ob.sellside.Len() == 0
 }
 
 func topPrice(book *skiplist.SkipList) Order {
@@ -104,10 +249,14 @@ func topPrice(book *skiplist.SkipList) Order {
 }
 
 func (ob *OrderBook) LowPriceSellSide() Order {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
 	return topPrice(ob.sellside)
 }
 
 func (ob *OrderBook) LowPriceBuySide() Order {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
 	return lowPrice(ob.buyside)
 }
 
@@ -117,33 +266,13 @@ func lowPrice(book *skiplist.SkipList) Order {
 	return iter.Value().(Order)
 }
 
+// GetTopTenPrices returns up to the ten best levels of a side,
+// fewer when the book is not that deep
 func (ob *OrderBook) GetTopTenPrices(side string) []Order {
-	arr := make([]Order, 10)
-	var it skiplist.Iterator
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
 	if side == "buy" {
-		it = ob.IteratorBuySide()
-	} else { // sell
-		it = ob.IteratorSellSide()
-	}
-	it.Next()
-	for i := 0; i < 10; i++ {
-		arr[i] = it.Value().(Order)
-		it.Next()
+		return copyLevels(ob.buyside, 10)
 	}
-	// for testing purposes
-	// var str string
-	// if side == "buy" {
-	// 	str = "HIGHEST 10 BIDS: "
-	// } else {
-	// 	str = "HIGHEST 10 ASKS: "
-	// }
-	// for i := 0; i < len(arr); i++ {
-	// 	log.Info(str, "Index", i,
-	// 		"Price", arr[i].Price,
-	// 		"Quantity", arr[i].Qty,
-	// 		"FillCost", arr[i].FillCost,
-	// 		"ExchangeKey", arr[i].ExchangeKey,
-	// 	)
-	// }
-	return arr
+	return copyLevels(ob.sellside, 10) // sell
 }
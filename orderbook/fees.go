@@ -0,0 +1,130 @@
+package orderbook
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/shopspring/decimal"
+)
+
+// FeeSchedule holds the fees charged by an exchange
+type FeeSchedule struct {
+	Maker decimal.Decimal `json:"maker"` // Rate charged on the orders adding liquidity, e.g. 0.001
+	Taker decimal.Decimal `json:"taker"` // Rate charged on the orders taking liquidity
+
+	// Symbols overrides the maker and taker rates of some symbols
+	Symbols map[Symbol]SymbolFees `json:"symbols,omitempty"`
+	// Withdrawals is the flat fee of withdrawing an asset, in the
+	// asset, for every network it can be withdrawn on
+	Withdrawals map[string]map[string]decimal.Decimal `json:"withdrawals,omitempty"`
+	// Discount applies when the trading fees are paid in another asset
+	Discount *FeeDiscount `json:"discount,omitempty"`
+}
+
+// SymbolFees are the trading fees of a symbol
+type SymbolFees struct {
+	Maker decimal.Decimal `json:"maker"`
+	Taker decimal.Decimal `json:"taker"`
+}
+
+// FeeDiscount lowers the trading fees by Rate when they
+// are paid in Asset, e.g. 25% when paying in BNB on binance
+type FeeDiscount struct {
+	Asset   string          `json:"asset"`
+	Rate    decimal.Decimal `json:"rate"`
+	Enabled bool            `json:"enabled"`
+}
+
+// FeeMap is the fee schedule of each exchange
+type FeeMap map[ExchangeKey]FeeSchedule
+
+// Fees is read by every profitability calculation,
+// LoadFees overrides it from a file
+var Fees = FeeMap{
+	Binance: {
+		Maker: decimal.New(1, -3),
+		Taker: decimal.New(1, -3),
+		Withdrawals: map[string]map[string]decimal.Decimal{
+			"ETH":  {"ETH": decimal.New(5, -3)},
+			"USDT": {"ETH": decimal.New(10, 0), "TRX": decimal.New(1, 0)},
+			"USDC": {"ETH": decimal.New(10, 0)},
+		},
+		Discount: &FeeDiscount{Asset: "BNB", Rate: decimal.New(25, -2)},
+	},
+	Indodax: {
+		Maker: decimal.Zero,
+		Taker: decimal.New(3, -3),
+		Withdrawals: map[string]map[string]decimal.Decimal{
+			"ETH":  {"ETH": decimal.New(5, -3)},
+			"USDT": {"ETH": decimal.New(15, 0)},
+		},
+	},
+}
+
+// LoadFees reads the fee schedules of a JSON file keyed by exchange,
+// they replace the schedules of the exchanges listed in it
+func LoadFees(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var fees FeeMap
+	if err := json.NewDecoder(f).Decode(&fees); err != nil {
+		return err
+	}
+	for key, schedule := range fees {
+		Fees[key] = schedule
+	}
+	return nil
+}
+
+// Maker returns the rate of the orders adding liquidity to a symbol
+func (f FeeMap) Maker(key ExchangeKey, symbol Symbol) decimal.Decimal {
+	schedule := f[key]
+	rate := schedule.Maker
+	if fees, ok := schedule.Symbols[symbol]; ok {
+		rate = fees.Maker
+	}
+	return schedule.discount(rate)
+}
+
+// Taker returns the rate of the orders taking liquidity from a symbol
+func (f FeeMap) Taker(key ExchangeKey, symbol Symbol) decimal.Decimal {
+	schedule := f[key]
+	rate := schedule.Taker
+	if fees, ok := schedule.Symbols[symbol]; ok {
+		rate = fees.Taker
+	}
+	return schedule.discount(rate)
+}
+
+// FillCost returns the taker rate of a symbol as the price
+// multiplier stored in Order.FillCost, e.g. 0.001 -> 1.001
+func (f FeeMap) FillCost(key ExchangeKey, symbol Symbol) float64 {
+	m, _ := decimal.New(1, 0).Add(f.Taker(key, symbol)).Float64()
+	return m
+}
+
+// Withdrawal returns the fee of withdrawing an asset over a network,
+// or over its cheapest network when network is empty
+func (f FeeMap) Withdrawal(key ExchangeKey, asset, network string) (fee decimal.Decimal, ok bool) {
+	networks := f[key].Withdrawals[asset]
+	if network != "" {
+		fee, ok = networks[network]
+		return fee, ok
+	}
+	for _, n := range networks {
+		if !ok || n.LessThan(fee) {
+			fee, ok = n, true
+		}
+	}
+	return fee, ok
+}
+
+func (s FeeSchedule) discount(rate decimal.Decimal) decimal.Decimal {
+	if s.Discount == nil || !s.Discount.Enabled {
+		return rate
+	}
+	return rate.Mul(decimal.New(1, 0).Sub(s.Discount.Rate))
+}
@@ -0,0 +1,47 @@
+package orderbook
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFees(t *testing.T) {
+	saved := Fees
+	defer func() { Fees = saved }()
+	Fees = FeeMap{Binance: saved[Binance]}
+
+	f, err := ioutil.TempFile("", "fees")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString(`{
+		"Indodax": {
+			"maker": "0",
+			"taker": "0.003",
+			"symbols": {"USDT/IDR": {"maker": "0", "taker": "0.002"}},
+			"withdrawals": {"USDT": {"ETH": "15", "TRX": "2"}}
+		}
+	}`)
+	f.Close()
+	assert.Nil(t, LoadFees(f.Name()))
+
+	assert.Equal(t, "0.003", Fees.Taker(Indodax, ETH_IDR).String())
+	assert.Equal(t, "0.002", Fees.Taker(Indodax, USDT_IDR).String())
+	assert.Equal(t, 1.003, Fees.FillCost(Indodax, ETH_IDR))
+
+	fee, ok := Fees.Withdrawal(Indodax, "USDT", "")
+	assert.True(t, ok)
+	assert.Equal(t, "2", fee.String())
+	fee, _ = Fees.Withdrawal(Indodax, "USDT", "ETH")
+	assert.Equal(t, "15", fee.String())
+	_, ok = Fees.Withdrawal(Indodax, "BTC", "")
+	assert.False(t, ok)
+
+	// paying the fees in BNB
+	binance := Fees[Binance]
+	binance.Discount = &FeeDiscount{Asset: "BNB", Rate: d("0.25"), Enabled: true}
+	Fees[Binance] = binance
+	assert.Equal(t, "0.00075", Fees.Taker(Binance, ETH_USDT).String())
+}
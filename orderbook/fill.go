@@ -0,0 +1,211 @@
+package orderbook
+
+import (
+	"github.com/anthonychristian/crypto-arbitrage/skiplist"
+	"github.com/shopspring/decimal"
+)
+
+// Fill is the simulated execution of a market order against one side
+// of a book, walking the levels from the best price
+type Fill struct {
+	Qty        decimal.Decimal // Base quantity filled
+	Notional   decimal.Decimal // Quote paid or received, before fees
+	Fees       decimal.Decimal // Quote paid in fees, from Order.FillCost
+	AvgPrice   decimal.Decimal // Volume weighted price, before fees
+	NetPrice   decimal.Decimal // Volume weighted price, fees included
+	WorstPrice decimal.Decimal // Price of the last level consumed
+	Levels     int             // Number of levels consumed, even partially
+	Remaining  decimal.Decimal // Unfilled amount, in the unit it was asked in
+	Takes      []Take          // What was taken from each level, only set by the From methods
+}
+
+// Take is the quantity a fill took from a level
+type Take struct {
+	Level Order           // The level as it is in the book
+	Qty   decimal.Decimal // Quantity taken from it
+}
+
+// Available returns the quantity of a level left to a simulated order,
+// when earlier simulated orders took some of it, see package paper
+type Available func(level Order) decimal.Decimal
+
+// Filled tells whether the book was deep enough for the whole amount
+func (f Fill) Filled() bool {
+	return !f.Remaining.IsPositive()
+}
+
+// FillBuy simulates buying qty of the base currency on the asks
+func (ob *OrderBook) FillBuy(qty decimal.Decimal) Fill {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return walk(iterate(ob.sellside.Iterator()), qty, decimal.Zero, false, true, nil)
+}
+
+// FillBuyQuote simulates spending quote on the asks, fees included
+func (ob *OrderBook) FillBuyQuote(quote decimal.Decimal) Fill {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return walk(iterate(ob.sellside.Iterator()), quote, decimal.Zero, true, true, nil)
+}
+
+// FillSell simulates selling qty of the base currency on the bids
+func (ob *OrderBook) FillSell(qty decimal.Decimal) Fill {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return walk(iterate(ob.buyside.Iterator()), qty, decimal.Zero, false, false, nil)
+}
+
+// FillSellQuote simulates selling on the bids until quote is received,
+// net of fees
+func (ob *OrderBook) FillSellQuote(quote decimal.Decimal) Fill {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return walk(iterate(ob.buyside.Iterator()), quote, decimal.Zero, true, false, nil)
+}
+
+// FillBuyLimit simulates buying qty on the asks priced up to limit
+func (ob *OrderBook) FillBuyLimit(qty, limit decimal.Decimal) Fill {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return walk(iterate(ob.sellside.Iterator()), qty, limit, false, true, nil)
+}
+
+// FillBuyLimitFrom simulates buying qty on the asks priced up to limit,
+// taking only the quantity of each level available returns
+func (ob *OrderBook) FillBuyLimitFrom(available Available, qty, limit decimal.Decimal) Fill {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return walk(iterate(ob.sellside.Iterator()), qty, limit, false, true, available)
+}
+
+// FillSellLimit simulates selling qty on the bids priced down to limit
+func (ob *OrderBook) FillSellLimit(qty, limit decimal.Decimal) Fill {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return walk(iterate(ob.buyside.Iterator()), qty, limit, false, false, nil)
+}
+
+// FillSellLimitFrom simulates selling qty on the bids priced down to limit,
+// taking only the quantity of each level available returns
+func (ob *OrderBook) FillSellLimitFrom(available Available, qty, limit decimal.Decimal) Fill {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return walk(iterate(ob.buyside.Iterator()), qty, limit, false, false, available)
+}
+
+// FillBuy simulates buying qty of the base currency on the asks of the depth
+func (d Depth) FillBuy(qty decimal.Decimal) Fill {
+	return walk(ofSlice(d.Asks), qty, decimal.Zero, false, true, nil)
+}
+
+// FillBuyQuote simulates spending quote on the asks of the depth, fees included
+func (d Depth) FillBuyQuote(quote decimal.Decimal) Fill {
+	return walk(ofSlice(d.Asks), quote, decimal.Zero, true, true, nil)
+}
+
+// FillSell simulates selling qty of the base currency on the bids of the depth
+func (d Depth) FillSell(qty decimal.Decimal) Fill {
+	return walk(ofSlice(d.Bids), qty, decimal.Zero, false, false, nil)
+}
+
+// FillSellQuote simulates selling on the bids of the depth until quote
+// is received, net of fees
+func (d Depth) FillSellQuote(quote decimal.Decimal) Fill {
+	return walk(ofSlice(d.Bids), quote, decimal.Zero, true, false, nil)
+}
+
+// levels returns the levels of one side from the best price,
+// false once there are none left
+type levels func() (Order, bool)
+
+// iterate returns the levels of a side of a book
+func iterate(it skiplist.Iterator) levels {
+	return func() (Order, bool) {
+		if !it.Next() {
+			return Order{}, false
+		}
+		return it.Value().(Order), true
+	}
+}
+
+// ofSlice returns the levels of a side of a depth
+func ofSlice(orders []Order) levels {
+	i := 0
+	return func() (Order, bool) {
+		if i == len(orders) {
+			return Order{}, false
+		}
+		i++
+		return orders[i-1], true
+	}
+}
+
+// walk consumes the levels until amount is filled. The amount is
+// in the base currency, or in the quote currency when byQuote is set.
+// Levels priced beyond a positive limit are not consumed. When available
+// is set only the quantity it returns is consumed from each level, and
+// the takes are listed in the fill.
+func walk(next levels, amount, limit decimal.Decimal, byQuote, buy bool, available Available) (f Fill) {
+	f.Remaining = amount
+	for f.Remaining.IsPositive() {
+		o, ok := next()
+		if !ok {
+			break
+		}
+		if limit.IsPositive() && ((buy && o.Price.GreaterThan(limit)) || (!buy && o.Price.LessThan(limit))) {
+			break
+		}
+		fee := fillCostRate(o.FillCost)
+		// quote paid or received per unit of base, fees included
+		net := o.Price.Mul(decimal.New(1, 0).Sub(fee))
+		if buy {
+			net = o.Price.Mul(decimal.New(1, 0).Add(fee))
+		}
+
+		qty := o.Qty
+		if available != nil {
+			if qty = available(o); !qty.IsPositive() {
+				continue
+			}
+		}
+		if byQuote {
+			if cost := qty.Mul(net); cost.LessThan(f.Remaining) {
+				f.Remaining = f.Remaining.Sub(cost)
+			} else {
+				qty = f.Remaining.Div(net)
+				f.Remaining = decimal.Zero
+			}
+		} else {
+			qty = decimal.Min(qty, f.Remaining)
+			f.Remaining = f.Remaining.Sub(qty)
+		}
+
+		notional := qty.Mul(o.Price)
+		f.Qty = f.Qty.Add(qty)
+		f.Notional = f.Notional.Add(notional)
+		f.Fees = f.Fees.Add(notional.Mul(fee))
+		f.WorstPrice = o.Price
+		f.Levels++
+		if available != nil {
+			f.Takes = append(f.Takes, Take{Level: o, Qty: qty})
+		}
+	}
+	if f.Qty.IsPositive() {
+		f.AvgPrice = f.Notional.Div(f.Qty)
+		if buy {
+			f.NetPrice = f.Notional.Add(f.Fees).Div(f.Qty)
+		} else {
+			f.NetPrice = f.Notional.Sub(f.Fees).Div(f.Qty)
+		}
+	}
+	return f
+}
+
+// fillCostRate converts a fill cost multiplier to a rate,
+// e.g. 1.001 -> 0.001
+func fillCostRate(m float64) decimal.Decimal {
+	if m == 0 {
+		return decimal.Zero
+	}
+	return decimal.NewFromFloat(m).Sub(decimal.New(1, 0))
+}
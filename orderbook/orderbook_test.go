@@ -2,9 +2,11 @@ package orderbook
 
 import (
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/stretchr/testify/suite"
@@ -12,6 +14,10 @@ import (
 
 var (
 	NY, _ = time.LoadLocation("America/New_York")
+
+	d   = decimal.RequireFromString
+	one = decimal.New(1, 0)
+	two = decimal.New(2, 0)
 )
 
 type OrderBookSuite struct{ suite.Suite }
@@ -40,39 +46,258 @@ func (s *OrderBookSuite) TestOrderBook() {
 	lpSell := ob.LowPriceSellSide()
 	fmt.Printf("Top price buy/sell: %v/%v\n", tpBuy, tpSell)
 	fmt.Printf("Low price buy/sell: %v/%v\n", lpBuy, lpSell)
-	assert.Equal(s.T(), 108.0, tpBuy.Price)
-	assert.Equal(s.T(), 110.0, tpSell.Price)
+	assert.Equal(s.T(), "108", tpBuy.Price.String())
+	assert.Equal(s.T(), "110", tpSell.Price.String())
 
 	// Test price level removal
 	ob.AddBuy(Order{
-		Price: 108,
-		Qty:   0,
+		Price: d("108"),
+		Qty:   d("0"),
 	})
 	tpBuy = ob.TopPriceBuySide()
-	assert.Equal(s.T(), 107.0, tpBuy.Price)
+	assert.Equal(s.T(), "107", tpBuy.Price.String())
+}
+
+func (s *OrderBookSuite) TestReplace() {
+	ob := setupInitialBook()
+
+	ob.Replace([]Order{
+		{Price: d("107"), Qty: d("80")},
+		{Price: d("105"), Qty: d("10")},
+	}, []Order{
+		{Price: d("111"), Qty: d("5")},
+	})
+	assert.Equal(s.T(), "107", ob.TopPriceBuySide().Price.String())
+	assert.Equal(s.T(), "80", ob.TopPriceBuySide().Qty.String())
+	assert.Equal(s.T(), "105", ob.LowPriceBuySide().Price.String())
+	assert.Equal(s.T(), "111", ob.LowPriceSellSide().Price.String())
+
+	bids, asks := ob.Levels()
+	assert.Equal(s.T(), 2, bids)
+	assert.Equal(s.T(), 1, asks)
+
+	updated := ob.Updated()
+	ob.ReplaceSellSide([]Order{{Price: d("112"), Qty: d("1")}})
+	assert.Equal(s.T(), "112", ob.LowPriceSellSide().Price.String())
+	assert.Equal(s.T(), "107", ob.TopPriceBuySide().Price.String())
+	assert.False(s.T(), ob.Updated().Before(updated))
+	assert.True(s.T(), NewOrderBook().Updated().IsZero())
+}
+
+// Every batch moves the book up by one tick keeping a spread of exactly 1,
+// readers must never see the bid of one batch with the ask of another.
+// Run with -race to also check the book for data races.
+func (s *OrderBookSuite) TestConcurrentBatchesAndReads() {
+	ob := NewOrderBook()
+	ob.Apply([]Order{{Price: d("100"), Qty: d("1")}}, []Order{{Price: d("101"), Qty: d("1")}})
+
+	const batches = 500
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for w := 0; w < 2; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < batches; i++ {
+				if w == 0 {
+					// the ask at p+1 becomes the bid, a new ask is added at p+2
+					p := decimal.New(int64(100+i), 0)
+					ob.Apply(
+						[]Order{{Price: p, Qty: d("0")}, {Price: p.Add(one), Qty: d("1")}},
+						[]Order{{Price: p.Add(one), Qty: d("0")}, {Price: p.Add(two), Qty: d("1")}},
+					)
+				} else {
+					ob.AddSell(Order{Price: d("1000000"), Qty: decimal.New(int64(i+1), 0)})
+				}
+			}
+		}(w)
+	}
+
+	errs := make(chan string, 4)
+	for r := 0; r < 4; r++ {
+		go func() {
+			for {
+				select {
+				case <-done:
+					errs <- ""
+					return
+				default:
+				}
+				bid, ask, ok := ob.BBO()
+				if !ok || !ask.Price.Sub(bid.Price).Equal(one) {
+					errs <- fmt.Sprintf("inconsistent BBO %v/%v", bid.Price, ask.Price)
+					return
+				}
+				depth := ob.Depth(1)
+				if len(depth.Bids) != 1 || len(depth.Asks) != 1 || !depth.Asks[0].Price.Sub(depth.Bids[0].Price).Equal(one) {
+					errs <- fmt.Sprintf("inconsistent depth %v", depth)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(done)
+	for r := 0; r < 4; r++ {
+		assert.Empty(s.T(), <-errs)
+	}
+	bid, ask, ok := ob.BBO()
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), fmt.Sprint(100+batches), bid.Price.String())
+	assert.Equal(s.T(), fmt.Sprint(101+batches), ask.Price.String())
+	assert.Len(s.T(), ob.Depth(0).Asks, 2)
+}
+
+func (s *OrderBookSuite) TestBBOEmpty() {
+	ob := NewOrderBook()
+	ob.AddBuy(Order{Price: d("100"), Qty: d("1")})
+	_, _, ok := ob.BBO()
+	assert.False(s.T(), ok)
+	assert.True(s.T(), ob.Empty())
+	assert.Len(s.T(), ob.Depth(0).Bids, 1)
+}
+
+func (s *OrderBookSuite) TestOffTickPrices() {
+	ob := NewOrderBookWithTick(d("0.01"))
+	// both round to 6543.21, they are kept as two levels
+	ob.AddBuy(Order{Price: d("6543.214"), Qty: d("1")})
+	ob.AddBuy(Order{Price: d("6543.2149"), Qty: d("2")})
+	ob.AddSell(Order{Price: d("6543.216"), Qty: d("0.00000001")})
+
+	depth := ob.Depth(0)
+	assert.Len(s.T(), depth.Bids, 2)
+	assert.Equal(s.T(), "6543.2149", depth.Bids[0].Price.String())
+	assert.Equal(s.T(), "6543.214", depth.Bids[1].Price.String())
+	assert.Equal(s.T(), "6543.216", depth.Asks[0].Price.String())
+	assert.Equal(s.T(), "0.00000001", depth.Asks[0].Qty.String())
+
+	// deleting one leaves the other
+	ob.AddBuy(Order{Price: d("6543.214"), Qty: d("0")})
+	depth = ob.Depth(0)
+	assert.Len(s.T(), depth.Bids, 1)
+	assert.Equal(s.T(), "6543.2149", depth.Bids[0].Price.String())
+	assert.Equal(s.T(), "2", depth.Bids[0].Qty.String())
+
+	assert.Equal(s.T(), "1.234", FloorToStep(d("1.23456"), d("0.001")).String())
+	assert.Equal(s.T(), "3500000", RoundToTick(d("3499999.5"), d("1")).String())
+}
+
+func (s *OrderBookSuite) TestGetTopTenPricesShallow() {
+	ob := setupInitialBook()
+	bids := ob.GetTopTenPrices("buy")
+	assert.Len(s.T(), bids, 3)
+	assert.Equal(s.T(), "108", bids[0].Price.String())
+	assert.Len(s.T(), ob.GetTopTenPrices("sell"), 2)
+	assert.Empty(s.T(), NewOrderBook().GetTopTenPrices("buy"))
+}
+
+func (s *OrderBookSuite) TestFill() {
+	ob := setupInitialBook()
+
+	// 20 @ 109 and 5 @ 110
+	buy := ob.FillBuy(d("25"))
+	assert.True(s.T(), buy.Filled())
+	assert.Equal(s.T(), "25", buy.Qty.String())
+	assert.Equal(s.T(), "2730", buy.Notional.String())
+	assert.Equal(s.T(), "109.2", buy.AvgPrice.String())
+	assert.Equal(s.T(), "110", buy.WorstPrice.String())
+	assert.Equal(s.T(), 2, buy.Levels)
+
+	// the asks only hold 30
+	buy = ob.FillBuy(d("40"))
+	assert.False(s.T(), buy.Filled())
+	assert.Equal(s.T(), "30", buy.Qty.String())
+	assert.Equal(s.T(), "10", buy.Remaining.String())
+
+	// 3454 sells 30 @ 108 and 2 @ 107
+	sell := ob.FillSellQuote(d("3454"))
+	assert.True(s.T(), sell.Filled())
+	assert.Equal(s.T(), "32", sell.Qty.String())
+	assert.Equal(s.T(), "107", sell.WorstPrice.String())
+
+	// 2400 takes 20 @ 109 for 2180, and 2 @ 110 with the rest
+	buy = ob.FillBuyQuote(d("2400"))
+	assert.Equal(s.T(), "22", buy.Qty.String())
+	assert.Equal(s.T(), 2, buy.Levels)
+
+	// the limit stops the walk before 110
+	buy = ob.FillBuyLimit(d("25"), d("109"))
+	assert.Equal(s.T(), "20", buy.Qty.String())
+	assert.Equal(s.T(), "5", buy.Remaining.String())
+
+	sell = ob.FillSellLimit(d("200"), d("107"))
+	assert.Equal(s.T(), "130", sell.Qty.String())
+	assert.Equal(s.T(), "70", sell.Remaining.String())
+
+	// 15 of the 20 @ 109 were taken already
+	available := func(level Order) decimal.Decimal {
+		if level.Price.Equal(d("109")) {
+			return level.Qty.Sub(d("15"))
+		}
+		return level.Qty
+	}
+	buy = ob.FillBuyLimitFrom(available, d("8"), d("110"))
+	assert.Equal(s.T(), "8", buy.Qty.String())
+	assert.Equal(s.T(), []Take{
+		{Level: Order{Price: d("109"), Qty: d("20")}, Qty: d("5")},
+		{Level: Order{Price: d("110"), Qty: d("10")}, Qty: d("3")},
+	}, buy.Takes)
+}
+
+func (s *OrderBookSuite) TestFillFees() {
+	ob := NewOrderBook()
+	ob.AddBuy(Order{Price: d("100"), Qty: d("10"), FillCost: 1.001})
+	ob.AddSell(Order{Price: d("200"), Qty: d("10"), FillCost: 1.001})
+
+	buy := ob.FillBuy(d("2"))
+	assert.Equal(s.T(), "0.4", buy.Fees.String())
+	assert.Equal(s.T(), "200", buy.AvgPrice.String())
+	assert.Equal(s.T(), "200.2", buy.NetPrice.String())
+
+	sell := ob.FillSell(d("2"))
+	assert.Equal(s.T(), "99.9", sell.NetPrice.String())
+
+	// 200.2 per unit fees included
+	buy = ob.FillBuyQuote(d("1001"))
+	assert.Equal(s.T(), "5", buy.Qty.String())
+	assert.Equal(s.T(), "1", buy.Fees.String())
+}
+
+func (s *OrderBookSuite) TestFillDepth() {
+	ob := setupInitialBook()
+	depth := ob.Depth(0)
+
+	// a depth fills like the book it was copied from
+	assert.Equal(s.T(), ob.FillBuy(d("25")), depth.FillBuy(d("25")))
+	assert.Equal(s.T(), ob.FillBuy(d("40")), depth.FillBuy(d("40")))
+	assert.Equal(s.T(), ob.FillBuyQuote(d("2400")), depth.FillBuyQuote(d("2400")))
+	assert.Equal(s.T(), ob.FillSell(d("200")), depth.FillSell(d("200")))
+	assert.Equal(s.T(), ob.FillSellQuote(d("3454")), depth.FillSellQuote(d("3454")))
+	assert.False(s.T(), Depth{}.FillBuy(d("1")).Filled())
 }
 
 func setupInitialBook() *OrderBook {
 	ob := NewOrderBook()
 	ob.AddBuy(Order{
-		Price: 108,
-		Qty:   30,
+		Price: d("108"),
+		Qty:   d("30"),
 	})
 	ob.AddBuy(Order{
-		Price: 107,
-		Qty:   100,
+		Price: d("107"),
+		Qty:   d("100"),
 	})
 	ob.AddBuy(Order{
-		Price: 106,
-		Qty:   50,
+		Price: d("106"),
+		Qty:   d("50"),
 	})
 	ob.AddSell(Order{
-		Price: 110,
-		Qty:   10,
+		Price: d("110"),
+		Qty:   d("10"),
 	})
 	ob.AddSell(Order{
-		Price: 109,
-		Qty:   20,
+		Price: d("109"),
+		Qty:   d("20"),
 	})
 	return ob
 }
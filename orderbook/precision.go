@@ -0,0 +1,39 @@
+package orderbook
+
+import "github.com/shopspring/decimal"
+
+// TickSizes is the price increment of each symbol on each exchange,
+// the levels of a book priced off it are logged
+var TickSizes = map[ExchangeKey]map[Symbol]decimal.Decimal{
+	Binance: {
+		BTC_USDC: decimal.New(1, -2),
+		ETH_USDT: decimal.New(1, -2),
+	},
+	Indodax: {
+		ETH_IDR:  decimal.New(1, 0),
+		USDT_IDR: decimal.New(1, 0),
+	},
+}
+
+// TickSize returns the price increment of a symbol on an exchange,
+// zero when it is unknown
+func TickSize(key ExchangeKey, symbol Symbol) decimal.Decimal {
+	return TickSizes[key][symbol]
+}
+
+// RoundToTick rounds a price to the nearest multiple of tick
+func RoundToTick(price, tick decimal.Decimal) decimal.Decimal {
+	if tick.Sign() <= 0 {
+		return price
+	}
+	return price.Div(tick).Round(0).Mul(tick)
+}
+
+// FloorToStep rounds a quantity down to a multiple of step,
+// so that it never exceeds what is available
+func FloorToStep(qty, step decimal.Decimal) decimal.Decimal {
+	if step.Sign() <= 0 {
+		return qty
+	}
+	return qty.Div(step).Floor().Mul(step)
+}
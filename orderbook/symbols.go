@@ -10,6 +10,9 @@ type Symbol string
 const (
 	BTC_USDC Symbol = "BTC/USDC"
 	BTC_ETH  Symbol = "BTC/ETH"
+	ETH_IDR  Symbol = "ETH/IDR"
+	ETH_USDT Symbol = "ETH/USDT"
+	USDT_IDR Symbol = "USDT/IDR"
 )
 
 type Symbols map[Symbol][]ExchangeKey // The key is the symbol pair, the []string is a list of exchanges for the pair
@@ -17,6 +20,9 @@ type Symbols map[Symbol][]ExchangeKey // The key is the symbol pair, the []strin
 var (
 	SymbolMap = Symbols{
 		BTC_USDC: []ExchangeKey{Binance},
+		ETH_IDR:  []ExchangeKey{Indodax},
+		ETH_USDT: []ExchangeKey{Binance},
+		USDT_IDR: []ExchangeKey{Indodax},
 	}
 )
 
@@ -45,6 +51,19 @@ func (s Symbols) GetExchangesForSymbol(symbol string) (exchanges []Exchange, err
 	return exchanges, nil
 }
 
+// SymbolsForExchange lists the symbols traded on the given exchange
+func (s Symbols) SymbolsForExchange(key ExchangeKey) (list []Symbol) {
+	for symbol, exchanges := range s {
+		for _, ex := range exchanges {
+			if ex == key {
+				list = append(list, symbol)
+				break
+			}
+		}
+	}
+	return list
+}
+
 // GetLeftCurrency returns the left currency in the symbol
 func GetLeftCurrency(symbol string) string {
 	currencies := strings.Split(symbol, "/")
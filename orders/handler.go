@@ -0,0 +1,89 @@
+package orders
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Prefix is the path the REST API is served under
+const Prefix = "/api/v0/orders"
+
+// Handler serves the orders of m:
+//
+//	POST   /api/v0/orders                    submits a Request
+//	GET    /api/v0/orders                    lists the orders
+//	GET    /api/v0/orders/{client_order_id}  returns an order
+//	DELETE /api/v0/orders/{client_order_id}  cancels an order
+func Handler(m *Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, Prefix), "/")
+		switch {
+		case id == "" && r.Method == http.MethodPost:
+			submit(m, w, r)
+		case id == "" && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, m.List())
+		case id != "" && r.Method == http.MethodGet:
+			order, err := m.Get(id)
+			if err != nil {
+				writeError(w, http.StatusNotFound, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, order)
+		case id != "" && r.Method == http.MethodDelete:
+			order, err := m.Cancel(id)
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, err)
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, order)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, nil)
+		}
+	})
+}
+
+func submit(m *Manager, w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	order, created, err := m.Submit(req)
+	switch err.(type) {
+	case nil:
+	case ValidationError:
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	default:
+		if err == ErrDuplicate {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if created {
+		writeJSON(w, http.StatusCreated, order)
+	} else {
+		writeJSON(w, http.StatusOK, order)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	message := http.StatusText(status)
+	if err != nil {
+		message = err.Error()
+	}
+	writeJSON(w, status, map[string]string{"message": message})
+}
@@ -0,0 +1,56 @@
+package orders
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	m := NewManager()
+	m.Register(&fakeExecutor{key: orderbook.Binance})
+	server := httptest.NewServer(Handler(m))
+	defer server.Close()
+
+	// as posted by the dashboard form
+	body := `{"client_order_id": "hello26","symbol": "BTC/USDC","qty": "2","side": "buy","order_type": "limit","limit_price": "4000", "time_in_force": "gtc"}`
+	post := func() *http.Response {
+		res, err := http.Post(server.URL+Prefix, "application/json", strings.NewReader(body))
+		assert.Nil(t, err)
+		return res
+	}
+
+	res := post()
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	var o Order
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&o))
+	assert.Equal(t, "hello26", o.ClientOrderID)
+	assert.Equal(t, Acknowledged, o.Status)
+	assert.Equal(t, "4000", o.LimitPrice.String())
+
+	assert.Equal(t, http.StatusOK, post().StatusCode)
+
+	res, err := http.Get(server.URL + Prefix + "/hello26")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, err = http.Get(server.URL + Prefix + "/unknown")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	body = `{"symbol": "BTC/USDC","qty": "2","side": "","order_type": "limit","limit_price": "4000"}`
+	assert.Equal(t, http.StatusUnprocessableEntity, post().StatusCode)
+
+	body = `{"client_order_id": "hello26","symbol": "BTC/USDC","qty": "1","side": "buy","order_type": "market"}`
+	assert.Equal(t, http.StatusConflict, post().StatusCode)
+
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+Prefix+"/hello26", nil)
+	res, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+}
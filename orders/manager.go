@@ -0,0 +1,226 @@
+package orders
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/logging"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/shopspring/decimal"
+)
+
+var log = logging.New("orders")
+
+var (
+	// ErrNotFound is returned for an unknown client_order_id
+	ErrNotFound = errors.New("order not found")
+	// ErrDuplicate is returned when a client_order_id is reused
+	// for an order different from the one it was first used for
+	ErrDuplicate = errors.New("client_order_id already used for another order")
+)
+
+// Executor sends orders to an exchange, it is implemented
+// by the exchange adapters able to trade
+type Executor interface {
+	Key() orderbook.ExchangeKey
+	// Submit sends a new order, returning the id the exchange gave it
+	Submit(o Order) (exchangeOrderID string, err error)
+	// Cancel cancels a live order
+	Cancel(o Order) error
+	// Executions reports the fills and the status changes of the orders,
+	// it is closed once the executor is stopped
+	Executions() <-chan Execution
+}
+
+// Execution is a change of an order on its exchange
+type Execution struct {
+	ClientOrderID string
+	Status        Status          // Cancelled or Rejected, unused for fills
+	Qty           decimal.Decimal // Quantity filled by this execution
+	Price         decimal.Decimal // Price of the fill
+	Reason        string
+	Time          time.Time
+}
+
+// Manager records the orders by client_order_id
+// and routes them to the executor of their exchange
+type Manager struct {
+	orders    map[string]*Order
+	executors map[orderbook.ExchangeKey]Executor
+	now       func() time.Time
+	mu        sync.RWMutex
+}
+
+// NewManager creates a manager without any executor
+func NewManager() *Manager {
+	return &Manager{
+		orders:    make(map[string]*Order),
+		executors: make(map[orderbook.ExchangeKey]Executor),
+		now:       time.Now,
+	}
+}
+
+// DefaultManager routes the orders of the REST API
+var DefaultManager = NewManager()
+
+// RegisterExecutor makes an executor available to DefaultManager.
+// It is meant to be called when the adapter of the exchange starts.
+func RegisterExecutor(e Executor) {
+	DefaultManager.Register(e)
+}
+
+// Register routes the orders of an exchange to e, and applies
+// the executions it reports until e closes its Executions
+func (m *Manager) Register(e Executor) {
+	m.mu.Lock()
+	m.executors[e.Key()] = e
+	m.mu.Unlock()
+	if executions := e.Executions(); executions != nil {
+		go func() {
+			for execution := range executions {
+				if err := m.Apply(execution); err != nil {
+					log.Error("error applying execution", "exchange", e.Key(), "err", err)
+				}
+			}
+		}()
+	}
+}
+
+// Submit records the order of a request and sends it to its exchange.
+// Submitting the same client_order_id twice returns the recorded order
+// without sending it again, created is false in that case.
+func (m *Manager) Submit(r Request) (o Order, created bool, err error) {
+	if err := r.Validate(); err != nil {
+		return o, false, err
+	}
+
+	m.mu.Lock()
+	if r.ClientOrderID == "" {
+		r.ClientOrderID = newID()
+	}
+	if existing, ok := m.orders[r.ClientOrderID]; ok {
+		defer m.mu.Unlock()
+		if !existing.Request.same(r) {
+			return o, false, ErrDuplicate
+		}
+		return *existing, false, nil
+	}
+	now := m.now()
+	order := &Order{
+		Request:   r,
+		ID:        newID(),
+		Status:    New,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	m.orders[r.ClientOrderID] = order
+	executor, ok := m.executors[r.Exchange]
+	m.mu.Unlock()
+
+	if !ok {
+		return m.reject(r.ClientOrderID, fmt.Sprintf("%v does not support trading", r.Exchange)), true, nil
+	}
+	exchangeOrderID, err := executor.Submit(*order)
+	if err != nil {
+		return m.reject(r.ClientOrderID, err.Error()), true, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	order.ExchangeOrderID = exchangeOrderID
+	// executions may have been applied already
+	if order.Status == New {
+		order.moveTo(Acknowledged, m.now())
+	}
+	return *order, true, nil
+}
+
+func (m *Manager) reject(clientOrderID, reason string) Order {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	order := m.orders[clientOrderID]
+	if err := order.moveTo(Rejected, m.now()); err == nil {
+		order.Reason = reason
+	}
+	return *order
+}
+
+// Cancel asks the exchange to cancel a live order, the order
+// is cancelled once the exchange reports it
+func (m *Manager) Cancel(clientOrderID string) (Order, error) {
+	m.mu.RLock()
+	order, ok := m.orders[clientOrderID]
+	if !ok {
+		m.mu.RUnlock()
+		return Order{}, ErrNotFound
+	}
+	o := *order
+	executor := m.executors[o.Exchange]
+	m.mu.RUnlock()
+
+	if !o.Status.CanMoveTo(Cancelled) {
+		return o, fmt.Errorf("order %v is %v", clientOrderID, o.Status)
+	}
+	if executor == nil {
+		return o, fmt.Errorf("%v does not support trading", o.Exchange)
+	}
+	return o, executor.Cancel(o)
+}
+
+// Apply updates an order with an execution
+func (m *Manager) Apply(e Execution) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	order, ok := m.orders[e.ClientOrderID]
+	if !ok {
+		return ErrNotFound
+	}
+	now := e.Time
+	if now.IsZero() {
+		now = m.now()
+	}
+	if e.Qty.IsPositive() {
+		return order.fill(e.Qty, e.Price, now)
+	}
+	if err := order.moveTo(e.Status, now); err != nil {
+		return err
+	}
+	order.Reason = e.Reason
+	return nil
+}
+
+// Get returns the order of a client_order_id
+func (m *Manager) Get(clientOrderID string) (Order, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	order, ok := m.orders[clientOrderID]
+	if !ok {
+		return Order{}, ErrNotFound
+	}
+	return *order, nil
+}
+
+// List returns every order, oldest first
+func (m *Manager) List() []Order {
+	m.mu.RLock()
+	list := make([]Order, 0, len(m.orders))
+	for _, order := range m.orders {
+		list = append(list, *order)
+	}
+	m.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,114 @@
+package orders
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+var d = decimal.RequireFromString
+
+type fakeExecutor struct {
+	key       orderbook.ExchangeKey
+	submitted []Order
+	cancelled []Order
+	err       error
+}
+
+func (f *fakeExecutor) Key() orderbook.ExchangeKey   { return f.key }
+func (f *fakeExecutor) Executions() <-chan Execution { return nil }
+func (f *fakeExecutor) Cancel(o Order) error         { f.cancelled = append(f.cancelled, o); return nil }
+func (f *fakeExecutor) Submit(o Order) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	f.submitted = append(f.submitted, o)
+	return "x-" + o.ClientOrderID, nil
+}
+
+func limitBuy(clientOrderID string) Request {
+	price := d("3000000")
+	return Request{
+		ClientOrderID: clientOrderID,
+		Symbol:        orderbook.ETH_IDR,
+		Qty:           d("2"),
+		Side:          Buy,
+		Type:          Limit,
+		LimitPrice:    &price,
+	}
+}
+
+func TestSubmitIsIdempotent(t *testing.T) {
+	m := NewManager()
+	executor := &fakeExecutor{key: orderbook.Indodax}
+	m.Register(executor)
+
+	o, created, err := m.Submit(limitBuy("a"))
+	assert.Nil(t, err)
+	assert.True(t, created)
+	assert.Equal(t, Acknowledged, o.Status)
+	assert.Equal(t, orderbook.Indodax, o.Exchange)
+	assert.Equal(t, GTC, o.TimeInForce)
+	assert.Equal(t, "x-a", o.ExchangeOrderID)
+
+	again, created, err := m.Submit(limitBuy("a"))
+	assert.Nil(t, err)
+	assert.False(t, created)
+	assert.Equal(t, o.ID, again.ID)
+	assert.Len(t, executor.submitted, 1)
+
+	other := limitBuy("a")
+	other.Qty = d("3")
+	_, _, err = m.Submit(other)
+	assert.Equal(t, ErrDuplicate, err)
+}
+
+func TestSubmitRejected(t *testing.T) {
+	m := NewManager()
+	o, created, err := m.Submit(limitBuy("a"))
+	assert.Nil(t, err)
+	assert.True(t, created)
+	assert.Equal(t, Rejected, o.Status)
+
+	m.Register(&fakeExecutor{key: orderbook.Indodax, err: errors.New("insufficient balance")})
+	o, _, _ = m.Submit(limitBuy("b"))
+	assert.Equal(t, Rejected, o.Status)
+	assert.Equal(t, "insufficient balance", o.Reason)
+
+	_, _, err = m.Submit(Request{Symbol: orderbook.ETH_IDR, Qty: d("1"), Side: Buy, Type: Market, LimitPrice: o.LimitPrice})
+	assert.Equal(t, ValidationError{"limit_price", "is not used by this order type"}, err)
+}
+
+func TestLifecycle(t *testing.T) {
+	m := NewManager()
+	executor := &fakeExecutor{key: orderbook.Indodax}
+	m.Register(executor)
+	m.Submit(limitBuy("a"))
+
+	assert.Nil(t, m.Apply(Execution{ClientOrderID: "a", Qty: d("0.5"), Price: d("3000000")}))
+	assert.Nil(t, m.Apply(Execution{ClientOrderID: "a", Qty: d("1"), Price: d("2990000")}))
+	o, _ := m.Get("a")
+	assert.Equal(t, PartiallyFilled, o.Status)
+	assert.Equal(t, "1.5", o.FilledQty.String())
+	assert.True(t, d("4490000").Div(d("1.5")).Equal(o.FilledAvgPrice))
+
+	// more than what is left
+	assert.NotNil(t, m.Apply(Execution{ClientOrderID: "a", Qty: d("1"), Price: d("3000000")}))
+
+	_, err := m.Cancel("a")
+	assert.Nil(t, err)
+	assert.Len(t, executor.cancelled, 1)
+	assert.Nil(t, m.Apply(Execution{ClientOrderID: "a", Status: Cancelled}))
+	o, _ = m.Get("a")
+	assert.Equal(t, Cancelled, o.Status)
+
+	// done orders don't move anymore
+	assert.NotNil(t, m.Apply(Execution{ClientOrderID: "a", Qty: d("0.5"), Price: d("3000000")}))
+	_, err = m.Cancel("a")
+	assert.NotNil(t, err)
+	_, err = m.Cancel("b")
+	assert.Equal(t, ErrNotFound, err)
+}
@@ -0,0 +1,228 @@
+// Package orders tracks the orders sent to the exchanges,
+// from their request to their last execution
+package orders
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/shopspring/decimal"
+)
+
+// Side of an order
+type Side string
+
+const (
+	Buy  Side = "buy"
+	Sell Side = "sell"
+)
+
+// Type of an order
+type Type string
+
+const (
+	Market    Type = "market"
+	Limit     Type = "limit"
+	Stop      Type = "stop"
+	StopLimit Type = "stop_limit"
+)
+
+// TimeInForce tells how long an order stays on the book
+type TimeInForce string
+
+const (
+	GTC TimeInForce = "gtc" // Good till cancelled
+	IOC TimeInForce = "ioc" // Immediate or cancel
+	FOK TimeInForce = "fok" // Fill or kill
+	Day TimeInForce = "day"
+)
+
+// Status is the state of an order in its lifecycle
+type Status string
+
+const (
+	// New orders are recorded but not acknowledged by the exchange yet
+	New Status = "new"
+	// Acknowledged orders are live on the exchange
+	Acknowledged Status = "acknowledged"
+	// PartiallyFilled orders are live with some quantity filled
+	PartiallyFilled Status = "partially_filled"
+	// Filled, Cancelled and Rejected orders are done
+	Filled    Status = "filled"
+	Cancelled Status = "cancelled"
+	Rejected  Status = "rejected"
+)
+
+// transitions lists the statuses each status may move to
+var transitions = map[Status][]Status{
+	New:             {Acknowledged, PartiallyFilled, Filled, Cancelled, Rejected},
+	Acknowledged:    {PartiallyFilled, Filled, Cancelled},
+	PartiallyFilled: {PartiallyFilled, Filled, Cancelled},
+}
+
+// Done tells whether an order can't change anymore
+func (s Status) Done() bool {
+	return s == Filled || s == Cancelled || s == Rejected
+}
+
+// CanMoveTo tells whether an order may go from s to status
+func (s Status) CanMoveTo(status Status) bool {
+	for _, to := range transitions[s] {
+		if to == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Request is the body of POST /api/v0/orders
+type Request struct {
+	ClientOrderID string                `json:"client_order_id"`
+	Symbol        orderbook.Symbol      `json:"symbol"`
+	Exchange      orderbook.ExchangeKey `json:"exchange,omitempty"` // The first exchange listing the symbol when empty
+	Qty           decimal.Decimal       `json:"qty"`
+	Side          Side                  `json:"side"`
+	Type          Type                  `json:"order_type"`
+	TimeInForce   TimeInForce           `json:"time_in_force"`
+	LimitPrice    *decimal.Decimal      `json:"limit_price,omitempty"`
+	StopPrice     *decimal.Decimal      `json:"stop_price,omitempty"`
+}
+
+// ValidationError tells why a request can't be turned into an order
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("invalid %v: %v", e.Field, e.Reason)
+}
+
+// Validate checks the request, filling in the default exchange
+// and time in force
+func (r *Request) Validate() error {
+	exchanges, ok := orderbook.SymbolMap[r.Symbol]
+	if !ok {
+		return ValidationError{"symbol", fmt.Sprintf("%q is not tradable", r.Symbol)}
+	}
+	if r.Exchange == "" {
+		r.Exchange = exchanges[0]
+	}
+	listed := false
+	for _, key := range exchanges {
+		listed = listed || key == r.Exchange
+	}
+	if !listed {
+		return ValidationError{"exchange", fmt.Sprintf("%v does not list %v", r.Exchange, r.Symbol)}
+	}
+	if !r.Qty.IsPositive() {
+		return ValidationError{"qty", "must be positive"}
+	}
+	if r.Side != Buy && r.Side != Sell {
+		return ValidationError{"side", fmt.Sprintf("%q is not buy or sell", r.Side)}
+	}
+	if r.TimeInForce == "" {
+		r.TimeInForce = GTC
+	}
+	switch r.TimeInForce {
+	case GTC, IOC, FOK, Day:
+	default:
+		return ValidationError{"time_in_force", fmt.Sprintf("%q is not supported", r.TimeInForce)}
+	}
+
+	needsLimit, needsStop := false, false
+	switch r.Type {
+	case Market:
+	case Limit:
+		needsLimit = true
+	case Stop:
+		needsStop = true
+	case StopLimit:
+		needsLimit, needsStop = true, true
+	default:
+		return ValidationError{"order_type", fmt.Sprintf("%q is not supported", r.Type)}
+	}
+	if err := checkPrice("limit_price", r.LimitPrice, needsLimit); err != nil {
+		return err
+	}
+	return checkPrice("stop_price", r.StopPrice, needsStop)
+}
+
+func checkPrice(field string, price *decimal.Decimal, required bool) error {
+	if price == nil {
+		if required {
+			return ValidationError{field, "is required for this order type"}
+		}
+		return nil
+	}
+	if !required {
+		return ValidationError{field, "is not used by this order type"}
+	}
+	if !price.IsPositive() {
+		return ValidationError{field, "must be positive"}
+	}
+	return nil
+}
+
+// same tells whether two requests describe the same order
+func (r Request) same(o Request) bool {
+	return r.Symbol == o.Symbol &&
+		r.Exchange == o.Exchange &&
+		r.Qty.Equal(o.Qty) &&
+		r.Side == o.Side &&
+		r.Type == o.Type &&
+		r.TimeInForce == o.TimeInForce &&
+		samePrice(r.LimitPrice, o.LimitPrice) &&
+		samePrice(r.StopPrice, o.StopPrice)
+}
+
+func samePrice(a, b *decimal.Decimal) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// Order is a request as tracked through its lifecycle
+type Order struct {
+	Request
+	ID              string          `json:"id"`
+	ExchangeOrderID string          `json:"exchange_order_id,omitempty"`
+	Status          Status          `json:"status"`
+	Reason          string          `json:"reason,omitempty"` // Why the order was rejected
+	FilledQty       decimal.Decimal `json:"filled_qty"`
+	FilledAvgPrice  decimal.Decimal `json:"filled_avg_price"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+}
+
+// moveTo changes the status of the order, failing when
+// the lifecycle does not allow it
+func (o *Order) moveTo(status Status, now time.Time) error {
+	if !o.Status.CanMoveTo(status) {
+		return fmt.Errorf("order %v can't go from %v to %v", o.ClientOrderID, o.Status, status)
+	}
+	o.Status = status
+	o.UpdatedAt = now
+	return nil
+}
+
+// fill adds an execution of qty at price to the order
+func (o *Order) fill(qty, price decimal.Decimal, now time.Time) error {
+	filled := o.FilledQty.Add(qty)
+	if filled.GreaterThan(o.Qty) {
+		return fmt.Errorf("order %v overfilled: %v of %v", o.ClientOrderID, filled, o.Qty)
+	}
+	status := PartiallyFilled
+	if filled.Equal(o.Qty) {
+		status = Filled
+	}
+	if err := o.moveTo(status, now); err != nil {
+		return err
+	}
+	notional := o.FilledAvgPrice.Mul(o.FilledQty).Add(price.Mul(qty))
+	o.FilledQty = filled
+	o.FilledAvgPrice = notional.Div(filled)
+	return nil
+}
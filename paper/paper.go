@@ -0,0 +1,439 @@
+// Package paper simulates the exchanges: orders are matched against the
+// live books of the exchange they are for and settled on simulated balances
+package paper
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/orders"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	// ErrNotOpen is returned when cancelling an order that is not open
+	ErrNotOpen = errors.New("order is not open")
+	// ErrStopped is returned for the orders sent once the simulator is stopped
+	ErrStopped = errors.New("simulator is stopped")
+)
+
+// Balance of an asset, Locked is held by the open orders
+type Balance struct {
+	Free   decimal.Decimal `json:"free"`
+	Locked decimal.Decimal `json:"locked"`
+}
+
+// Fill is an execution of a simulated order
+type Fill struct {
+	ClientOrderID string           `json:"client_order_id"`
+	Symbol        orderbook.Symbol `json:"symbol"`
+	Side          orders.Side      `json:"side"`
+	Qty           decimal.Decimal  `json:"qty"`
+	Price         decimal.Decimal  `json:"price"` // Average price of the levels taken
+	Fee           decimal.Decimal  `json:"fee"`   // Taker fee, in the quote currency
+	Time          time.Time        `json:"time"`
+}
+
+// Exchange is an orders.Executor standing in for an exchange. Orders
+// take the liquidity of the books of that exchange, walking the levels
+// from the best price, and pay its taker fee from orderbook.Fees.
+//
+// The liquidity the orders take is kept out of their reach until the
+// book changes the level it was taken from: a level whose quantity
+// moves is taken as a fresh one.
+type Exchange struct {
+	// Books are the books orders are matched against,
+	// those of the exchange in orderbook.Exchanges by default
+	Books orderbook.OrderBookMap
+	// Now is the clock fills are timed with, time.Now by default
+	Now func() time.Time
+
+	key        orderbook.ExchangeKey
+	balances   map[string]*Balance
+	open       map[string]*resting
+	consumed   map[level]consumed
+	fills      []Fill
+	executions chan orders.Execution
+	ids        int
+	stopped    bool // The executions are closed
+	mu         sync.Mutex
+	quit       chan struct{}
+	done       chan struct{}
+}
+
+// resting is an open order and what is left of it
+type resting struct {
+	order     orders.Order
+	remaining decimal.Decimal
+	locked    decimal.Decimal // Held for the order, in the currency it spends
+	triggered bool            // The stop price was reached
+}
+
+// level is a price level of one side of a book
+type level struct {
+	symbol orderbook.Symbol
+	bid    bool
+	price  string
+}
+
+// consumed is the quantity the orders took from a level
+type consumed struct {
+	qty   decimal.Decimal // Quantity of the level when it was taken from
+	taken decimal.Decimal
+}
+
+// New creates a simulator of an exchange, starting with balances
+func New(key orderbook.ExchangeKey, balances map[string]decimal.Decimal) *Exchange {
+	e := &Exchange{
+		Books:      orderbook.Exchanges[key].Books,
+		Now:        time.Now,
+		key:        key,
+		balances:   make(map[string]*Balance),
+		open:       make(map[string]*resting),
+		consumed:   make(map[level]consumed),
+		executions: make(chan orders.Execution, 1024),
+	}
+	for asset, amount := range balances {
+		e.balances[asset] = &Balance{Free: amount}
+	}
+	return e
+}
+
+// Key returns the exchange simulated
+func (e *Exchange) Key() orderbook.ExchangeKey {
+	return e.key
+}
+
+// Executions reports the fills and the cancellations of the orders,
+// it is closed by Stop
+func (e *Exchange) Executions() <-chan orders.Execution {
+	return e.executions
+}
+
+// Submit locks the balance an order spends and matches it on the book
+// of its symbol. The remainder of limit orders rests until cancelled,
+// that of market orders and IOC orders is cancelled.
+func (e *Exchange) Submit(o orders.Order) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stopped {
+		return "", ErrStopped
+	}
+	book, ok := e.Books[o.Symbol]
+	if !ok {
+		return "", fmt.Errorf("no %v book to match against", o.Symbol)
+	}
+
+	base := orderbook.GetLeftCurrency(string(o.Symbol))
+	quote := orderbook.GetRightCurrency(string(o.Symbol))
+	asset, lock := base, o.Qty
+	if o.Side == orders.Buy {
+		asset, lock = quote, e.cost(o, book)
+	}
+	balance := e.balance(asset)
+	if balance.Free.LessThan(lock) {
+		return "", fmt.Errorf("insufficient %v balance: %v free, %v needed", asset, balance.Free, lock)
+	}
+	balance.Free = balance.Free.Sub(lock)
+	balance.Locked = balance.Locked.Add(lock)
+
+	e.ids++
+	r := &resting{
+		order:     o,
+		remaining: o.Qty,
+		locked:    lock,
+		triggered: o.StopPrice == nil,
+	}
+	e.open[o.ClientOrderID] = r
+	e.match(r, book)
+	return fmt.Sprintf("paper-%d", e.ids), nil
+}
+
+// cost is the quote a buy order locks: its limit or stop price
+// when it has one, the cost of filling it now otherwise
+func (e *Exchange) cost(o orders.Order, book *orderbook.OrderBook) decimal.Decimal {
+	var notional decimal.Decimal
+	switch {
+	case o.LimitPrice != nil:
+		notional = o.LimitPrice.Mul(o.Qty)
+	case o.StopPrice != nil:
+		notional = o.StopPrice.Mul(o.Qty)
+	default:
+		notional = book.FillBuy(o.Qty).Notional
+	}
+	return notional.Add(notional.Mul(orderbook.Fees.Taker(e.key, o.Symbol)))
+}
+
+// Cancel cancels an open order
+func (e *Exchange) Cancel(o orders.Order) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stopped {
+		return ErrStopped
+	}
+	r, ok := e.open[o.ClientOrderID]
+	if !ok {
+		return ErrNotOpen
+	}
+	e.cancel(r, "")
+	return nil
+}
+
+// Start matches the open orders every time their book is updated
+func (e *Exchange) Start() {
+	e.quit = make(chan struct{})
+	e.done = make(chan struct{})
+	updates := orderbook.SubscribeUpdates()
+	go func() {
+		defer close(e.done)
+		defer orderbook.UnsubscribeUpdates(updates)
+		for {
+			select {
+			case <-e.quit:
+				return
+			case update := <-updates:
+				if update.Exchange == e.key {
+					e.Update(update.Symbol)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops matching the open orders on book updates, and closes
+// Executions: the orders left open are not matched anymore
+func (e *Exchange) Stop() {
+	close(e.quit)
+	<-e.done
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stopped = true
+	close(e.executions)
+}
+
+// Update matches the open orders of a symbol against its book,
+// and expires the day orders of the previous days
+func (e *Exchange) Update(symbol orderbook.Symbol) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	book, ok := e.Books[symbol]
+	if !ok || e.stopped {
+		return
+	}
+	today := e.Now().UTC().Truncate(24 * time.Hour)
+	for _, r := range e.sorted() {
+		if r.order.Symbol != symbol {
+			continue
+		}
+		if r.order.TimeInForce == orders.Day && r.order.CreatedAt.UTC().Before(today) {
+			e.cancel(r, "expired")
+			continue
+		}
+		e.match(r, book)
+	}
+}
+
+// sorted returns the open orders, oldest first
+func (e *Exchange) sorted() []*resting {
+	list := make([]*resting, 0, len(e.open))
+	for _, r := range e.open {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].order.CreatedAt.Before(list[j].order.CreatedAt)
+	})
+	return list
+}
+
+// match fills an order with the liquidity of book priced within its
+// limit, once its stop price is reached
+func (e *Exchange) match(r *resting, book *orderbook.OrderBook) {
+	o := r.order
+	buy := o.Side == orders.Buy
+	if !r.triggered {
+		bid, ask, ok := book.BBO()
+		if !ok {
+			return
+		}
+		r.triggered = (buy && ask.Price.GreaterThanOrEqual(*o.StopPrice)) ||
+			(!buy && bid.Price.LessThanOrEqual(*o.StopPrice))
+		if !r.triggered {
+			return
+		}
+	}
+
+	limit := decimal.Zero
+	if o.LimitPrice != nil {
+		limit = *o.LimitPrice
+	}
+	var fill orderbook.Fill
+	if buy {
+		fill = book.FillBuyLimitFrom(e.available(o.Symbol, false), r.remaining, limit)
+	} else {
+		fill = book.FillSellLimitFrom(e.available(o.Symbol, true), r.remaining, limit)
+	}
+
+	if o.TimeInForce == orders.FOK && !fill.Filled() {
+		e.cancel(r, "not enough liquidity to fill the order")
+		return
+	}
+	if fill.Qty.IsPositive() && !e.settle(r, fill) {
+		return
+	}
+	if r.remaining.IsPositive() && (limit.IsZero() || o.TimeInForce == orders.IOC || o.TimeInForce == orders.FOK) {
+		e.cancel(r, "not enough liquidity to fill the order")
+	}
+}
+
+// available returns the quantity of the levels of a side the
+// orders did not take yet, forgetting the levels the book changed
+func (e *Exchange) available(symbol orderbook.Symbol, bid bool) orderbook.Available {
+	return func(o orderbook.Order) decimal.Decimal {
+		key := level{symbol, bid, o.Price.String()}
+		c, ok := e.consumed[key]
+		if !ok {
+			return o.Qty
+		}
+		if !c.qty.Equal(o.Qty) {
+			delete(e.consumed, key)
+			return o.Qty
+		}
+		return o.Qty.Sub(c.taken)
+	}
+}
+
+// consume keeps the liquidity taken by a fill from the next orders
+func (e *Exchange) consume(symbol orderbook.Symbol, bid bool, fill orderbook.Fill) {
+	for _, t := range fill.Takes {
+		key := level{symbol, bid, t.Level.Price.String()}
+		c := e.consumed[key]
+		c.qty = t.Level.Qty
+		c.taken = c.taken.Add(t.Qty)
+		e.consumed[key] = c
+	}
+}
+
+// settle moves the balances for a fill of r, cancelling r instead
+// when the balance can't pay for it
+func (e *Exchange) settle(r *resting, fill orderbook.Fill) bool {
+	o := r.order
+	base := e.balance(orderbook.GetLeftCurrency(string(o.Symbol)))
+	quote := e.balance(orderbook.GetRightCurrency(string(o.Symbol)))
+	fee := fill.Notional.Mul(orderbook.Fees.Taker(e.key, o.Symbol))
+
+	if o.Side == orders.Buy {
+		cost := fill.Notional.Add(fee)
+		fromLocked := decimal.Min(cost, r.locked)
+		if quote.Free.LessThan(cost.Sub(fromLocked)) {
+			e.cancel(r, "insufficient balance")
+			return false
+		}
+		r.locked = r.locked.Sub(fromLocked)
+		quote.Locked = quote.Locked.Sub(fromLocked)
+		quote.Free = quote.Free.Sub(cost.Sub(fromLocked))
+		base.Free = base.Free.Add(fill.Qty)
+	} else {
+		r.locked = r.locked.Sub(fill.Qty)
+		base.Locked = base.Locked.Sub(fill.Qty)
+		quote.Free = quote.Free.Add(fill.Notional.Sub(fee))
+	}
+
+	e.consume(o.Symbol, o.Side == orders.Sell, fill)
+	now := e.Now()
+	r.remaining = r.remaining.Sub(fill.Qty)
+	e.fills = append(e.fills, Fill{
+		ClientOrderID: o.ClientOrderID,
+		Symbol:        o.Symbol,
+		Side:          o.Side,
+		Qty:           fill.Qty,
+		Price:         fill.AvgPrice,
+		Fee:           fee,
+		Time:          now,
+	})
+	e.executions <- orders.Execution{
+		ClientOrderID: o.ClientOrderID,
+		Qty:           fill.Qty,
+		Price:         fill.AvgPrice,
+		Time:          now,
+	}
+	if !r.remaining.IsPositive() {
+		e.release(r)
+	}
+	return true
+}
+
+// cancel closes an open order, reason is empty when it was asked for
+func (e *Exchange) cancel(r *resting, reason string) {
+	e.release(r)
+	e.executions <- orders.Execution{
+		ClientOrderID: r.order.ClientOrderID,
+		Status:        orders.Cancelled,
+		Reason:        reason,
+		Time:          e.Now(),
+	}
+}
+
+// release closes an order, freeing what it still holds
+func (e *Exchange) release(r *resting) {
+	asset := orderbook.GetLeftCurrency(string(r.order.Symbol))
+	if r.order.Side == orders.Buy {
+		asset = orderbook.GetRightCurrency(string(r.order.Symbol))
+	}
+	balance := e.balance(asset)
+	balance.Locked = balance.Locked.Sub(r.locked)
+	balance.Free = balance.Free.Add(r.locked)
+	r.locked = decimal.Zero
+	delete(e.open, r.order.ClientOrderID)
+}
+
+func (e *Exchange) balance(asset string) *Balance {
+	b, ok := e.balances[asset]
+	if !ok {
+		b = &Balance{}
+		e.balances[asset] = b
+	}
+	return b
+}
+
+// Balances returns the simulated balance of every asset
+func (e *Exchange) Balances() map[string]Balance {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	balances := make(map[string]Balance, len(e.balances))
+	for asset, b := range e.balances {
+		balances[asset] = *b
+	}
+	return balances
+}
+
+// Fills returns the fills of the orders, oldest first
+func (e *Exchange) Fills() []Fill {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Fill(nil), e.fills...)
+}
+
+// ParseBalances parses starting balances such as "USDT=10000,ETH=2.5"
+func ParseBalances(s string) (map[string]decimal.Decimal, error) {
+	balances := make(map[string]decimal.Decimal)
+	for _, entry := range strings.Split(s, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("balance %q is not ASSET=AMOUNT", entry)
+		}
+		amount, err := decimal.NewFromString(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("balance %q: %v", entry, err)
+		}
+		balances[strings.ToUpper(strings.TrimSpace(parts[0]))] = amount
+	}
+	return balances, nil
+}
@@ -0,0 +1,193 @@
+package paper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/orders"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+var d = decimal.RequireFromString
+
+func newTestExchange(t *testing.T) (*Exchange, *orderbook.OrderBook) {
+	fees := orderbook.Fees
+	orderbook.Fees = orderbook.FeeMap{orderbook.Binance: {Taker: d("0.001")}}
+	t.Cleanup(func() { orderbook.Fees = fees })
+
+	book := orderbook.NewOrderBook()
+	book.Replace(
+		[]orderbook.Order{{Price: d("99"), Qty: d("1")}, {Price: d("98"), Qty: d("2")}},
+		[]orderbook.Order{{Price: d("100"), Qty: d("1")}, {Price: d("101"), Qty: d("2")}},
+	)
+	e := New(orderbook.Binance, map[string]decimal.Decimal{"USDT": d("1000"), "ETH": d("5")})
+	e.Books = orderbook.OrderBookMap{orderbook.ETH_USDT: book}
+	return e, book
+}
+
+func order(id string, side orders.Side, qty string, limit string, tif orders.TimeInForce) orders.Order {
+	o := orders.Order{Request: orders.Request{
+		ClientOrderID: id,
+		Symbol:        orderbook.ETH_USDT,
+		Exchange:      orderbook.Binance,
+		Qty:           d(qty),
+		Side:          side,
+		Type:          orders.Market,
+		TimeInForce:   tif,
+	}, CreatedAt: time.Now()}
+	if limit != "" {
+		price := d(limit)
+		o.Type, o.LimitPrice = orders.Limit, &price
+	}
+	return o
+}
+
+func TestMarketOrders(t *testing.T) {
+	e, _ := newTestExchange(t)
+
+	// 1 @ 100 and 1 @ 101, plus 0.1% of 201
+	_, err := e.Submit(order("a", orders.Buy, "2", "", orders.GTC))
+	assert.Nil(t, err)
+	execution := <-e.Executions()
+	assert.Equal(t, "2", execution.Qty.String())
+	assert.Equal(t, "100.5", execution.Price.String())
+	balances := e.Balances()
+	assert.Equal(t, "798.799", balances["USDT"].Free.String())
+	assert.True(t, balances["USDT"].Locked.IsZero())
+	assert.Equal(t, "7", balances["ETH"].Free.String())
+	assert.Equal(t, "0.201", e.Fills()[0].Fee.String())
+
+	// the bids only hold 3, the rest is cancelled
+	_, err = e.Submit(order("b", orders.Sell, "4", "", orders.GTC))
+	assert.Nil(t, err)
+	assert.Equal(t, "3", (<-e.Executions()).Qty.String())
+	assert.Equal(t, orders.Cancelled, (<-e.Executions()).Status)
+	balances = e.Balances()
+	assert.Equal(t, "4", balances["ETH"].Free.String())
+	assert.True(t, balances["ETH"].Locked.IsZero())
+	// 295 less 0.295 of fees
+	assert.Equal(t, "1093.504", balances["USDT"].Free.String())
+
+	_, err = e.Submit(order("c", orders.Sell, "10", "", orders.GTC))
+	assert.NotNil(t, err)
+}
+
+func TestLimitOrders(t *testing.T) {
+	e, book := newTestExchange(t)
+
+	// 1 @ 100 is taken, the rest rests with 100.1 locked
+	_, err := e.Submit(order("a", orders.Buy, "2", "100", orders.GTC))
+	assert.Nil(t, err)
+	assert.Equal(t, "1", (<-e.Executions()).Qty.String())
+	assert.Equal(t, "100.1", e.Balances()["USDT"].Locked.String())
+
+	// the book moves down to the limit
+	book.Apply(nil, []orderbook.Order{{Price: d("99.5"), Qty: d("3")}})
+	e.Update(orderbook.ETH_USDT)
+	execution := <-e.Executions()
+	assert.Equal(t, "1", execution.Qty.String())
+	assert.Equal(t, "99.5", execution.Price.String())
+	balances := e.Balances()
+	assert.True(t, balances["USDT"].Locked.IsZero())
+	assert.Equal(t, "800.3005", balances["USDT"].Free.String())
+
+	// FOK orders fill entirely or not at all
+	_, err = e.Submit(order("b", orders.Sell, "3", "98.5", orders.FOK))
+	assert.Nil(t, err)
+	execution = <-e.Executions()
+	assert.Equal(t, orders.Cancelled, execution.Status)
+	assert.Empty(t, e.open)
+
+	// cancelling frees the locked balance
+	_, err = e.Submit(order("c", orders.Sell, "1", "200", orders.GTC))
+	assert.Nil(t, err)
+	assert.Equal(t, "1", e.Balances()["ETH"].Locked.String())
+	assert.Nil(t, e.Cancel(order("c", orders.Sell, "1", "200", orders.GTC)))
+	assert.Equal(t, orders.Cancelled, (<-e.Executions()).Status)
+	assert.Equal(t, "7", e.Balances()["ETH"].Free.String())
+	assert.Equal(t, ErrNotOpen, e.Cancel(order("c", orders.Sell, "1", "200", orders.GTC)))
+}
+
+func TestConsumedLiquidity(t *testing.T) {
+	e, book := newTestExchange(t)
+
+	_, err := e.Submit(order("a", orders.Buy, "3", "100", orders.GTC))
+	assert.Nil(t, err)
+	assert.Equal(t, "1", (<-e.Executions()).Qty.String())
+
+	// the ask taken is not taken again, by the resting order or another
+	e.Update(orderbook.ETH_USDT)
+	_, err = e.Submit(order("b", orders.Buy, "2", "", orders.IOC))
+	assert.Nil(t, err)
+	execution := <-e.Executions()
+	assert.Equal(t, "2", execution.Qty.String())
+	assert.Equal(t, "101", execution.Price.String())
+	assert.Empty(t, e.Executions())
+
+	// until the book changes the level
+	book.Apply(nil, []orderbook.Order{{Price: d("100"), Qty: d("4")}})
+	e.Update(orderbook.ETH_USDT)
+	execution = <-e.Executions()
+	assert.Equal(t, "2", execution.Qty.String())
+	assert.Equal(t, "100", execution.Price.String())
+	assert.Empty(t, e.open)
+
+	_, err = e.Submit(order("c", orders.Buy, "3", "100", orders.IOC))
+	assert.Nil(t, err)
+	assert.Equal(t, "2", (<-e.Executions()).Qty.String())
+	assert.Equal(t, orders.Cancelled, (<-e.Executions()).Status)
+}
+
+func TestStopOrders(t *testing.T) {
+	e, book := newTestExchange(t)
+
+	stop := d("98.5")
+	o := order("a", orders.Sell, "1", "", orders.GTC)
+	o.Type, o.StopPrice = orders.Stop, &stop
+	_, err := e.Submit(o)
+	assert.Nil(t, err)
+	assert.Len(t, e.open, 1)
+
+	book.Apply([]orderbook.Order{{Price: d("99"), Qty: d("0")}}, nil)
+	e.Update(orderbook.ETH_USDT)
+	execution := <-e.Executions()
+	assert.Equal(t, "98", execution.Price.String())
+	assert.Empty(t, e.open)
+}
+
+func TestWithManager(t *testing.T) {
+	e, _ := newTestExchange(t)
+	m := orders.NewManager()
+	m.Register(e)
+
+	price := d("100")
+	o, _, err := m.Submit(orders.Request{
+		ClientOrderID: "a", Symbol: orderbook.ETH_USDT, Qty: d("1"),
+		Side: orders.Buy, Type: orders.Limit, LimitPrice: &price,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "paper-1", o.ExchangeOrderID)
+	assert.Eventually(t, func() bool {
+		o, _ := m.Get("a")
+		return o.Status == orders.Filled
+	}, time.Second, time.Millisecond)
+
+	o, _, err = m.Submit(orders.Request{
+		ClientOrderID: "b", Symbol: orderbook.ETH_USDT, Qty: d("100"),
+		Side: orders.Sell, Type: orders.Market,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, orders.Rejected, o.Status)
+}
+
+func TestParseBalances(t *testing.T) {
+	balances, err := ParseBalances("usdt=10000, ETH=2.5")
+	assert.Nil(t, err)
+	assert.Equal(t, "10000", balances["USDT"].String())
+	assert.Equal(t, "2.5", balances["ETH"].String())
+
+	_, err = ParseBalances("USDT")
+	assert.NotNil(t, err)
+}
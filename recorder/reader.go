@@ -0,0 +1,208 @@
+package recorder
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+)
+
+// Reader reads the records of files one after the other
+type Reader struct {
+	paths []string
+	f     *os.File
+	gz    *gzip.Reader
+	dec   *json.Decoder
+}
+
+// Open returns a reader of the records of paths, in that order
+func Open(paths ...string) *Reader {
+	return &Reader{paths: paths}
+}
+
+// Stream is the data recorded for an exchange and symbol
+type Stream struct {
+	Exchange orderbook.ExchangeKey
+	Symbol   orderbook.Symbol
+}
+
+// Streams lists the exchanges and symbols recorded under dir
+func Streams(dir string) ([]Stream, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	var streams []Stream
+	for _, path := range paths {
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			continue
+		}
+		streams = append(streams, Stream{
+			Exchange: orderbook.ExchangeKey(filepath.Base(filepath.Dir(path))),
+			Symbol:   orderbook.Symbol(strings.Replace(filepath.Base(path), "-", "/", -1)),
+		})
+	}
+	return streams, nil
+}
+
+// Files lists the files recorded for an exchange and symbol, oldest
+// first: by day, and the segments of a day in the order they were written
+func Files(dir string, exchange orderbook.ExchangeKey, symbol orderbook.Symbol) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, string(exchange),
+		strings.Replace(string(symbol), "/", "-", -1), "*"+ext))
+	sort.Slice(paths, func(i, j int) bool {
+		dayI, nI := segment(paths[i])
+		dayJ, nJ := segment(paths[j])
+		if dayI != dayJ {
+			return dayI < dayJ
+		}
+		return nI < nJ
+	})
+	return paths, err
+}
+
+// segment returns the day of a file and its segment number
+func segment(path string) (day string, n int) {
+	name := strings.TrimSuffix(filepath.Base(path), ext)
+	if i := strings.LastIndex(name, "-"); i == len("2006-01-02") {
+		if n, err := strconv.Atoi(name[i+1:]); err == nil {
+			return name[:i], n
+		}
+	}
+	return name, 0
+}
+
+// Next returns the next record, or io.EOF once every file is read.
+// A file cut by a crash ends at its last complete record.
+func (r *Reader) Next() (rec Record, err error) {
+	for {
+		if r.dec == nil {
+			if len(r.paths) == 0 {
+				return rec, io.EOF
+			}
+			if err := r.open(r.paths[0]); err != nil {
+				return rec, err
+			}
+			r.paths = r.paths[1:]
+		}
+		err := r.dec.Decode(&rec)
+		if err == nil {
+			return rec, nil
+		}
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return rec, err
+		}
+		r.closeFile()
+	}
+}
+
+func (r *Reader) open(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.gz, r.dec = f, gz, json.NewDecoder(gz)
+	return nil
+}
+
+func (r *Reader) closeFile() {
+	if r.f != nil {
+		r.gz.Close()
+		r.f.Close()
+	}
+	r.f, r.gz, r.dec = nil, nil, nil
+}
+
+// Close closes the file being read
+func (r *Reader) Close() error {
+	r.closeFile()
+	r.paths = nil
+	return nil
+}
+
+// Merger reads the records of several readers
+// ordered by the time they were received
+type Merger struct {
+	readers []*Reader
+	heads   []*Record // Next record of each reader, nil once read
+	started bool
+}
+
+// Merge returns the records of readers ordered by the time they were
+// received, those received at the same time in the order of readers
+func Merge(readers ...*Reader) *Merger {
+	return &Merger{readers: readers, heads: make([]*Record, len(readers))}
+}
+
+// OpenDir merges the records of every stream recorded under dir
+func OpenDir(dir string) (*Merger, error) {
+	streams, err := Streams(dir)
+	if err != nil {
+		return nil, err
+	}
+	readers := make([]*Reader, 0, len(streams))
+	for _, s := range streams {
+		paths, err := Files(dir, s.Exchange, s.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, Open(paths...))
+	}
+	return Merge(readers...), nil
+}
+
+// Next returns the next record, or io.EOF once every reader is read
+func (m *Merger) Next() (Record, error) {
+	if !m.started {
+		m.started = true
+		for i := range m.readers {
+			if err := m.advance(i); err != nil {
+				return Record{}, err
+			}
+		}
+	}
+	next := -1
+	for i, head := range m.heads {
+		if head != nil && (next < 0 || head.Received.Before(m.heads[next].Received)) {
+			next = i
+		}
+	}
+	if next < 0 {
+		return Record{}, io.EOF
+	}
+	rec := *m.heads[next]
+	return rec, m.advance(next)
+}
+
+func (m *Merger) advance(i int) error {
+	rec, err := m.readers[i].Next()
+	if err == io.EOF {
+		m.heads[i] = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	m.heads[i] = &rec
+	return nil
+}
+
+// Close closes every reader
+func (m *Merger) Close() error {
+	for _, r := range m.readers {
+		r.Close()
+	}
+	return nil
+}
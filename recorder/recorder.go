@@ -0,0 +1,289 @@
+// Package recorder writes the raw depth data received from the exchanges
+// to disk, and reads it back.
+//
+// Records are stored under the directory of the Recorder, one file per
+// exchange, symbol and UTC day the data was received on:
+//
+//	<dir>/<exchange>/<base>-<quote>/<yyyy-mm-dd>.jsonl.gz
+//
+// Files are never reopened: a recorder started on a day already recorded
+// writes a new segment of the day, <yyyy-mm-dd>-1.jsonl.gz, then -2 and so
+// on, read after the segments before it.
+//
+// A file is a gzip stream of JSON lines, one per record:
+//
+//	{"received":"2020-01-06T10:31:34.123456789Z","exchange":"Binance","symbol":"BTC/USDC","kind":"diff","data":{...}}
+//
+// received is the time the data was received, kind is snapshot for a full
+// depth or diff for a change of the depth, and data is the payload as the
+// exchange sent it: a Binance depth snapshot or depth update event, or an
+// Indodax depth, which is always a snapshot.
+//
+// The records are flushed to disk every FlushInterval, when their file is
+// rotated and when the recorder is closed: data written before a crash is
+// readable up to the last record flushed, and the segment written after
+// the restart is read in full.
+package recorder
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/logging"
+	"github.com/anthonychristian/crypto-arbitrage/metrics"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+)
+
+// FlushInterval is how often the records written are flushed to disk
+var FlushInterval = time.Second
+
+// queueSize is how many records Save queues for the recorder,
+// the records saved while the queue is full are dropped
+const queueSize = 4096
+
+// errorLog samples the errors, a full disk fails every record
+var errorLog = logging.New("recorder").Sampled(1, 1000)
+
+// Kind tells whether a record holds a full depth or a change of the depth
+type Kind string
+
+const (
+	Snapshot Kind = "snapshot"
+	Diff     Kind = "diff"
+)
+
+// Record is a payload received from an exchange
+type Record struct {
+	Received time.Time             `json:"received"`
+	Exchange orderbook.ExchangeKey `json:"exchange"`
+	Symbol   orderbook.Symbol      `json:"symbol"`
+	Kind     Kind                  `json:"kind"`
+	Data     json.RawMessage       `json:"data"`
+}
+
+// Recorder appends records to the file of their exchange,
+// symbol and day, rotating the files at midnight UTC
+type Recorder struct {
+	Dir string
+
+	files map[string]*file // By exchange and symbol
+	queue chan Record      // Records saved, written by run
+	mu    sync.Mutex
+	quit  chan struct{}
+	done  chan struct{}
+}
+
+// file is the open file of an exchange and symbol
+type file struct {
+	day   string // Path of the first segment of the day
+	path  string
+	f     *os.File
+	gz    *gzip.Writer
+	dirty bool // Records were written since the last flush
+}
+
+// New creates a recorder writing under dir, it writes the records
+// saved and flushes the files until it is closed
+func New(dir string) *Recorder {
+	r := &Recorder{
+		Dir:   dir,
+		files: make(map[string]*file),
+		queue: make(chan Record, queueSize),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	metrics.SetQueue("recorder", func() int { return len(r.queue) })
+	go r.run(FlushInterval)
+	return r
+}
+
+// run writes the queued records, and flushes the files every interval
+func (r *Recorder) run(interval time.Duration) {
+	defer close(r.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case rec := <-r.queue:
+			r.write(rec)
+		case <-ticker.C:
+			if err := r.Flush(); err != nil {
+				errorLog.Error("error flushing the records", "err", err)
+			}
+		case <-r.quit:
+			for {
+				select {
+				case rec := <-r.queue:
+					r.write(rec)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (r *Recorder) write(rec Record) {
+	if err := r.Write(rec); err != nil {
+		errorLog.Error("error recording", "exchange", rec.Exchange, "symbol", rec.Symbol, "err", err)
+	}
+}
+
+// Path returns the first segment of an exchange and symbol for a day
+func Path(dir string, exchange orderbook.ExchangeKey, symbol orderbook.Symbol, day time.Time) string {
+	return filepath.Join(dir, string(exchange), strings.Replace(string(symbol), "/", "-", -1),
+		day.UTC().Format("2006-01-02")+ext)
+}
+
+// ext is the extension of the files
+const ext = ".jsonl.gz"
+
+// segmentPath returns the n-th segment of the day of path, path itself
+// for the first one
+func segmentPath(path string, n int) string {
+	if n == 0 {
+		return path
+	}
+	return strings.TrimSuffix(path, ext) + "-" + strconv.Itoa(n) + ext
+}
+
+// Write appends a record to its file, it is on disk once flushed
+func (r *Recorder) Write(rec Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, err := r.file(rec)
+	if err != nil {
+		return err
+	}
+	f.dirty = true
+	_, err = f.gz.Write(append(line, '\n'))
+	return err
+}
+
+// Flush flushes the records written to disk
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var first error
+	for _, f := range r.files {
+		if !f.dirty {
+			continue
+		}
+		if err := f.gz.Flush(); err != nil && first == nil {
+			first = err
+		}
+		f.dirty = false
+	}
+	return first
+}
+
+// file returns the open file of a record, closing the file
+// of the previous day of its exchange and symbol. The file is
+// the first segment of the day not created yet.
+func (r *Recorder) file(rec Record) (*file, error) {
+	key := string(rec.Exchange) + " " + string(rec.Symbol)
+	day := Path(r.Dir, rec.Exchange, rec.Symbol, rec.Received)
+	if f, ok := r.files[key]; ok {
+		if f.day == day {
+			return f, nil
+		}
+		delete(r.files, key)
+		if err := f.close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(day), 0755); err != nil {
+		return nil, err
+	}
+	for n := 0; ; n++ {
+		path := segmentPath(day, n)
+		osFile, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		f := &file{day: day, path: path, f: osFile, gz: gzip.NewWriter(osFile)}
+		r.files[key] = f
+		return f, nil
+	}
+}
+
+func (f *file) close() error {
+	if err := f.gz.Close(); err != nil {
+		f.f.Close()
+		return err
+	}
+	return f.f.Close()
+}
+
+// Close writes the records queued, then flushes and closes every open file
+func (r *Recorder) Close() error {
+	close(r.quit)
+	<-r.done
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var first error
+	for key, f := range r.files {
+		if err := f.close(); err != nil && first == nil {
+			first = err
+		}
+		delete(r.files, key)
+	}
+	return first
+}
+
+var (
+	defaultRecorder *Recorder
+	defaultMu       sync.RWMutex
+)
+
+// SetDefault makes the adapters record the data they receive to r,
+// nil stops the recording
+func SetDefault(r *Recorder) {
+	defaultMu.Lock()
+	defaultRecorder = r
+	defaultMu.Unlock()
+}
+
+// Enabled tells whether a default recorder is set
+func Enabled() bool {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultRecorder != nil
+}
+
+// Save queues v, received now, for the default recorder if any. It is
+// meant to be called by the adapters as soon as data arrives, the record
+// is written by the recorder's goroutine and dropped when its queue is full.
+func Save(exchange orderbook.ExchangeKey, symbol orderbook.Symbol, kind Kind, v interface{}) {
+	defaultMu.RLock()
+	r := defaultRecorder
+	defaultMu.RUnlock()
+	if r == nil {
+		return
+	}
+	received := time.Now()
+	data, err := json.Marshal(v)
+	if err != nil {
+		errorLog.Error("error recording", "exchange", exchange, "symbol", symbol, "err", err)
+		return
+	}
+	select {
+	case r.queue <- Record{Received: received, Exchange: exchange, Symbol: symbol, Kind: kind, Data: data}:
+	default:
+		errorLog.Error("recorder queue full, dropping the record", "exchange", exchange, "symbol", symbol)
+	}
+}
@@ -0,0 +1,220 @@
+package recorder
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/stretchr/testify/assert"
+)
+
+func record(received time.Time, symbol orderbook.Symbol, data string) Record {
+	return Record{
+		Received: received,
+		Exchange: orderbook.Binance,
+		Symbol:   symbol,
+		Kind:     Diff,
+		Data:     json.RawMessage(data),
+	}
+}
+
+func readAll(t *testing.T, paths ...string) (records []Record) {
+	r := Open(paths...)
+	defer r.Close()
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return records
+		}
+		assert.Nil(t, err)
+		records = append(records, rec)
+	}
+}
+
+func TestRecordAndRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	day := time.Date(2020, 1, 6, 23, 59, 59, 0, time.UTC)
+	r := New(dir)
+	assert.Nil(t, r.Write(record(day, orderbook.BTC_USDC, `{"u":1}`)))
+	assert.Nil(t, r.Write(record(day, orderbook.ETH_USDT, `{"u":1}`)))
+	// the next day rotates the file
+	assert.Nil(t, r.Write(record(day.Add(time.Second), orderbook.BTC_USDC, `{"u":2}`)))
+	assert.Nil(t, r.Close())
+
+	// a day already recorded gets a new segment
+	r = New(dir)
+	assert.Nil(t, r.Write(record(day.Add(2*time.Second), orderbook.BTC_USDC, `{"u":3}`)))
+	assert.Nil(t, r.Close())
+
+	paths, err := Files(dir, orderbook.Binance, orderbook.BTC_USDC)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "Binance", "BTC-USDC", "2020-01-06.jsonl.gz"),
+		filepath.Join(dir, "Binance", "BTC-USDC", "2020-01-07.jsonl.gz"),
+		filepath.Join(dir, "Binance", "BTC-USDC", "2020-01-07-1.jsonl.gz"),
+	}, paths)
+
+	records := readAll(t, paths...)
+	assert.Len(t, records, 3)
+	for i, rec := range records {
+		assert.Equal(t, orderbook.BTC_USDC, rec.Symbol)
+		assert.Equal(t, Diff, rec.Kind)
+		assert.True(t, day.Add(time.Duration(i)*time.Second).Equal(rec.Received))
+		assert.JSONEq(t, `{"u":`+strconv.Itoa(i+1)+`}`, string(rec.Data))
+	}
+}
+
+func TestReadUnclosedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// records are readable once flushed, before the recorder is closed
+	r := New(dir)
+	now := time.Now()
+	assert.Nil(t, r.Write(record(now, orderbook.BTC_USDC, `{"u":1}`)))
+	assert.Nil(t, r.Write(record(now, orderbook.BTC_USDC, `{"u":2}`)))
+	assert.Nil(t, r.Flush())
+	assert.Len(t, readAll(t, Path(dir, orderbook.Binance, orderbook.BTC_USDC, now)), 2)
+
+	// every FlushInterval
+	interval := FlushInterval
+	FlushInterval = 10 * time.Millisecond
+	defer func() { FlushInterval = interval }()
+	flushed := New(dir)
+	assert.Nil(t, flushed.Write(record(now, orderbook.ETH_USDT, `{"u":1}`)))
+	assert.Eventually(t, func() bool {
+		reader := Open(Path(dir, orderbook.Binance, orderbook.ETH_USDT, now))
+		defer reader.Close()
+		_, err := reader.Next()
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Nil(t, r.Close())
+	assert.Nil(t, flushed.Close())
+}
+
+func TestRestartAfterCrash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2020, 1, 6, 10, 0, 0, 0, time.UTC)
+	path := Path(dir, orderbook.Binance, orderbook.BTC_USDC, now)
+	r := New(dir)
+	assert.Nil(t, r.Write(record(now, orderbook.BTC_USDC, `{"u":1}`)))
+	assert.Nil(t, r.Flush())
+	flushed, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Nil(t, r.Write(record(now, orderbook.BTC_USDC, `{"u":2}`)))
+	assert.Nil(t, r.Flush())
+	crashed, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Nil(t, r.Close())
+	// the process died while the second record was written
+	assert.Nil(t, ioutil.WriteFile(path, crashed[:len(flushed)+(len(crashed)-len(flushed))/2], 0644))
+
+	// the recorder of the restarted process writes the next segment
+	r = New(dir)
+	assert.Nil(t, r.Write(record(now.Add(time.Second), orderbook.BTC_USDC, `{"u":3}`)))
+	assert.Nil(t, r.Write(record(now.Add(2*time.Second), orderbook.BTC_USDC, `{"u":4}`)))
+	assert.Nil(t, r.Close())
+
+	paths, err := Files(dir, orderbook.Binance, orderbook.BTC_USDC)
+	assert.Nil(t, err)
+	assert.Len(t, paths, 2)
+	m, err := OpenDir(dir)
+	assert.Nil(t, err)
+	defer m.Close()
+	var updates []string
+	for {
+		rec, err := m.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.Nil(t, err) {
+			break
+		}
+		updates = append(updates, string(rec.Data))
+	}
+	assert.Equal(t, []string{`{"u":1}`, `{"u":3}`, `{"u":4}`}, updates)
+}
+
+func TestFilesOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	symbolDir := filepath.Join(dir, "Binance", "BTC-USDC")
+	assert.Nil(t, os.MkdirAll(symbolDir, 0755))
+	names := []string{"2020-01-06.jsonl.gz", "2020-01-06-2.jsonl.gz", "2020-01-06-10.jsonl.gz", "2020-01-07.jsonl.gz"}
+	for _, name := range names {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(symbolDir, name), nil, 0644))
+	}
+	paths, err := Files(dir, orderbook.Binance, orderbook.BTC_USDC)
+	assert.Nil(t, err)
+	for i, name := range names {
+		assert.Equal(t, filepath.Join(symbolDir, name), paths[i])
+	}
+}
+
+func TestSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	Save(orderbook.Indodax, orderbook.ETH_IDR, Snapshot, map[string]string{"ignored": "yes"})
+	r := New(dir)
+	SetDefault(r)
+	assert.True(t, Enabled())
+	Save(orderbook.Indodax, orderbook.ETH_IDR, Snapshot, map[string][][]string{"buy": {{"3000000", "1"}}})
+	SetDefault(nil)
+	assert.Nil(t, r.Close())
+
+	paths, _ := Files(dir, orderbook.Indodax, orderbook.ETH_IDR)
+	records := readAll(t, paths...)
+	assert.Len(t, records, 1)
+	assert.Equal(t, Snapshot, records[0].Kind)
+	assert.JSONEq(t, `{"buy":[["3000000","1"]]}`, string(records[0].Data))
+}
+
+func TestOpenDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2020, 1, 6, 0, 0, 0, 0, time.UTC)
+	r := New(dir)
+	assert.Nil(t, r.Write(record(start.Add(2*time.Second), orderbook.BTC_USDC, `{"u":3}`)))
+	assert.Nil(t, r.Write(record(start, orderbook.ETH_USDT, `{"u":1}`)))
+	assert.Nil(t, r.Write(record(start.Add(time.Second), orderbook.BTC_USDC, `{"u":2}`)))
+	assert.Nil(t, r.Close())
+
+	streams, err := Streams(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, []Stream{{orderbook.Binance, orderbook.BTC_USDC}, {orderbook.Binance, orderbook.ETH_USDT}}, streams)
+
+	// each file is in write order, the merge is in time order
+	m, err := OpenDir(dir)
+	assert.Nil(t, err)
+	defer m.Close()
+	var symbols []orderbook.Symbol
+	for {
+		rec, err := m.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		symbols = append(symbols, rec.Symbol)
+	}
+	assert.Equal(t, []orderbook.Symbol{orderbook.ETH_USDT, orderbook.BTC_USDC, orderbook.BTC_USDC}, symbols)
+}
@@ -0,0 +1,110 @@
+// Package rest types the errors of the exchanges' REST APIs, so callers
+// tell a throttled request from an exchange in maintenance, a symbol it
+// doesn't list or a response that can't be parsed, and retries the
+// requests failing for a transient reason.
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+)
+
+// RateLimited is returned when the exchange throttles the requests
+type RateLimited struct {
+	Exchange   orderbook.ExchangeKey
+	Endpoint   string
+	RetryAfter time.Duration // Zero when the exchange didn't say
+}
+
+func (e *RateLimited) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%v %v: rate limited, retry after %v", e.Exchange, e.Endpoint, e.RetryAfter)
+	}
+	return fmt.Sprintf("%v %v: rate limited", e.Exchange, e.Endpoint)
+}
+
+// ExchangeUnavailable is returned when the exchange can't be reached,
+// answers with a server error or is in maintenance
+type ExchangeUnavailable struct {
+	Exchange orderbook.ExchangeKey
+	Endpoint string
+	Status   int   // Zero when no response was received
+	Err      error // Error of the request when no response was received
+}
+
+func (e *ExchangeUnavailable) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%v %v: unavailable: %v", e.Exchange, e.Endpoint, e.Err)
+	}
+	return fmt.Sprintf("%v %v: unavailable (status %d)", e.Exchange, e.Endpoint, e.Status)
+}
+
+func (e *ExchangeUnavailable) Unwrap() error {
+	return e.Err
+}
+
+// InvalidSymbol is returned for a symbol the exchange doesn't list
+type InvalidSymbol struct {
+	Exchange orderbook.ExchangeKey
+	Symbol   string // Name of the symbol on the exchange, e.g. BTCUSDC
+}
+
+func (e *InvalidSymbol) Error() string {
+	return fmt.Sprintf("%v: invalid symbol %v", e.Exchange, e.Symbol)
+}
+
+// DecodeError is returned when a response can't be parsed
+type DecodeError struct {
+	Exchange orderbook.ExchangeKey
+	Endpoint string
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%v %v: decoding the response: %v", e.Exchange, e.Endpoint, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// StatusError returns the error of a response by its status: RateLimited
+// for 429 and 418, the status binance bans the IPs ignoring 429 with,
+// ExchangeUnavailable for 5xx, nil for the other statuses
+func StatusError(exchange orderbook.ExchangeKey, endpoint string, res *http.Response) error {
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusTeapot:
+		return &RateLimited{Exchange: exchange, Endpoint: endpoint, RetryAfter: retryAfter(res.Header.Get("Retry-After"))}
+	case res.StatusCode >= 500:
+		return &ExchangeUnavailable{Exchange: exchange, Endpoint: endpoint, Status: res.StatusCode}
+	}
+	return nil
+}
+
+// retryAfter parses a Retry-After header, in seconds or an HTTP date
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// Temporary tells whether a request failing with err may succeed later
+func Temporary(err error) bool {
+	var limited *RateLimited
+	var unavailable *ExchangeUnavailable
+	return errors.As(err, &limited) || errors.As(err, &unavailable)
+}
@@ -0,0 +1,80 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/logging"
+)
+
+var log = logging.New("rest")
+
+// Policy is how the requests failing for a transient reason are retried:
+// RateLimited ones once the exchange said to, ExchangeUnavailable ones
+// with an exponential backoff. The other errors are returned as is.
+type Policy struct {
+	Attempts   int           // Requests sent at most, 1 never retries
+	Backoff    time.Duration // Delay before the first retry, doubled at every retry
+	MaxBackoff time.Duration // Longest delay, rate limits asking to wait longer are not retried
+}
+
+// DefaultPolicy is the policy of the exchanges' clients
+var DefaultPolicy = Policy{
+	Attempts:   3,
+	Backoff:    500 * time.Millisecond,
+	MaxBackoff: 10 * time.Second,
+}
+
+// Delay returns how long to wait before sending again a request that
+// failed with err for the attempt-th time in a row, false when the
+// request is not worth sending again
+func (p Policy) Delay(err error, attempt int) (time.Duration, bool) {
+	var limited *RateLimited
+	if errors.As(err, &limited) {
+		if limited.RetryAfter > 0 {
+			return limited.RetryAfter, true
+		}
+		return p.backoff(attempt), true
+	}
+	var unavailable *ExchangeUnavailable
+	if errors.As(err, &unavailable) {
+		return p.backoff(attempt), true
+	}
+	return 0, false
+}
+
+func (p Policy) backoff(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// Do calls f until it succeeds or fails with an error not worth
+// retrying, at most Attempts times. The last error is returned when
+// ctx is done before the next attempt.
+func (p Policy) Do(ctx context.Context, f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= p.Attempts {
+			return err
+		}
+		delay, ok := p.Delay(err, attempt)
+		if !ok || delay > p.MaxBackoff {
+			return err
+		}
+		log.Debug("retrying", "attempt", attempt, "delay", delay, "err", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
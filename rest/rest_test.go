@@ -0,0 +1,95 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/stretchr/testify/assert"
+)
+
+func response(status int, retryAfter string) *http.Response {
+	header := http.Header{}
+	if retryAfter != "" {
+		header.Set("Retry-After", retryAfter)
+	}
+	return &http.Response{StatusCode: status, Header: header}
+}
+
+func TestStatusError(t *testing.T) {
+	assert.Equal(t, &RateLimited{Exchange: orderbook.Binance, Endpoint: "depth", RetryAfter: 30 * time.Second},
+		StatusError(orderbook.Binance, "depth", response(http.StatusTooManyRequests, "30")))
+	assert.Equal(t, &RateLimited{Exchange: orderbook.Binance, Endpoint: "depth"},
+		StatusError(orderbook.Binance, "depth", response(http.StatusTeapot, "")))
+	assert.Equal(t, &ExchangeUnavailable{Exchange: orderbook.Indodax, Endpoint: "depth", Status: 503},
+		StatusError(orderbook.Indodax, "depth", response(http.StatusServiceUnavailable, "")))
+	assert.Nil(t, StatusError(orderbook.Indodax, "depth", response(http.StatusBadRequest, "")))
+
+	limited := StatusError(orderbook.Binance, "depth", response(http.StatusTooManyRequests, time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)))
+	assert.InDelta(t, time.Minute, limited.(*RateLimited).RetryAfter, float64(2*time.Second))
+}
+
+func TestDelay(t *testing.T) {
+	p := Policy{Attempts: 5, Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	unavailable := &ExchangeUnavailable{Exchange: orderbook.Indodax, Endpoint: "depth", Err: errors.New("timeout")}
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		delay, ok := p.Delay(unavailable, attempt+1)
+		assert.True(t, ok)
+		assert.Equal(t, expected, delay)
+	}
+
+	delay, ok := p.Delay(&RateLimited{RetryAfter: time.Minute}, 1)
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, delay)
+
+	_, ok = p.Delay(&InvalidSymbol{Exchange: orderbook.Binance, Symbol: "BTCXYZ"}, 1)
+	assert.False(t, ok)
+	_, ok = p.Delay(&DecodeError{Err: errors.New("unexpected EOF")}, 1)
+	assert.False(t, ok)
+	assert.True(t, Temporary(unavailable))
+	assert.False(t, Temporary(errors.New("other")))
+}
+
+func TestDo(t *testing.T) {
+	p := Policy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	failing := func(errs ...error) (func() error, *int) {
+		calls := 0
+		return func() error {
+			calls++
+			if calls <= len(errs) {
+				return errs[calls-1]
+			}
+			return nil
+		}, &calls
+	}
+	unavailable := &ExchangeUnavailable{Status: 502}
+
+	f, calls := failing(unavailable, &RateLimited{})
+	assert.Nil(t, p.Do(context.Background(), f))
+	assert.Equal(t, 3, *calls)
+
+	f, calls = failing(unavailable, unavailable, unavailable)
+	assert.Equal(t, unavailable, p.Do(context.Background(), f))
+	assert.Equal(t, 3, *calls)
+
+	// not worth retrying
+	invalid := &InvalidSymbol{Symbol: "BTCXYZ"}
+	f, calls = failing(invalid)
+	assert.Equal(t, invalid, p.Do(context.Background(), f))
+	assert.Equal(t, 1, *calls)
+
+	// waiting longer than MaxBackoff
+	limited := &RateLimited{RetryAfter: time.Minute}
+	f, calls = failing(limited)
+	assert.Equal(t, limited, p.Do(context.Background(), f))
+	assert.Equal(t, 1, *calls)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f, calls = failing(unavailable)
+	assert.Equal(t, unavailable, p.Do(ctx, f))
+	assert.Equal(t, 1, *calls)
+}
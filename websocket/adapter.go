@@ -0,0 +1,269 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	binance "github.com/adshao/go-binance"
+	"github.com/anthonychristian/crypto-arbitrage/metrics"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/orders"
+	"github.com/anthonychristian/crypto-arbitrage/rest"
+	"github.com/shopspring/decimal"
+)
+
+// BinanceAdapter plugs binance's depth stream into orderbook.Exchanges.
+// It is also the orders.Executor of binance, trading through
+// BinanceRESTInstance, see executor.go.
+type BinanceAdapter struct {
+	updates    chan orderbook.BookUpdate
+	connEvents chan ConnEvent
+	books      map[orderbook.Symbol]*symbolBook
+	conns      []*connection
+	runs       sync.WaitGroup // Books applying the events of a stream
+	client     *BinanceClient
+	executions chan orders.Execution
+	live       map[string]*liveOrder // By client_order_id
+	quit       chan struct{}
+	tracking   sync.WaitGroup
+	mu         sync.RWMutex
+}
+
+func init() {
+	orderbook.RegisterAdapter(NewBinanceAdapter())
+}
+
+// NewBinanceAdapter creates a new binance adapter
+func NewBinanceAdapter() *BinanceAdapter {
+	b := &BinanceAdapter{
+		updates:    make(chan orderbook.BookUpdate, 256),
+		connEvents: make(chan ConnEvent, 64),
+		books:      make(map[orderbook.Symbol]*symbolBook),
+		client:     BinanceRESTInstance,
+	}
+	metrics.SetQueue("binance updates", func() int { return len(b.updates) })
+	return b
+}
+
+func (b *BinanceAdapter) Key() orderbook.ExchangeKey {
+	return orderbook.Binance
+}
+
+// Names of the endpoints of the configuration
+const (
+	DepthEndpoint = "depth" // Depth snapshots, BinanceDepthURL
+	RESTEndpoint  = "rest"  // Base URL of the signed REST API, BinanceRESTURL
+)
+
+// Defaults returns the symbols and the endpoints binance runs with by default
+func (b *BinanceAdapter) Defaults() orderbook.AdapterConfig {
+	return orderbook.AdapterConfig{
+		Symbols: []orderbook.Symbol{orderbook.BTC_USDC, orderbook.ETH_USDT},
+		URLs: map[string]string{
+			DepthEndpoint: BinanceDepthURL,
+			RESTEndpoint:  BinanceRESTURL,
+		},
+	}
+}
+
+// Configure sets the endpoints and the credentials of the REST client
+func (b *BinanceAdapter) Configure(cfg orderbook.AdapterConfig) error {
+	if u, ok := cfg.URLs[DepthEndpoint]; ok {
+		BinanceDepthURL = u
+	}
+	if u, ok := cfg.URLs[RESTEndpoint]; ok {
+		BinanceRESTURL = u
+		b.client.BaseURL = u
+	}
+	b.client.SetCredentials(cfg.APIKey, cfg.APISecret)
+	return nil
+}
+
+// HasCredentials tells whether the REST client signs its requests
+func (b *BinanceAdapter) HasCredentials() bool {
+	return b.client.key != "" && b.client.secret != ""
+}
+
+// Connect starts polling the executions of the orders,
+// the websocket is opened once a symbol is subscribed
+func (b *BinanceAdapter) Connect() error {
+	b.quit = make(chan struct{})
+	b.executions = make(chan orders.Execution, 256)
+	b.live = make(map[string]*liveOrder)
+	b.tracking.Add(1)
+	go b.track()
+	return nil
+}
+
+// Subscribe starts a combined depth stream for the given symbols,
+// keeping the book of each symbol in sync independently.
+// The stream is reopened whenever it fails or goes stale.
+func (b *BinanceAdapter) Subscribe(symbols ...orderbook.Symbol) error {
+	if len(symbols) == 0 {
+		return nil
+	}
+	books := make(map[string]*symbolBook)
+	for _, symbol := range symbols {
+		book := orderbook.Exchanges[orderbook.Binance].Books[symbol]
+		if book == nil {
+			return fmt.Errorf("no book for %v", symbol)
+		}
+		sb := newSymbolBook(symbol, book)
+		sb.publish = b.publish
+		books[streamName(symbol)] = sb
+		metrics.SetQueue("binance events "+string(symbol), func() int { return len(sb.events) })
+	}
+	for _, sb := range books {
+		b.runs.Add(1)
+		go func(sb *symbolBook) {
+			defer b.runs.Done()
+			sb.run()
+		}(sb)
+	}
+	conn := newConnection(books, b.connEvents)
+	go conn.run()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, sb := range books {
+		b.books[sb.symbol] = sb
+	}
+	b.conns = append(b.conns, conn)
+	return nil
+}
+
+// ConnEvents streams the state transitions of the depth streams.
+// Events are dropped when nobody keeps up with the channel.
+func (b *BinanceAdapter) ConnEvents() <-chan ConnEvent {
+	return b.connEvents
+}
+
+// SyncState returns whether the book of a symbol follows the depth stream
+func (b *BinanceAdapter) SyncState(symbol orderbook.Symbol) SyncState {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if sb, ok := b.books[symbol]; ok {
+		return sb.State()
+	}
+	return OutOfSync
+}
+
+// Resyncs returns how many times the book of a symbol was rebuilt
+// from a snapshot after a gap in the depth stream
+func (b *BinanceAdapter) Resyncs(symbol orderbook.Symbol) int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if sb, ok := b.books[symbol]; ok {
+		return sb.Resyncs()
+	}
+	return 0
+}
+
+func (b *BinanceAdapter) Updates() <-chan orderbook.BookUpdate {
+	return b.updates
+}
+
+// Snapshot fetches the REST depth snapshot of a symbol,
+// retrying with rest.DefaultPolicy
+func (b *BinanceAdapter) Snapshot(symbol orderbook.Symbol) (orderbook.BookUpdate, error) {
+	var depth binance.DepthResponse
+	err := rest.DefaultPolicy.Do(context.Background(), func() (err error) {
+		depth, err = getBinanceDepth(streamName(symbol))
+		return err
+	})
+	if err != nil {
+		return orderbook.BookUpdate{}, err
+	}
+	bids, asks, err := levelsToOrders(symbol, depth.Bids, depth.Asks)
+	if err != nil {
+		return orderbook.BookUpdate{}, &rest.DecodeError{Exchange: orderbook.Binance, Endpoint: "depth", Err: err}
+	}
+	return orderbook.BookUpdate{
+		Exchange: orderbook.Binance,
+		Symbol:   symbol,
+		Bids:     bids,
+		Asks:     asks,
+		Snapshot: true,
+	}, nil
+}
+
+// Fee returns the taker rate of a symbol
+func (b *BinanceAdapter) Fee(symbol orderbook.Symbol) decimal.Decimal {
+	return orderbook.Fees.Taker(orderbook.Binance, symbol)
+}
+
+// Close stops polling the executions and the depth streams,
+// and waits for the events they delivered to be applied to the books
+func (b *BinanceAdapter) Close() error {
+	if b.quit != nil {
+		close(b.quit)
+		b.tracking.Wait()
+		close(b.executions)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, conn := range b.conns {
+		conn.stop()
+	}
+	b.conns = nil
+	// the streams are closed, nothing sends to the books anymore
+	for _, sb := range b.books {
+		close(sb.events)
+	}
+	b.runs.Wait()
+	b.books = make(map[orderbook.Symbol]*symbolBook)
+	return nil
+}
+
+// publish hands the applied change to the adapter's consumers,
+// dropping it when nobody keeps up with the channel
+func (b *BinanceAdapter) publish(update orderbook.BookUpdate) {
+	select {
+	case b.updates <- update:
+	default:
+	}
+}
+
+// streamName converts a symbol to binance's symbol name, e.g. BTC/USDC -> BTCUSDC
+func streamName(symbol orderbook.Symbol) string {
+	return strings.ToUpper(strings.Replace(string(symbol), "/", "", 1))
+}
+
+// bidsToOrders parses the bids of a symbol, failing on the first malformed one
+func bidsToOrders(symbol orderbook.Symbol, bids []binance.Bid) ([]orderbook.Order, error) {
+	orders := make([]orderbook.Order, 0, len(bids))
+	for _, elem := range bids {
+		o, err := toOrder(symbol, elem.Price, elem.Quantity)
+		if err != nil {
+			return nil, fmt.Errorf("bids: %v", err)
+		}
+		orders = append(orders, o)
+	}
+	return orders, nil
+}
+
+// asksToOrders parses the asks of a symbol, failing on the first malformed one
+func asksToOrders(symbol orderbook.Symbol, asks []binance.Ask) ([]orderbook.Order, error) {
+	orders := make([]orderbook.Order, 0, len(asks))
+	for _, elem := range asks {
+		o, err := toOrder(symbol, elem.Price, elem.Quantity)
+		if err != nil {
+			return nil, fmt.Errorf("asks: %v", err)
+		}
+		orders = append(orders, o)
+	}
+	return orders, nil
+}
+
+// levelsToOrders parses both sides of a snapshot or an event
+func levelsToOrders(symbol orderbook.Symbol, bids []binance.Bid, asks []binance.Ask) (b, a []orderbook.Order, err error) {
+	if b, err = bidsToOrders(symbol, bids); err != nil {
+		return nil, nil, err
+	}
+	if a, err = asksToOrders(symbol, asks); err != nil {
+		return nil, nil, err
+	}
+	return b, a, nil
+}
@@ -0,0 +1,214 @@
+package websocket
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	binance "github.com/adshao/go-binance"
+)
+
+// ConnState is the state of a binance websocket connection
+type ConnState int
+
+const (
+	// Connecting to binance, for the first time or after a disconnection
+	Connecting ConnState = iota
+	// Connected and receiving depth events
+	Connected
+	// Stale connections are still open but did not receive
+	// any event within StaleTimeout, they are dropped and reopened
+	Stale
+	// Disconnected after an error, waiting for the backoff to reconnect
+	Disconnected
+	// Closed by the adapter, it won't reconnect
+	Closed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	case Stale:
+		return "stale"
+	case Disconnected:
+		return "disconnected"
+	default:
+		return "closed"
+	}
+}
+
+// ConnEvent is emitted on every state transition of a connection
+type ConnEvent struct {
+	State   ConnState
+	Attempt int   // Number of failed attempts since the last connection
+	Err     error // Why the connection was lost, if known
+	Time    time.Time
+}
+
+var (
+	// MinBackoff is the delay before the first reconnection attempt
+	MinBackoff = time.Second
+	// MaxBackoff caps the delay between two reconnection attempts
+	MaxBackoff = 2 * time.Minute
+	// StaleTimeout is how long a connection may go without any depth event
+	StaleTimeout = 30 * time.Second
+)
+
+// wsCombinedDepthServe opens the combined depth stream, replaced in tests
+var wsCombinedDepthServe = binance.WsCombinedDepthServe
+
+// connection supervises a combined depth stream, reopening it with
+// exponential backoff whenever it fails or goes stale, and resyncing
+// its books after every reconnection
+type connection struct {
+	books  map[string]*symbolBook
+	events chan<- ConnEvent
+
+	lastEvent int64 // Unix nano time of the last depth event
+	lastErr   error
+	errMu     sync.Mutex
+	quit      chan struct{}
+	done      chan struct{}
+}
+
+func newConnection(books map[string]*symbolBook, events chan<- ConnEvent) *connection {
+	return &connection{
+		books:  books,
+		events: events,
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// run keeps the stream open until stop is called
+func (c *connection) run() {
+	defer close(c.done)
+	names := make([]string, 0, len(c.books))
+	for name := range c.books {
+		names = append(names, name)
+	}
+	handler := depthHandler(c.books)
+	onEvent := func(event *binance.WsDepthEvent) {
+		atomic.StoreInt64(&c.lastEvent, time.Now().UnixNano())
+		handler(event)
+	}
+	onError := func(err error) {
+		depthErrHandler(err)
+		c.errMu.Lock()
+		c.lastErr = err
+		c.errMu.Unlock()
+	}
+
+	attempt := 0
+	for {
+		c.emit(Connecting, attempt, nil)
+		doneC, stopC, err := wsCombinedDepthServe(names, onEvent, onError)
+		if err != nil {
+			attempt++
+			c.emit(Disconnected, attempt, err)
+			if !c.wait(backoff(attempt)) {
+				c.emit(Closed, attempt, nil)
+				return
+			}
+			continue
+		}
+
+		attempt = 0
+		atomic.StoreInt64(&c.lastEvent, time.Now().UnixNano())
+		// events may have been missed while disconnected
+		for _, sb := range c.books {
+			sb.reset()
+		}
+		c.emit(Connected, attempt, nil)
+
+		if !c.watch(doneC, stopC) {
+			c.emit(Closed, attempt, nil)
+			return
+		}
+		attempt++
+		if !c.wait(backoff(attempt)) {
+			c.emit(Closed, attempt, nil)
+			return
+		}
+	}
+}
+
+// watch waits for an open stream to end or go stale,
+// returning false when the connection is being stopped
+func (c *connection) watch(doneC, stopC chan struct{}) bool {
+	ticker := time.NewTicker(StaleTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-doneC:
+			c.errMu.Lock()
+			err := c.lastErr
+			c.lastErr = nil
+			c.errMu.Unlock()
+			c.emit(Disconnected, 0, err)
+			return true
+		case <-ticker.C:
+			last := time.Unix(0, atomic.LoadInt64(&c.lastEvent))
+			if time.Since(last) > StaleTimeout {
+				log.Warn("Binance depth stream is stale, reconnecting", "since", last)
+				c.emit(Stale, 0, nil)
+				close(stopC)
+				<-doneC
+				return true
+			}
+		case <-c.quit:
+			close(stopC)
+			<-doneC
+			return false
+		}
+	}
+}
+
+// wait sleeps for d, returning false when the connection is being stopped
+func (c *connection) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.quit:
+		return false
+	}
+}
+
+// stop closes the stream and waits for run to return
+func (c *connection) stop() {
+	close(c.quit)
+	<-c.done
+}
+
+func (c *connection) emit(state ConnState, attempt int, err error) {
+	if state == Disconnected {
+		log.Warn("Binance depth stream disconnected", "attempt", attempt, "err", err)
+	}
+	select {
+	case c.events <- ConnEvent{State: state, Attempt: attempt, Err: err, Time: time.Now()}:
+	default:
+	}
+}
+
+// backoff returns the delay before a reconnection attempt, doubling
+// from MinBackoff up to MaxBackoff, with jitter over its upper half
+func backoff(attempt int) time.Duration {
+	d := MinBackoff
+	for i := 1; i < attempt && d < MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > MaxBackoff {
+		d = MaxBackoff
+	}
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
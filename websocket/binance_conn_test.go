@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	binance "github.com/adshao/go-binance"
+	"github.com/anthonychristian/crypto-arbitrage/lifecycle"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	minBackoff, maxBackoff := MinBackoff, MaxBackoff
+	MinBackoff, MaxBackoff = time.Second, 10*time.Second
+	defer func() { MinBackoff, MaxBackoff = minBackoff, maxBackoff }()
+
+	for i := 0; i < 20; i++ {
+		d := backoff(1)
+		assert.True(t, d >= 500*time.Millisecond && d <= time.Second, "backoff(1) = %v", d)
+		d = backoff(3)
+		assert.True(t, d >= 2*time.Second && d <= 4*time.Second, "backoff(3) = %v", d)
+		d = backoff(10)
+		assert.True(t, d >= 5*time.Second && d <= 10*time.Second, "backoff(10) = %v", d)
+	}
+}
+
+func TestConnectionReconnects(t *testing.T) {
+	minBackoff, maxBackoff, staleTimeout, serve := MinBackoff, MaxBackoff, StaleTimeout, wsCombinedDepthServe
+	MinBackoff, MaxBackoff, StaleTimeout = time.Millisecond, 5*time.Millisecond, 40*time.Millisecond
+	defer func() {
+		MinBackoff, MaxBackoff, StaleTimeout, wsCombinedDepthServe = minBackoff, maxBackoff, staleTimeout, serve
+	}()
+
+	calls := 0
+	wsCombinedDepthServe = func(names []string, handler binance.WsDepthHandler, errHandler binance.ErrHandler) (doneC, stopC chan struct{}, err error) {
+		calls++
+		assert.Equal(t, []string{"BTCUSDC"}, names)
+		switch calls {
+		case 1:
+			return nil, nil, errors.New("dial failed")
+		}
+		doneC, stopC = make(chan struct{}), make(chan struct{})
+		go func(call int) {
+			if call == 2 {
+				// deliver an event then drop the connection
+				handler(&binance.WsDepthEvent{Symbol: "BTCUSDC", FirstUpdateID: 1, UpdateID: 2})
+				errHandler(errors.New("connection reset"))
+				close(doneC)
+				return
+			}
+			// later connections stay silent until stopped
+			<-stopC
+			close(doneC)
+		}(calls)
+		return doneC, stopC, nil
+	}
+
+	sb := newSymbolBook(orderbook.BTC_USDC, orderbook.NewOrderBook())
+	sb.setState(Synced)
+	events := make(chan ConnEvent, 64)
+	conn := newConnection(map[string]*symbolBook{"BTCUSDC": sb}, events)
+	go conn.run()
+
+	var states []ConnState
+	timeout := time.After(2 * time.Second)
+	for len(states) < 9 {
+		select {
+		case e := <-events:
+			states = append(states, e.State)
+			if e.State == Connected {
+				assert.Equal(t, OutOfSync, sb.State())
+			}
+		case <-timeout:
+			t.Fatalf("timed out, got %v", states)
+		}
+	}
+	conn.stop()
+
+	assert.Equal(t, []ConnState{
+		Connecting, Disconnected, // dial failed
+		Connecting, Connected, Disconnected, // connection reset
+		Connecting, Connected, Stale, // no event
+		Connecting,
+	}, states)
+	assert.Len(t, sb.events, 1)
+}
+
+func TestAdapterClose(t *testing.T) {
+	serve, exchanges := wsCombinedDepthServe, orderbook.Exchanges
+	defer func() { wsCombinedDepthServe, orderbook.Exchanges = serve, exchanges }()
+	orderbook.Exchanges = orderbook.ExchangeMap{orderbook.Binance: {
+		Books: orderbook.OrderBookMap{orderbook.BTC_USDC: orderbook.NewOrderBook()},
+	}}
+	wsCombinedDepthServe = func(names []string, handler binance.WsDepthHandler, errHandler binance.ErrHandler) (doneC, stopC chan struct{}, err error) {
+		doneC, stopC = make(chan struct{}), make(chan struct{})
+		go func() {
+			<-stopC
+			close(doneC)
+		}()
+		return doneC, stopC, nil
+	}
+
+	goroutines := runtime.NumGoroutine()
+	b := NewBinanceAdapter()
+	assert.Nil(t, b.Subscribe(orderbook.BTC_USDC))
+	assert.Equal(t, Connecting, (<-b.ConnEvents()).State)
+	assert.Equal(t, Connected, (<-b.ConnEvents()).State)
+
+	assert.Nil(t, b.Close())
+	assert.Equal(t, Closed, (<-b.ConnEvents()).State)
+	assert.Equal(t, OutOfSync, b.SyncState(orderbook.BTC_USDC))
+	assert.Zero(t, lifecycle.Leaked(goroutines, time.Second))
+}
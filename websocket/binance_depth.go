@@ -2,20 +2,26 @@ package websocket
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strconv"
-	"time"
 
 	binance "github.com/adshao/go-binance"
-	"github.com/alpacahq/gopaca/log"
+	"github.com/anthonychristian/crypto-arbitrage/logging"
+	"github.com/anthonychristian/crypto-arbitrage/metrics"
 	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/recorder"
+	"github.com/anthonychristian/crypto-arbitrage/rest"
+	"github.com/shopspring/decimal"
 )
 
-const (
-	// Bitcoin Symbol
-	binanceSymbol = "BTCUSDC"
-)
+var log = logging.New("binance").With("exchange", orderbook.Binance)
+
+// BinanceDepthURL is the REST endpoint the depth snapshot is fetched from
+var BinanceDepthURL = "https://www.binance.com/api/v1/depth"
+
+// Number of levels fetched in the depth snapshot
+const binanceDepthLimit = 1000
 
 // BinanceDepthResponse is the type retrieved from the first orderbook snapshot
 type BinanceDepthResponse struct {
@@ -35,6 +41,66 @@ type BinanceDepthEvent struct {
 	Asks          []binance.Ask `json:"a"`
 }
 
+// wireDepthEvent is a depth event as sent on the stream
+type wireDepthEvent struct {
+	Event         string     `json:"e"`
+	Time          int64      `json:"E"`
+	Symbol        string     `json:"s"`
+	FirstUpdateID int64      `json:"U"`
+	FinalUpdateID int64      `json:"u"`
+	Bids          [][]string `json:"b"`
+	Asks          [][]string `json:"a"`
+}
+
+// MarshalJSON encodes the event as binance sends it,
+// with ["price", "qty"] pairs for the levels
+func (v BinanceDepthEvent) MarshalJSON() ([]byte, error) {
+	wire := wireDepthEvent{
+		Event:         v.Event,
+		Time:          v.Time,
+		Symbol:        v.Symbol,
+		FirstUpdateID: v.FirstUpdateID,
+		FinalUpdateID: v.FinalUpdateID,
+		Bids:          make([][]string, 0, len(v.Bids)),
+		Asks:          make([][]string, 0, len(v.Asks)),
+	}
+	for _, bid := range v.Bids {
+		wire.Bids = append(wire.Bids, []string{bid.Price, bid.Quantity})
+	}
+	for _, ask := range v.Asks {
+		wire.Asks = append(wire.Asks, []string{ask.Price, ask.Quantity})
+	}
+	return json.Marshal(wire)
+}
+
+// UnmarshalJSON decodes an event as binance sends it
+func (v *BinanceDepthEvent) UnmarshalJSON(data []byte) error {
+	var wire wireDepthEvent
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	*v = BinanceDepthEvent{
+		Event:         wire.Event,
+		Time:          wire.Time,
+		Symbol:        wire.Symbol,
+		FirstUpdateID: wire.FirstUpdateID,
+		FinalUpdateID: wire.FinalUpdateID,
+	}
+	for _, level := range wire.Bids {
+		if len(level) < 2 {
+			return fmt.Errorf("malformed depth level %v", level)
+		}
+		v.Bids = append(v.Bids, binance.Bid{Price: level[0], Quantity: level[1]})
+	}
+	for _, level := range wire.Asks {
+		if len(level) < 2 {
+			return fmt.Errorf("malformed depth level %v", level)
+		}
+		v.Asks = append(v.Asks, binance.Ask{Price: level[0], Quantity: level[1]})
+	}
+	return nil
+}
+
 // BinanceOrderBook is used for temporary orderbook struct
 type BinanceOrderBook struct {
 	Bids map[int64]float64
@@ -50,204 +116,174 @@ type OrderEvent struct {
 	FinalUpdateID int64
 }
 
-// Channel to hold the events you receive from WebSocket Stream
-var queueBinChan = make(chan *BinanceDepthEvent)
-var quitBinQueueChan = make(chan int)
-
-// Skiplist Binance OrderBook
-var binOrderBook *orderbook.OrderBook
-
-// Keep track of lastUpdateID of the first snapshot, needed to correctly add websocket events
-var lastUpdateID int64 = -1
-
-// Keep track of the last event's final update, needed to correctly add websocket events
-var prevu int64 = -1
-
-// AddBinOrderBookToSkipList is used to parse binance Bids and Asks to add into the BinanceOrderBook for the Skiplist Orderbook
-func AddBinOrderBookToSkipList(sl *orderbook.OrderBook, bids []binance.Bid, asks []binance.Ask) {
-	for _, elem := range bids {
-		fQty, _ := strconv.ParseFloat(elem.Quantity, 64)
-		fPrice, _ := strconv.ParseFloat(elem.Price, 64)
-		newOrder := orderbook.Order{
-			Price:       fPrice,
-			ExchangeKey: orderbook.Binance,
-			FillCost:    orderbook.ExFeeMap[orderbook.Binance],
-			Qty:         fQty,
+// AddBinOrderBookToSkipList is used to parse binance Bids and Asks of a symbol to add into the Skiplist Orderbook.
+// The levels before a malformed one are added.
+func AddBinOrderBookToSkipList(sl *orderbook.OrderBook, symbol orderbook.Symbol, bids []binance.Bid, asks []binance.Ask) error {
+	for i := range bids {
+		if err := AddBinanceBidEventToSkipList(sl, symbol, &bids[i]); err != nil {
+			return err
 		}
-		sl.AddBuy(newOrder)
-	}
-	for _, elem := range asks {
-		fQty, _ := strconv.ParseFloat(elem.Quantity, 64)
-		fPrice, _ := strconv.ParseFloat(elem.Price, 64)
-		newOrder := orderbook.Order{
-			Price:       fPrice,
-			ExchangeKey: orderbook.Binance,
-			FillCost:    orderbook.ExFeeMap[orderbook.Binance],
-			Qty:         fQty,
+	}
+	for i := range asks {
+		if err := AddBinanceAskEventToSkipList(sl, symbol, &asks[i]); err != nil {
+			return err
 		}
-		sl.AddSell(newOrder)
 	}
+	return nil
 }
 
-// AddBinanceBidEventToSkipList is used to add the Bid Event to the Skiplist, with restrictions
-func AddBinanceBidEventToSkipList(sl *orderbook.OrderBook, v *binance.Bid) {
-	fQty, _ := strconv.ParseFloat(v.Quantity, 64)
-	fPrice, _ := strconv.ParseFloat(v.Price, 64)
-	orderToAdd := orderbook.Order{
-		Price:       fPrice,
-		ExchangeKey: orderbook.Binance,
-		FillCost:    orderbook.ExFeeMap[orderbook.Binance],
-		Qty:         fQty,
+// AddBinanceBidEventToSkipList is used to add the Bid Event of a symbol to the Skiplist, with restrictions
+func AddBinanceBidEventToSkipList(sl *orderbook.OrderBook, symbol orderbook.Symbol, v *binance.Bid) error {
+	o, err := toOrder(symbol, v.Price, v.Quantity)
+	if err != nil {
+		return err
 	}
-	sl.AddBuy(orderToAdd)
+	sl.AddBuy(o)
+	return nil
 }
 
-// AddBinanceAskEventToSkipList is used to add the Ask Event to the Skiplist, with restrictions
-func AddBinanceAskEventToSkipList(sl *orderbook.OrderBook, v *binance.Ask) {
-	fQty, _ := strconv.ParseFloat(v.Quantity, 64)
-	fPrice, _ := strconv.ParseFloat(v.Price, 64)
-	orderToAdd := orderbook.Order{
-		Price:       fPrice,
-		ExchangeKey: orderbook.Binance,
-		FillCost:    orderbook.ExFeeMap[orderbook.Binance],
-		Qty:         fQty,
+// AddBinanceAskEventToSkipList is used to add the Ask Event of a symbol to the Skiplist, with restrictions
+func AddBinanceAskEventToSkipList(sl *orderbook.OrderBook, symbol orderbook.Symbol, v *binance.Ask) error {
+	o, err := toOrder(symbol, v.Price, v.Quantity)
+	if err != nil {
+		return err
 	}
-	sl.AddSell(orderToAdd)
+	sl.AddSell(o)
+	return nil
 }
 
-// Functions to manage local order book
-
-// InitBinanceHandler is used to initialize orderbook and websocket handler
-func InitBinanceHandler() {
-	binOrderBook = orderbook.Exchanges[orderbook.Binance].Books[orderbook.BTC_USDC]
-	if lastUpdateID != -1 {
-		lastUpdateID = -1
-		prevu = -1
-		quitBinQueueChan <- 0
+// toOrder parses a binance price level, keeping the exact decimals
+func toOrder(symbol orderbook.Symbol, price, qty string) (orderbook.Order, error) {
+	dPrice, err := decimal.NewFromString(price)
+	if err != nil {
+		return orderbook.Order{}, fmt.Errorf("price of level [%v %v]: %v", price, qty, err)
 	}
-	go GetDepthFromBinance()
-	time.Sleep(1000 * time.Millisecond)
-	manageBinanceOrderBook()
-	go manageBinanceQueue()
-}
-
-var wsDepthHandler = func(event *binance.WsDepthEvent) {
-	// Put event in BinanceDepth struct
-	data := BinanceDepthEvent{
-		Event:         event.Event,
-		Time:          event.Time,
-		Symbol:        event.Symbol,
-		FirstUpdateID: event.FirstUpdateID,
-		FinalUpdateID: event.UpdateID,
-		Bids:          event.Bids,
-		Asks:          event.Asks,
+	dQty, err := decimal.NewFromString(qty)
+	if err != nil {
+		return orderbook.Order{}, fmt.Errorf("quantity of level [%v %v]: %v", price, qty, err)
 	}
-
-	// Put the data received inside the queue
-	queueBinChan <- &data
-	// log.Info("UPDATE", "first", data.FirstUpdateID, "final", data.FinalUpdateID)
+	return orderbook.Order{
+		Price:       dPrice,
+		ExchangeKey: orderbook.Binance,
+		FillCost:    orderbook.Fees.FillCost(orderbook.Binance, symbol),
+		Qty:         dQty,
+	}, nil
 }
 
+// Functions to manage local order book
+
 var depthErrHandler = func(err error) {
-	log.Info("error", "err", err.Error())
+	log.Warn("depth stream error", "err", err)
 }
 
-// GetDepthFromBinance is the function used to start websocket connection to binance
-func GetDepthFromBinance() {
-	doneC, _, err := binance.WsDepthServe(binanceSymbol, wsDepthHandler, depthErrHandler)
-	if err != nil {
-		// log.Info("error", "err", err.Error())
-		return
-	}
-	<-doneC
-}
-
-// Function to get the depth snapshot from API, and insert into the local order book
-func manageBinanceOrderBook() {
-	// Get the data from the order book
-	depth := getBinanceDepth()
-	// add bids and asks into the skiplist orderbook
-	AddBinOrderBookToSkipList(binOrderBook, depth.Bids, depth.Asks)
-	// update the lastUpdateID of the snapshot
-	lastUpdateID = depth.LastUpdateID
-	// log.Info("LastUpdateID", "FIRST LUI", lastUpdateID)
-	log.Info("Binance Orderbook Initialized")
-}
-
-// Function to manage queue channel.
-// Each event is sent into the channel,
-// once lastUpdateID is updated, start processing events,
-// updating the orderbook when appropriate
-func manageBinanceQueue() {
-	for {
-		// Start processing queueChan when lastUpdateID is initialized
-		if lastUpdateID != -1 {
-			v, ok := <-queueBinChan
-			if ok {
-				// log.Info("MQ", "LASTUPDATEID", lastUpdateID)
-				// log.Info("MQ", "PREVU", prevu)
-				// ignore events where u <= lastUpdateID
-				if v.FinalUpdateID <= lastUpdateID {
-					continue
-				}
-				// finding the first event to use
-				if prevu == -1 && v.FirstUpdateID <= lastUpdateID+1 && v.FinalUpdateID >= lastUpdateID+1 {
-					// Add the bids and asks to the SkipList OrderBook
-					for _, elem := range v.Bids {
-						AddBinanceBidEventToSkipList(binOrderBook, &elem)
-					}
-					for _, elem := range v.Asks {
-						AddBinanceAskEventToSkipList(binOrderBook, &elem)
-					}
-					prevu = v.FinalUpdateID
-					// for testing purposes
-					// log.Info("MANAGE QUEUE", "first", v.FirstUpdateID, "final", v.FinalUpdateID)
-				} else if prevu != -1 && v.FirstUpdateID == prevu+1 {
-					// Add the bids and asks to the SkipList OrderBook
-					for _, elem := range v.Bids {
-						AddBinanceBidEventToSkipList(binOrderBook, &elem)
-					}
-					for _, elem := range v.Asks {
-						AddBinanceAskEventToSkipList(binOrderBook, &elem)
-					}
-					prevu = v.FinalUpdateID
-					// for testing purposes
-					// log.Info("MANAGE QUEUE", "first", v.FirstUpdateID, "final", v.FinalUpdateID)
-				}
-			}
-		} else {
-			_, ok := <-quitBinQueueChan
-			if ok {
-				// log.Info("quit")
-				return
-			}
+// depthHandler dispatches the depth events of each symbol to its book.
+// It never blocks the stream: the events of a book not keeping up are
+// dropped, the gap they leave resyncs that book alone.
+func depthHandler(books map[string]*symbolBook) binance.WsDepthHandler {
+	return func(event *binance.WsDepthEvent) {
+		sb, ok := books[event.Symbol]
+		if !ok {
+			return
+		}
+		// Put event in BinanceDepth struct
+		v := &BinanceDepthEvent{
+			Event:         event.Event,
+			Time:          event.Time,
+			Symbol:        event.Symbol,
+			FirstUpdateID: event.FirstUpdateID,
+			FinalUpdateID: event.UpdateID,
+			Bids:          event.Bids,
+			Asks:          event.Asks,
+		}
+		recorder.Save(orderbook.Binance, sb.symbol, recorder.Diff, v)
+		metrics.DepthEvents.WithLabelValues(string(orderbook.Binance), string(sb.symbol)).Inc()
+		select {
+		case sb.events <- v:
+		default:
+			sb.droppedLog.Warn("binance events queue full, dropping", "u", v.FinalUpdateID)
 		}
 	}
 }
 
-func getBinanceDepth() binance.DepthResponse {
-	response, err := http.Get("https://www.binance.com/api/v1/depth?symbol=BTCUSDC&limit=1000")
+// getBinanceDepth fetches the depth snapshot of a symbol, e.g. BTCUSDC.
+// The errors are typed, see package rest.
+func getBinanceDepth(symbol string) (binance.DepthResponse, error) {
+	url := fmt.Sprintf("%s?symbol=%s&limit=%d", BinanceDepthURL, symbol, binanceDepthLimit)
+	response, err := http.Get(url)
 	if err != nil {
-		// log.Info("error", "err", err.Error())
-		return binance.DepthResponse{}
+		metrics.HTTPErrors.WithLabelValues(string(orderbook.Binance), "depth").Inc()
+		return binance.DepthResponse{}, &rest.ExchangeUnavailable{Exchange: orderbook.Binance, Endpoint: "depth", Err: err}
 	}
 	defer response.Body.Close()
 	contents, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		// log.Info("Error: ", "err", err)
-		return binance.DepthResponse{}
+		return binance.DepthResponse{}, &rest.ExchangeUnavailable{Exchange: orderbook.Binance, Endpoint: "depth", Err: err}
+	}
+	if response.StatusCode != http.StatusOK {
+		metrics.HTTPErrors.WithLabelValues(string(orderbook.Binance), "depth").Inc()
+		return binance.DepthResponse{}, responseError("depth", symbol, response, contents)
 	}
 	// unmarshal JSON response
 	depthResponse := BinanceDepthResponse{}
-	jsonErr := json.Unmarshal(contents, &depthResponse)
-	if jsonErr != nil {
-		// log.Info("Error: ", "err", jsonErr)
-		return binance.DepthResponse{}
+	if err := json.Unmarshal(contents, &depthResponse); err != nil {
+		return binance.DepthResponse{}, &rest.DecodeError{Exchange: orderbook.Binance, Endpoint: "depth", Err: err}
+	}
+	depth, err := depthResponse.Depth()
+	if err != nil {
+		return depth, &rest.DecodeError{Exchange: orderbook.Binance, Endpoint: "depth", Err: err}
+	}
+	return depth, nil
+}
+
+// Depth parses the levels of the snapshot
+func (r BinanceDepthResponse) Depth() (binance.DepthResponse, error) {
+	depth := binance.DepthResponse{
+		LastUpdateID: r.LastUpdateID,
+		Bids:         make([]binance.Bid, 0, len(r.Bids)),
+		Asks:         make([]binance.Ask, 0, len(r.Asks)),
+	}
+	for _, elem := range r.Bids {
+		price, qty, err := parseLevel(elem)
+		if err != nil {
+			return binance.DepthResponse{}, err
+		}
+		depth.Bids = append(depth.Bids, binance.Bid{Price: price, Quantity: qty})
+	}
+	for _, elem := range r.Asks {
+		price, qty, err := parseLevel(elem)
+		if err != nil {
+			return binance.DepthResponse{}, err
+		}
+		depth.Asks = append(depth.Asks, binance.Ask{Price: price, Quantity: qty})
 	}
+	return depth, nil
+}
 
-	depthToReturn := binance.DepthResponse{
-		LastUpdateID: depthResponse.LastUpdateID,
+// depthResponse encodes a snapshot back to the response binance sent
+func depthResponse(depth binance.DepthResponse) BinanceDepthResponse {
+	r := BinanceDepthResponse{
+		LastUpdateID: depth.LastUpdateID,
+		Bids:         make([][]interface{}, 0, len(depth.Bids)),
+		Asks:         make([][]interface{}, 0, len(depth.Asks)),
+	}
+	for _, bid := range depth.Bids {
+		r.Bids = append(r.Bids, []interface{}{bid.Price, bid.Quantity})
+	}
+	for _, ask := range depth.Asks {
+		r.Asks = append(r.Asks, []interface{}{ask.Price, ask.Quantity})
 	}
+	return r
+}
 
-	return depthToReturn
+// parseLevel reads the ["price", "qty"] pair of a snapshot level
+func parseLevel(level []interface{}) (price, qty string, err error) {
+	if len(level) < 2 {
+		return "", "", fmt.Errorf("malformed depth level %v", level)
+	}
+	price, okPrice := level[0].(string)
+	qty, okQty := level[1].(string)
+	if !okPrice || !okQty {
+		return "", "", fmt.Errorf("malformed depth level %v", level)
+	}
+	return price, qty, nil
 }
@@ -0,0 +1,113 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/rest"
+	"github.com/stretchr/testify/assert"
+)
+
+const snapshot = `{
+	"lastUpdateId": 1027024,
+	"bids": [["4000.00000000", "431.00000000"], ["3999.50000000", "12.50000000"]],
+	"asks": [["4000.50000000", "12.00000000"], ["4001.00000000", "3.25000000"], ["4010.00000000", "1.00000000"]]
+}`
+
+func serveSnapshot(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "BTCUSDC", r.URL.Query().Get("symbol"))
+		_, _ = w.Write([]byte(snapshot))
+	}))
+}
+
+func TestSnapshotLoadedIntoBook(t *testing.T) {
+	server := serveSnapshot(t)
+	defer server.Close()
+	url := BinanceDepthURL
+	BinanceDepthURL = server.URL
+	defer func() { BinanceDepthURL = url }()
+
+	book := orderbook.NewOrderBook()
+	sb := newSymbolBook(orderbook.BTC_USDC, book)
+	// an event older than the snapshot triggers the sync, and is dropped
+	handle(sb, &BinanceDepthEvent{FirstUpdateID: 1027000, FinalUpdateID: 1027010})
+
+	assert.Equal(t, Synced, sb.State())
+	assert.Equal(t, int64(1027024), sb.lastUpdateID)
+	var bids, asks []orderbook.Order
+	for it := book.IteratorBuySide(); it.Next(); {
+		bids = append(bids, it.Value().(orderbook.Order))
+	}
+	for it := book.IteratorSellSide(); it.Next(); {
+		asks = append(asks, it.Value().(orderbook.Order))
+	}
+	assert.Len(t, bids, 2)
+	assert.Len(t, asks, 3)
+	assert.Equal(t, "4000", bids[0].Price.String())
+	assert.Equal(t, "431", bids[0].Qty.String())
+	assert.Equal(t, "3999.5", bids[1].Price.String())
+	assert.Equal(t, "4000.5", asks[0].Price.String())
+	assert.Equal(t, "12", asks[0].Qty.String())
+	assert.Equal(t, "4010", asks[2].Price.String())
+	assert.Equal(t, orderbook.Binance, asks[2].ExchangeKey)
+}
+
+func TestGetBinanceDepthStatusError(t *testing.T) {
+	var status int
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	url := BinanceDepthURL
+	BinanceDepthURL = server.URL
+	defer func() { BinanceDepthURL = url }()
+
+	status, body = http.StatusBadRequest, ""
+	_, err := getBinanceDepth("BTCUSDC")
+	assert.Equal(t, &BinanceError{Status: 400, Msg: "Bad Request"}, err)
+
+	body = `{"code": -1121, "msg": "Invalid symbol."}`
+	_, err = getBinanceDepth("BTCXYZ")
+	assert.Equal(t, &rest.InvalidSymbol{Exchange: orderbook.Binance, Symbol: "BTCXYZ"}, err)
+
+	status = http.StatusTooManyRequests
+	_, err = getBinanceDepth("BTCUSDC")
+	assert.Equal(t, &rest.RateLimited{Exchange: orderbook.Binance, Endpoint: "depth", RetryAfter: time.Minute}, err)
+
+	status = http.StatusServiceUnavailable
+	_, err = getBinanceDepth("BTCUSDC")
+	assert.Equal(t, &rest.ExchangeUnavailable{Exchange: orderbook.Binance, Endpoint: "depth", Status: 503}, err)
+
+	status, body = http.StatusOK, `{"lastUpdateId": 1, "bids": [["4000.00", 1]], "asks": []}`
+	_, err = getBinanceDepth("BTCUSDC")
+	assert.IsType(t, &rest.DecodeError{}, err)
+}
+
+func TestDepthEventWireFormat(t *testing.T) {
+	raw := `{"e":"depthUpdate","E":1578304294000,"s":"BTCUSDC","U":157,"u":160,"b":[["4000.00","1.5"]],"a":[["4001.00","0"]]}`
+	var v BinanceDepthEvent
+	assert.Nil(t, json.Unmarshal([]byte(raw), &v))
+	assert.Equal(t, int64(160), v.FinalUpdateID)
+	assert.Equal(t, "1.5", v.Bids[0].Quantity)
+	assert.Equal(t, "4001.00", v.Asks[0].Price)
+
+	encoded, err := json.Marshal(&v)
+	assert.Nil(t, err)
+	assert.JSONEq(t, raw, string(encoded))
+
+	var response BinanceDepthResponse
+	assert.Nil(t, json.Unmarshal([]byte(snapshot), &response))
+	depth, err := response.Depth()
+	assert.Nil(t, err)
+	encoded, err = json.Marshal(depthResponse(depth))
+	assert.Nil(t, err)
+	assert.JSONEq(t, snapshot, string(encoded))
+}
@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	binance "github.com/adshao/go-binance"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/recorder"
+)
+
+// errNoSnapshot is returned to a replayed book asking for a snapshot
+// before the recorded one is replayed
+var errNoSnapshot = errors.New("no recorded snapshot yet")
+
+// BinanceReplay rebuilds the binance books of orderbook.Exchanges from
+// recorded depth data. The recorded events go through the same
+// synchronisation as those of the stream, the snapshots recorded
+// being handed to the books when they ask for one.
+type BinanceReplay struct {
+	books   map[orderbook.Symbol]*symbolBook
+	pending map[orderbook.Symbol]*binance.DepthResponse
+	now     func() time.Time
+	publish func(orderbook.BookUpdate)
+}
+
+// NewBinanceReplay creates a replay timing the snapshot retries with now,
+// and calling publish with every change applied to a book
+func NewBinanceReplay(now func() time.Time, publish func(orderbook.BookUpdate)) *BinanceReplay {
+	return &BinanceReplay{
+		books:   make(map[orderbook.Symbol]*symbolBook),
+		pending: make(map[orderbook.Symbol]*binance.DepthResponse),
+		now:     now,
+		publish: publish,
+	}
+}
+
+// Apply replays a recorded snapshot or depth event
+func (r *BinanceReplay) Apply(rec recorder.Record) error {
+	sb, err := r.book(rec.Symbol)
+	if err != nil {
+		return err
+	}
+	switch rec.Kind {
+	case recorder.Snapshot:
+		var response BinanceDepthResponse
+		if err := json.Unmarshal(rec.Data, &response); err != nil {
+			return fmt.Errorf("binance %v snapshot: %v", rec.Symbol, err)
+		}
+		depth, err := response.Depth()
+		if err != nil {
+			return fmt.Errorf("binance %v snapshot: %v", rec.Symbol, err)
+		}
+		r.pending[rec.Symbol] = &depth
+		// the snapshot was fetched because the book was out of sync
+		sb.mu.Lock()
+		defer sb.mu.Unlock()
+		sb.nextAttempt = time.Time{}
+		if sb.State() != Synced {
+			sb.resync()
+		}
+	case recorder.Diff:
+		var v BinanceDepthEvent
+		if err := json.Unmarshal(rec.Data, &v); err != nil {
+			return fmt.Errorf("binance %v event: %v", rec.Symbol, err)
+		}
+		sb.handle(&v)
+	default:
+		return fmt.Errorf("unknown record kind %q", rec.Kind)
+	}
+	return nil
+}
+
+func (r *BinanceReplay) book(symbol orderbook.Symbol) (*symbolBook, error) {
+	if sb, ok := r.books[symbol]; ok {
+		return sb, nil
+	}
+	book := orderbook.Exchanges[orderbook.Binance].Books[symbol]
+	if book == nil {
+		return nil, fmt.Errorf("no binance book for %v", symbol)
+	}
+	sb := newSymbolBook(symbol, book)
+	sb.now = r.now
+	sb.publish = r.publish
+	sb.inline = true
+	sb.snapshot = func(string) (binance.DepthResponse, error) {
+		depth, ok := r.pending[symbol]
+		if !ok {
+			return binance.DepthResponse{}, errNoSnapshot
+		}
+		delete(r.pending, symbol)
+		return *depth, nil
+	}
+	r.books[symbol] = sb
+	return sb, nil
+}
@@ -0,0 +1,386 @@
+package websocket
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/metrics"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/rest"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	// BinanceRESTURL is the base URL of binance's REST API
+	BinanceRESTURL = "https://api.binance.com"
+	// RecvWindow is how long a signed request stays valid after its timestamp
+	RecvWindow = 5 * time.Second
+)
+
+// ErrNoCredentials is returned by the signed methods
+// until SetCredentials is called
+var ErrNoCredentials = errors.New("binance: no API credentials")
+
+const (
+	// codeTimestamp is returned when the timestamp of a request
+	// is outside of the recvWindow
+	codeTimestamp = -1021
+	// codeInvalidSymbol is returned for a symbol binance doesn't list
+	codeInvalidSymbol = -1121
+)
+
+// BinanceError is an error returned by binance's REST API
+type BinanceError struct {
+	Status int
+	Code   int    `json:"code"`
+	Msg    string `json:"msg"`
+}
+
+func (e *BinanceError) Error() string {
+	return fmt.Sprintf("binance: %v (code %d, status %d)", e.Msg, e.Code, e.Status)
+}
+
+// FilterError is returned when an order breaks a filter of its symbol
+type FilterError struct {
+	Symbol string
+	Filter string
+	Reason string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("binance %v %v: %v", e.Symbol, e.Filter, e.Reason)
+}
+
+// SymbolFilters are the exchangeInfo filters an order must pass
+type SymbolFilters struct {
+	TickSize, MinPrice, MaxPrice decimal.Decimal // PRICE_FILTER
+	StepSize, MinQty, MaxQty     decimal.Decimal // LOT_SIZE
+	MinNotional                  decimal.Decimal // MIN_NOTIONAL
+}
+
+// Apply rounds the price to the tick size and the quantity down to the
+// step size, then checks them against the filters. The price is zero
+// for market orders, their notional is not checked.
+func (f SymbolFilters) Apply(symbol string, price, qty decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	if !price.IsZero() {
+		price = orderbook.RoundToTick(price, f.TickSize)
+		if price.LessThan(f.MinPrice) || (f.MaxPrice.IsPositive() && price.GreaterThan(f.MaxPrice)) {
+			return price, qty, &FilterError{symbol, "PRICE_FILTER", fmt.Sprintf("price %v out of [%v, %v]", price, f.MinPrice, f.MaxPrice)}
+		}
+	}
+	qty = orderbook.FloorToStep(qty, f.StepSize)
+	if qty.LessThan(f.MinQty) || !qty.IsPositive() || (f.MaxQty.IsPositive() && qty.GreaterThan(f.MaxQty)) {
+		return price, qty, &FilterError{symbol, "LOT_SIZE", fmt.Sprintf("quantity %v out of [%v, %v]", qty, f.MinQty, f.MaxQty)}
+	}
+	if !price.IsZero() && price.Mul(qty).LessThan(f.MinNotional) {
+		return price, qty, &FilterError{symbol, "MIN_NOTIONAL", fmt.Sprintf("notional %v below %v", price.Mul(qty), f.MinNotional)}
+	}
+	return price, qty, nil
+}
+
+// BinanceOrderRequest are the parameters of a new order
+type BinanceOrderRequest struct {
+	Symbol        string // e.g. BTCUSDC
+	Side          string // BUY or SELL
+	Type          string // LIMIT or MARKET
+	TimeInForce   string // GTC, IOC or FOK, for limit orders
+	Qty           decimal.Decimal
+	Price         decimal.Decimal // Zero for market orders
+	ClientOrderID string
+}
+
+// BinanceOrder is an order as returned by binance
+type BinanceOrder struct {
+	Symbol              string          `json:"symbol"`
+	OrderID             int64           `json:"orderId"`
+	ClientOrderID       string          `json:"clientOrderId"`
+	Price               decimal.Decimal `json:"price"`
+	OrigQty             decimal.Decimal `json:"origQty"`
+	ExecutedQty         decimal.Decimal `json:"executedQty"`
+	CummulativeQuoteQty decimal.Decimal `json:"cummulativeQuoteQty"`
+	Status              string          `json:"status"`
+	TimeInForce         string          `json:"timeInForce"`
+	Type                string          `json:"type"`
+	Side                string          `json:"side"`
+}
+
+// BinanceBalance is the balance of an asset
+type BinanceBalance struct {
+	Asset  string          `json:"asset"`
+	Free   decimal.Decimal `json:"free"`
+	Locked decimal.Decimal `json:"locked"`
+}
+
+// BinanceClient is a signed client of binance's REST API.
+// Request timestamps are corrected by the offset of binance's
+// clock measured by SyncTime.
+type BinanceClient struct {
+	BaseURL    string
+	RecvWindow time.Duration
+	Retry      rest.Policy // Policy of the GET requests
+
+	key, secret string
+	client      *http.Client
+	offset      int64 // Server time minus local time, in milliseconds
+	filters     map[string]SymbolFilters
+	mu          sync.RWMutex
+}
+
+// BinanceRESTInstance is the client used by the app,
+// its credentials are set from the environment
+var BinanceRESTInstance = NewBinanceClient("", "")
+
+// NewBinanceClient creates a client signing its requests with key and secret
+func NewBinanceClient(key, secret string) *BinanceClient {
+	return &BinanceClient{
+		BaseURL:    BinanceRESTURL,
+		RecvWindow: RecvWindow,
+		Retry:      rest.DefaultPolicy,
+		key:        key,
+		secret:     secret,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// SetCredentials sets the API key and secret signing the requests
+func (c *BinanceClient) SetCredentials(key, secret string) {
+	c.key, c.secret = key, secret
+}
+
+// SyncTime measures the offset between the local clock and binance's
+func (c *BinanceClient) SyncTime() error {
+	before := time.Now()
+	var response struct {
+		ServerTime int64 `json:"serverTime"`
+	}
+	if err := c.do(http.MethodGet, "/api/v3/time", nil, false, &response); err != nil {
+		return err
+	}
+	after := time.Now()
+	local := before.Add(after.Sub(before)/2).UnixNano() / int64(time.Millisecond)
+	atomic.StoreInt64(&c.offset, response.ServerTime-local)
+	return nil
+}
+
+// Offset returns the offset of binance's clock measured by SyncTime
+func (c *BinanceClient) Offset() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.offset)) * time.Millisecond
+}
+
+// LoadFilters reads the filters of every symbol from exchangeInfo
+func (c *BinanceClient) LoadFilters() error {
+	var info struct {
+		Symbols []struct {
+			Symbol  string `json:"symbol"`
+			Filters []struct {
+				FilterType  string          `json:"filterType"`
+				TickSize    decimal.Decimal `json:"tickSize"`
+				MinPrice    decimal.Decimal `json:"minPrice"`
+				MaxPrice    decimal.Decimal `json:"maxPrice"`
+				StepSize    decimal.Decimal `json:"stepSize"`
+				MinQty      decimal.Decimal `json:"minQty"`
+				MaxQty      decimal.Decimal `json:"maxQty"`
+				MinNotional decimal.Decimal `json:"minNotional"`
+			} `json:"filters"`
+		} `json:"symbols"`
+	}
+	if err := c.do(http.MethodGet, "/api/v3/exchangeInfo", nil, false, &info); err != nil {
+		return err
+	}
+	filters := make(map[string]SymbolFilters, len(info.Symbols))
+	for _, s := range info.Symbols {
+		var f SymbolFilters
+		for _, filter := range s.Filters {
+			switch filter.FilterType {
+			case "PRICE_FILTER":
+				f.TickSize, f.MinPrice, f.MaxPrice = filter.TickSize, filter.MinPrice, filter.MaxPrice
+			case "LOT_SIZE":
+				f.StepSize, f.MinQty, f.MaxQty = filter.StepSize, filter.MinQty, filter.MaxQty
+			case "MIN_NOTIONAL":
+				f.MinNotional = filter.MinNotional
+			}
+		}
+		filters[s.Symbol] = f
+	}
+	c.mu.Lock()
+	c.filters = filters
+	c.mu.Unlock()
+	return nil
+}
+
+// Filters returns the filters of a symbol, loading them when needed
+func (c *BinanceClient) Filters(symbol string) (SymbolFilters, error) {
+	c.mu.RLock()
+	loaded := c.filters != nil
+	f, ok := c.filters[symbol]
+	c.mu.RUnlock()
+	if !loaded {
+		if err := c.LoadFilters(); err != nil {
+			return f, err
+		}
+		return c.Filters(symbol)
+	}
+	if !ok {
+		return f, fmt.Errorf("binance: unknown symbol %v", symbol)
+	}
+	return f, nil
+}
+
+// CreateOrder applies the filters of the symbol to the order and places it
+func (c *BinanceClient) CreateOrder(r BinanceOrderRequest) (order BinanceOrder, err error) {
+	filters, err := c.Filters(r.Symbol)
+	if err != nil {
+		return order, err
+	}
+	price, qty, err := filters.Apply(r.Symbol, r.Price, r.Qty)
+	if err != nil {
+		return order, err
+	}
+	params := url.Values{
+		"symbol":           {r.Symbol},
+		"side":             {r.Side},
+		"type":             {r.Type},
+		"quantity":         {qty.String()},
+		"newOrderRespType": {"RESULT"},
+	}
+	if r.Type != "MARKET" {
+		params.Set("price", price.String())
+		params.Set("timeInForce", r.TimeInForce)
+	}
+	if r.ClientOrderID != "" {
+		params.Set("newClientOrderId", r.ClientOrderID)
+	}
+	err = c.do(http.MethodPost, "/api/v3/order", params, true, &order)
+	return order, err
+}
+
+// CancelOrder cancels an order by its client order id
+func (c *BinanceClient) CancelOrder(symbol, clientOrderID string) (order BinanceOrder, err error) {
+	params := url.Values{"symbol": {symbol}, "origClientOrderId": {clientOrderID}}
+	err = c.do(http.MethodDelete, "/api/v3/order", params, true, &order)
+	return order, err
+}
+
+// GetOrder returns an order by its client order id
+func (c *BinanceClient) GetOrder(symbol, clientOrderID string) (order BinanceOrder, err error) {
+	params := url.Values{"symbol": {symbol}, "origClientOrderId": {clientOrderID}}
+	err = c.do(http.MethodGet, "/api/v3/order", params, true, &order)
+	return order, err
+}
+
+// OpenOrders returns the open orders of a symbol
+func (c *BinanceClient) OpenOrders(symbol string) (orders []BinanceOrder, err error) {
+	err = c.do(http.MethodGet, "/api/v3/openOrders", url.Values{"symbol": {symbol}}, true, &orders)
+	return orders, err
+}
+
+// Balances returns the balance of every asset of the account
+func (c *BinanceClient) Balances() (map[string]BinanceBalance, error) {
+	var account struct {
+		Balances []BinanceBalance `json:"balances"`
+	}
+	if err := c.do(http.MethodGet, "/api/v3/account", url.Values{}, true, &account); err != nil {
+		return nil, err
+	}
+	balances := make(map[string]BinanceBalance, len(account.Balances))
+	for _, b := range account.Balances {
+		balances[b.Asset] = b
+	}
+	return balances, nil
+}
+
+// do sends a request, signing it when signed is set. A signed request
+// rejected for its timestamp is sent again once the time is synced.
+// The GET requests are retried with the Retry policy, the others
+// may have gone through.
+func (c *BinanceClient) do(method, path string, params url.Values, signed bool, result interface{}) error {
+	policy := c.Retry
+	if method != http.MethodGet {
+		policy.Attempts = 1
+	}
+	return policy.Do(context.Background(), func() error {
+		err := c.send(method, path, params, signed, result)
+		if apiErr, ok := err.(*BinanceError); ok && signed && apiErr.Code == codeTimestamp {
+			if err := c.SyncTime(); err != nil {
+				return err
+			}
+			return c.send(method, path, params, signed, result)
+		}
+		return err
+	})
+}
+
+func (c *BinanceClient) send(method, path string, params url.Values, signed bool, result interface{}) error {
+	if signed && (c.key == "" || c.secret == "") {
+		return ErrNoCredentials
+	}
+	query := url.Values{}
+	for k, v := range params {
+		query[k] = v
+	}
+	if signed {
+		now := time.Now().UnixNano()/int64(time.Millisecond) + atomic.LoadInt64(&c.offset)
+		query.Set("timestamp", strconv.FormatInt(now, 10))
+		query.Set("recvWindow", strconv.FormatInt(int64(c.RecvWindow/time.Millisecond), 10))
+	}
+	encoded := query.Encode()
+	if signed {
+		mac := hmac.New(sha256.New, []byte(c.secret))
+		mac.Write([]byte(encoded))
+		encoded += "&signature=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	req, err := http.NewRequest(method, c.BaseURL+path+"?"+encoded, nil)
+	if err != nil {
+		return err
+	}
+	if signed {
+		req.Header.Set("X-MBX-APIKEY", c.key)
+	}
+	response, err := c.client.Do(req)
+	if err != nil {
+		metrics.HTTPErrors.WithLabelValues(string(orderbook.Binance), path).Inc()
+		return &rest.ExchangeUnavailable{Exchange: orderbook.Binance, Endpoint: path, Err: err}
+	}
+	defer response.Body.Close()
+	contents, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return &rest.ExchangeUnavailable{Exchange: orderbook.Binance, Endpoint: path, Err: err}
+	}
+	if response.StatusCode != http.StatusOK {
+		metrics.HTTPErrors.WithLabelValues(string(orderbook.Binance), path).Inc()
+		return responseError(path, params.Get("symbol"), response, contents)
+	}
+	if err := json.Unmarshal(contents, result); err != nil {
+		return &rest.DecodeError{Exchange: orderbook.Binance, Endpoint: path, Err: err}
+	}
+	return nil
+}
+
+// responseError returns the error of a response with a status other
+// than 200: a rate limit, a server error, or the error of the API
+func responseError(endpoint, symbol string, response *http.Response, contents []byte) error {
+	if err := rest.StatusError(orderbook.Binance, endpoint, response); err != nil {
+		return err
+	}
+	apiErr := &BinanceError{Status: response.StatusCode}
+	if json.Unmarshal(contents, apiErr) != nil || apiErr.Msg == "" {
+		apiErr.Msg = http.StatusText(response.StatusCode)
+	}
+	if apiErr.Code == codeInvalidSymbol {
+		return &rest.InvalidSymbol{Exchange: orderbook.Binance, Symbol: symbol}
+	}
+	return apiErr
+}
@@ -0,0 +1,215 @@
+package websocket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+var d = decimal.RequireFromString
+
+const exchangeInfo = `{"symbols": [{"symbol": "BTCUSDC", "filters": [
+	{"filterType": "PRICE_FILTER", "minPrice": "0.01000000", "maxPrice": "1000000.00000000", "tickSize": "0.01000000"},
+	{"filterType": "LOT_SIZE", "minQty": "0.00001000", "maxQty": "9000.00000000", "stepSize": "0.00001000"},
+	{"filterType": "MIN_NOTIONAL", "minNotional": "10.00000000"}
+]}]}`
+
+// mockBinance stands in for binance's REST API. It checks the signature
+// and the timestamp of signed requests against its own clock, which is
+// skew ahead of the local one.
+type mockBinance struct {
+	t        *testing.T
+	skew     time.Duration
+	orders   map[string]BinanceOrder
+	requests []*http.Request
+	mu       sync.Mutex
+}
+
+func newMockBinance(t *testing.T, skew time.Duration) (*mockBinance, *httptest.Server) {
+	m := &mockBinance{t: t, skew: skew, orders: make(map[string]BinanceOrder)}
+	return m, httptest.NewServer(m)
+}
+
+func (m *mockBinance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = append(m.requests, r)
+	now := time.Now().Add(m.skew).UnixNano() / int64(time.Millisecond)
+	query := r.URL.Query()
+
+	switch r.URL.Path {
+	case "/api/v3/time":
+		m.write(w, map[string]int64{"serverTime": now})
+		return
+	case "/api/v3/exchangeInfo":
+		w.Write([]byte(exchangeInfo))
+		return
+	}
+
+	// signed endpoints
+	raw := r.URL.RawQuery
+	i := strings.LastIndex(raw, "&signature=")
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(raw[:i]))
+	if r.Header.Get("X-MBX-APIKEY") != "key" || raw[i+len("&signature="):] != hex.EncodeToString(mac.Sum(nil)) {
+		m.fail(w, http.StatusUnauthorized, -1022, "Signature for this request is not valid.")
+		return
+	}
+	timestamp, _ := strconv.ParseInt(query.Get("timestamp"), 10, 64)
+	window, _ := strconv.ParseInt(query.Get("recvWindow"), 10, 64)
+	if timestamp > now+1000 || now-timestamp > window {
+		m.fail(w, http.StatusBadRequest, codeTimestamp, "Timestamp for this request is outside of the recvWindow.")
+		return
+	}
+
+	switch r.URL.Path + " " + r.Method {
+	case "/api/v3/order POST":
+		price, _ := decimal.NewFromString(query.Get("price"))
+		order := BinanceOrder{
+			Symbol:        query.Get("symbol"),
+			OrderID:       int64(len(m.orders) + 1),
+			ClientOrderID: query.Get("newClientOrderId"),
+			Price:         price,
+			OrigQty:       decimal.RequireFromString(query.Get("quantity")),
+			Status:        "NEW",
+			TimeInForce:   query.Get("timeInForce"),
+			Type:          query.Get("type"),
+			Side:          query.Get("side"),
+		}
+		m.orders[order.ClientOrderID] = order
+		m.write(w, order)
+	case "/api/v3/order GET", "/api/v3/order DELETE":
+		order, ok := m.orders[query.Get("origClientOrderId")]
+		if !ok {
+			m.fail(w, http.StatusBadRequest, -2013, "Order does not exist.")
+			return
+		}
+		if r.Method == http.MethodDelete {
+			order.Status = "CANCELED"
+			m.orders[order.ClientOrderID] = order
+		}
+		m.write(w, order)
+	case "/api/v3/openOrders GET":
+		orders := []BinanceOrder{}
+		for _, o := range m.orders {
+			if o.Status == "NEW" {
+				orders = append(orders, o)
+			}
+		}
+		m.write(w, orders)
+	case "/api/v3/account GET":
+		w.Write([]byte(`{"balances": [{"asset": "BTC", "free": "0.50000000", "locked": "0.01000000"}, {"asset": "USDC", "free": "1000.00000000", "locked": "0.00000000"}]}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (m *mockBinance) write(w http.ResponseWriter, v interface{}) {
+	assert.Nil(m.t, json.NewEncoder(w).Encode(v))
+}
+
+func (m *mockBinance) fail(w http.ResponseWriter, status, code int, msg string) {
+	w.WriteHeader(status)
+	m.write(w, map[string]interface{}{"code": code, "msg": msg})
+}
+
+func newTestClient(server *httptest.Server) *BinanceClient {
+	c := NewBinanceClient("key", "secret")
+	c.BaseURL = server.URL
+	return c
+}
+
+func TestBinanceClient(t *testing.T) {
+	mock, server := newMockBinance(t, 0)
+	defer server.Close()
+	c := newTestClient(server)
+
+	// the price is rounded to the tick and the quantity floored to the step
+	order, err := c.CreateOrder(BinanceOrderRequest{
+		Symbol: "BTCUSDC", Side: "BUY", Type: "LIMIT", TimeInForce: "GTC",
+		Price: d("4000.123"), Qty: d("0.0123456"), ClientOrderID: "a",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "4000.12", order.Price.String())
+	assert.Equal(t, "0.01234", order.OrigQty.String())
+	assert.Equal(t, "NEW", order.Status)
+	assert.Equal(t, "5000", mock.requests[1].URL.Query().Get("recvWindow"))
+
+	order, err = c.GetOrder("BTCUSDC", "a")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), order.OrderID)
+
+	open, err := c.OpenOrders("BTCUSDC")
+	assert.Nil(t, err)
+	assert.Len(t, open, 1)
+
+	order, err = c.CancelOrder("BTCUSDC", "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "CANCELED", order.Status)
+
+	_, err = c.GetOrder("BTCUSDC", "b")
+	assert.Equal(t, -2013, err.(*BinanceError).Code)
+
+	balances, err := c.Balances()
+	assert.Nil(t, err)
+	assert.Equal(t, "0.5", balances["BTC"].Free.String())
+	assert.Equal(t, "0.01", balances["BTC"].Locked.String())
+
+	c.SetCredentials("key", "wrong")
+	_, err = c.Balances()
+	assert.Equal(t, -1022, err.(*BinanceError).Code)
+
+	c.SetCredentials("", "")
+	_, err = c.Balances()
+	assert.Equal(t, ErrNoCredentials, err)
+}
+
+func TestBinanceFilters(t *testing.T) {
+	_, server := newMockBinance(t, 0)
+	defer server.Close()
+	c := newTestClient(server)
+
+	orders := map[string]BinanceOrderRequest{
+		"LOT_SIZE":     {Symbol: "BTCUSDC", Side: "SELL", Type: "LIMIT", TimeInForce: "GTC", Price: d("4000"), Qty: d("0.000009")},
+		"MIN_NOTIONAL": {Symbol: "BTCUSDC", Side: "SELL", Type: "LIMIT", TimeInForce: "GTC", Price: d("4000"), Qty: d("0.002")},
+		"PRICE_FILTER": {Symbol: "BTCUSDC", Side: "BUY", Type: "LIMIT", TimeInForce: "GTC", Price: d("0.001"), Qty: d("1")},
+	}
+	for filter, r := range orders {
+		_, err := c.CreateOrder(r)
+		assert.Equal(t, filter, err.(*FilterError).Filter)
+	}
+
+	// market orders have no price to check the notional against
+	_, err := c.CreateOrder(BinanceOrderRequest{Symbol: "BTCUSDC", Side: "SELL", Type: "MARKET", Qty: d("0.002")})
+	assert.Nil(t, err)
+
+	_, err = c.CreateOrder(BinanceOrderRequest{Symbol: "ETHUSDC", Side: "SELL", Type: "MARKET", Qty: d("1")})
+	assert.NotNil(t, err)
+}
+
+func TestBinanceServerTime(t *testing.T) {
+	mock, server := newMockBinance(t, 10*time.Second)
+	defer server.Close()
+	c := newTestClient(server)
+
+	// rejected for its timestamp, the request is sent again once synced
+	_, err := c.Balances()
+	assert.Nil(t, err)
+	assert.Len(t, mock.requests, 3)
+	assert.InDelta(t, float64(10*time.Second), float64(c.Offset()), float64(time.Second))
+
+	_, err = c.Balances()
+	assert.Nil(t, err)
+	assert.Len(t, mock.requests, 4)
+}
@@ -0,0 +1,325 @@
+package websocket
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	binance "github.com/adshao/go-binance"
+	"github.com/anthonychristian/crypto-arbitrage/logging"
+	"github.com/anthonychristian/crypto-arbitrage/metrics"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/recorder"
+	"github.com/anthonychristian/crypto-arbitrage/rest"
+)
+
+// SyncState tells whether a local book follows the depth stream
+type SyncState int32
+
+const (
+	// OutOfSync books wait for a snapshot, buffering the stream events
+	OutOfSync SyncState = iota
+	// Synced books apply every stream event as it arrives
+	Synced
+)
+
+func (s SyncState) String() string {
+	if s == Synced {
+		return "synced"
+	}
+	return "out-of-sync"
+}
+
+const (
+	// Maximum number of events buffered while waiting for a snapshot
+	maxBufferedEvents = 1000
+	// Minimum time between two snapshot requests of a symbol
+	snapshotRetryInterval = time.Second
+)
+
+// symbolBook keeps the local book of one symbol in sync with its
+// depth stream, following binance's documented procedure:
+//   - buffer the stream events and fetch a depth snapshot
+//   - drop the buffered events where u <= lastUpdateId of the snapshot
+//   - the first event applied must have U <= lastUpdateId+1 <= u
+//   - every following event must have U == u+1 of the previous one
+//
+// Whenever an event breaks the sequence the book goes out of sync
+// and the procedure is restarted with a new snapshot. The snapshots
+// are fetched off the run loop, which keeps buffering the events.
+type symbolBook struct {
+	symbol orderbook.Symbol
+	book   *orderbook.OrderBook
+	events chan *BinanceDepthEvent
+
+	// snapshot fetches the depth snapshot, getBinanceDepth by default
+	snapshot func(symbol string) (binance.DepthResponse, error)
+	// publish is called with every change applied to the book
+	publish func(update orderbook.BookUpdate)
+	// now is the clock the snapshot retries are timed with
+	now func() time.Time
+	// inline fetches the snapshots on the goroutine handling the events,
+	// so that replays are deterministic
+	inline bool
+
+	log        *logging.Logger
+	appliedLog *logging.Logger // Samples the applied events, they come by the hundreds
+	droppedLog *logging.Logger // Samples the events dropped when the queue is full
+
+	buffer       []*BinanceDepthEvent
+	lastUpdateID int64     // lastUpdateId of the snapshot the book was built from
+	prevu        int64     // Final update ID of the last applied event
+	nextAttempt  time.Time // No snapshot is requested before then
+	synced       bool      // The book has been synced at least once
+
+	snapshots  chan fetched   // Snapshot fetched for the run loop, one at a time
+	fetching   bool           // A snapshot is being fetched
+	generation int            // Bumped by reset, older snapshots are dropped
+	fetches    sync.WaitGroup // Snapshots being fetched
+
+	state   int32 // SyncState
+	resyncs int64
+	mu      sync.Mutex
+}
+
+// fetched is a depth snapshot fetched for a book
+type fetched struct {
+	depth      binance.DepthResponse
+	err        error
+	generation int // Generation of the book it was requested for
+}
+
+func newSymbolBook(symbol orderbook.Symbol, book *orderbook.OrderBook) *symbolBook {
+	return &symbolBook{
+		symbol:       symbol,
+		book:         book,
+		events:       make(chan *BinanceDepthEvent, maxBufferedEvents),
+		snapshots:    make(chan fetched, 1),
+		snapshot:     getBinanceDepth,
+		publish:      func(orderbook.BookUpdate) {},
+		now:          time.Now,
+		log:          log.With("symbol", symbol),
+		appliedLog:   log.With("symbol", symbol).Sampled(1, 100),
+		droppedLog:   log.With("symbol", symbol).Sampled(1, 1000),
+		lastUpdateID: -1,
+		prevu:        -1,
+	}
+}
+
+// State returns whether the book currently follows the stream
+func (s *symbolBook) State() SyncState {
+	return SyncState(atomic.LoadInt32(&s.state))
+}
+
+// Resyncs returns how many times the book had to be rebuilt
+// from a snapshot after a gap in the stream
+func (s *symbolBook) Resyncs() int64 {
+	return atomic.LoadInt64(&s.resyncs)
+}
+
+func (s *symbolBook) setState(state SyncState) {
+	atomic.StoreInt32(&s.state, int32(state))
+}
+
+// reset puts the book out of sync, it is rebuilt
+// from a new snapshot with the next event
+func (s *symbolBook) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setState(OutOfSync)
+	s.buffer = nil
+	s.prevu = -1
+	s.nextAttempt = time.Time{}
+	s.generation++
+}
+
+// run applies the stream events and the snapshots fetched
+// until the events channel is closed
+func (s *symbolBook) run() {
+	for {
+		select {
+		case v, ok := <-s.events:
+			if !ok {
+				// the snapshot being fetched goes to the buffered channel
+				s.fetches.Wait()
+				return
+			}
+			s.handle(v)
+		case f := <-s.snapshots:
+			s.mu.Lock()
+			s.rebuild(f)
+			s.mu.Unlock()
+		}
+	}
+}
+
+// handle applies an event to the book, or buffers it
+// and tries to resync when the book is out of sync
+func (s *symbolBook) handle(v *BinanceDepthEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.State() == Synced {
+		stale, ok := s.inSequence(v)
+		if stale {
+			return
+		}
+		if ok {
+			if err := s.apply(v); err != nil {
+				// prevu is not moved, the next event resyncs the book
+				s.log.Warn("dropping malformed depth event", "u", v.FinalUpdateID, "err", err)
+			}
+			return
+		}
+		s.log.Warn("Binance depth gap, resyncing",
+			"expected", s.prevu+1,
+			"got", v.FirstUpdateID,
+		)
+		metrics.DepthGaps.WithLabelValues(string(orderbook.Binance), string(s.symbol)).Inc()
+		s.setState(OutOfSync)
+	}
+
+	s.buffer = append(s.buffer, v)
+	if len(s.buffer) > maxBufferedEvents {
+		s.buffer = s.buffer[len(s.buffer)-maxBufferedEvents:]
+	}
+	s.resync()
+}
+
+// resync requests a snapshot to rebuild the book from, unless one
+// is being fetched or the last attempt was too recent
+func (s *symbolBook) resync() {
+	if s.fetching || s.now().Before(s.nextAttempt) {
+		return
+	}
+	s.fetching = true
+	if s.inline {
+		depth, err := s.snapshot(streamName(s.symbol))
+		s.rebuild(fetched{depth: depth, err: err, generation: s.generation})
+		return
+	}
+	s.fetches.Add(1)
+	go func(generation int) {
+		defer s.fetches.Done()
+		depth, err := s.snapshot(streamName(s.symbol))
+		s.snapshots <- fetched{depth: depth, err: err, generation: generation}
+	}(s.generation)
+}
+
+// rebuild rebuilds the book from a snapshot and replays the buffered
+// events. The book stays out of sync when the snapshot can't be used yet.
+func (s *symbolBook) rebuild(f fetched) {
+	s.fetching = false
+	if f.generation != s.generation {
+		// requested before a reconnection, the events since are newer
+		if len(s.buffer) > 0 {
+			s.resync()
+		}
+		return
+	}
+	depth, err := f.depth, f.err
+	if err != nil {
+		s.nextAttempt = s.now().Add(snapshotRetryInterval)
+		switch err := err.(type) {
+		case *rest.RateLimited:
+			if err.RetryAfter > snapshotRetryInterval {
+				s.nextAttempt = s.now().Add(err.RetryAfter)
+			}
+			s.log.Warn("binance depth rate limited", "retry_after", err.RetryAfter)
+		case *rest.InvalidSymbol:
+			s.log.Error("error fetching binance depth", "err", err)
+		default:
+			s.log.Warn("error fetching binance depth", "err", err)
+		}
+		return
+	}
+	// the snapshot must not be older than the first buffered event
+	if len(s.buffer) > 0 && depth.LastUpdateID+1 < s.buffer[0].FirstUpdateID {
+		s.nextAttempt = s.now().Add(snapshotRetryInterval)
+		return
+	}
+	bids, asks, err := levelsToOrders(s.symbol, depth.Bids, depth.Asks)
+	if err != nil {
+		s.nextAttempt = s.now().Add(snapshotRetryInterval)
+		s.log.Warn("malformed binance depth", "err", err)
+		return
+	}
+	s.nextAttempt = time.Time{}
+	if recorder.Enabled() {
+		recorder.Save(orderbook.Binance, s.symbol, recorder.Snapshot, depthResponse(depth))
+	}
+
+	s.book.Replace(bids, asks)
+	s.lastUpdateID = depth.LastUpdateID
+	s.prevu = -1
+	s.publish(orderbook.BookUpdate{
+		Exchange: orderbook.Binance,
+		Symbol:   s.symbol,
+		Bids:     bids,
+		Asks:     asks,
+		Snapshot: true,
+	})
+
+	buffer := s.buffer
+	s.buffer = nil
+	for i, v := range buffer {
+		stale, ok := s.inSequence(v)
+		if stale {
+			continue
+		}
+		if !ok {
+			// keep buffering from the gap, a newer snapshot is needed
+			s.buffer = buffer[i:]
+			s.nextAttempt = s.now().Add(snapshotRetryInterval)
+			return
+		}
+		if err := s.apply(v); err != nil {
+			// the dropped event is a gap as well
+			s.log.Warn("dropping malformed depth event", "u", v.FinalUpdateID, "err", err)
+			s.buffer = buffer[i+1:]
+			s.nextAttempt = s.now().Add(snapshotRetryInterval)
+			return
+		}
+	}
+
+	if s.synced {
+		atomic.AddInt64(&s.resyncs, 1)
+		metrics.Resyncs.WithLabelValues(string(orderbook.Binance), string(s.symbol)).Inc()
+	}
+	s.synced = true
+	s.setState(Synced)
+	s.log.Info("Binance Orderbook Initialized", "lastUpdateId", s.lastUpdateID)
+}
+
+// inSequence tells whether an event is older than the book,
+// or whether it is the next one to apply
+func (s *symbolBook) inSequence(v *BinanceDepthEvent) (stale, ok bool) {
+	// ignore events where u <= lastUpdateID
+	if v.FinalUpdateID <= s.lastUpdateID || v.FinalUpdateID <= s.prevu {
+		return true, false
+	}
+	// the first event must have U <= lastUpdateID+1 <= u
+	if s.prevu == -1 {
+		return false, v.FirstUpdateID <= s.lastUpdateID+1
+	}
+	return false, v.FirstUpdateID == s.prevu+1
+}
+
+// apply adds the bids and asks of an event to the book at once,
+// the book is left untouched when the event is malformed
+func (s *symbolBook) apply(v *BinanceDepthEvent) error {
+	bids, asks, err := levelsToOrders(s.symbol, v.Bids, v.Asks)
+	if err != nil {
+		return err
+	}
+	s.book.Apply(bids, asks)
+	s.prevu = v.FinalUpdateID
+	s.appliedLog.Debug("depth event applied", "U", v.FirstUpdateID, "u", v.FinalUpdateID, "bids", len(bids), "asks", len(asks))
+	s.publish(orderbook.BookUpdate{
+		Exchange: orderbook.Binance,
+		Symbol:   s.symbol,
+		Bids:     bids,
+		Asks:     asks,
+	})
+	return nil
+}
@@ -0,0 +1,265 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	binance "github.com/adshao/go-binance"
+	"github.com/anthonychristian/crypto-arbitrage/metrics"
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/rest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSnapshots serves the given snapshots in order, repeating the last one
+func fakeSnapshots(snapshots ...binance.DepthResponse) (func(string) (binance.DepthResponse, error), *int) {
+	calls := 0
+	return func(string) (binance.DepthResponse, error) {
+		s := snapshots[calls]
+		if calls < len(snapshots)-1 {
+			calls++
+		}
+		return s, nil
+	}, &calls
+}
+
+// handle hands an event to a book, and waits for
+// the snapshot it requested to rebuild the book
+func handle(sb *symbolBook, v *BinanceDepthEvent) {
+	sb.handle(v)
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if sb.fetching {
+		sb.rebuild(<-sb.snapshots)
+	}
+}
+
+func event(first, final int64, bidPrice, bidQty string) *BinanceDepthEvent {
+	return &BinanceDepthEvent{
+		FirstUpdateID: first,
+		FinalUpdateID: final,
+		Bids:          []binance.Bid{{Price: bidPrice, Quantity: bidQty}},
+	}
+}
+
+func TestSymbolBookSync(t *testing.T) {
+	book := orderbook.NewOrderBook()
+	sb := newSymbolBook(orderbook.BTC_USDC, book)
+	sb.snapshot, _ = fakeSnapshots(binance.DepthResponse{
+		LastUpdateID: 100,
+		Bids:         []binance.Bid{{Price: "10", Quantity: "1"}},
+		Asks:         []binance.Ask{{Price: "11", Quantity: "1"}},
+	})
+
+	// the first event straddles the snapshot
+	handle(sb, event(95, 102, "9", "2"))
+	assert.Equal(t, Synced, sb.State())
+	assert.Equal(t, int64(102), sb.prevu)
+	assert.Equal(t, "10", book.TopPriceBuySide().Price.String())
+	assert.Equal(t, "9", book.LowPriceBuySide().Price.String())
+
+	handle(sb, event(103, 105, "10", "0"))
+	assert.Equal(t, "9", book.TopPriceBuySide().Price.String())
+	assert.Equal(t, int64(0), sb.Resyncs())
+}
+
+func TestSymbolBookGapResync(t *testing.T) {
+	book := orderbook.NewOrderBook()
+	sb := newSymbolBook(orderbook.BTC_USDC, book)
+	snapshots, calls := fakeSnapshots(
+		binance.DepthResponse{
+			LastUpdateID: 100,
+			Bids:         []binance.Bid{{Price: "10", Quantity: "1"}},
+			Asks:         []binance.Ask{{Price: "11", Quantity: "1"}},
+		},
+		binance.DepthResponse{
+			LastUpdateID: 205,
+			Bids:         []binance.Bid{{Price: "12", Quantity: "3"}},
+			Asks:         []binance.Ask{{Price: "13", Quantity: "1"}},
+		},
+	)
+	sb.snapshot = snapshots
+	gaps := metrics.DepthGaps.WithLabelValues("Binance", "BTC/USDC")
+	resyncs := metrics.Resyncs.WithLabelValues("Binance", "BTC/USDC")
+	gapsBefore, resyncsBefore := testutil.ToFloat64(gaps), testutil.ToFloat64(resyncs)
+
+	handle(sb, event(101, 110, "10.5", "1"))
+	assert.Equal(t, Synced, sb.State())
+	assert.Equal(t, "10.5", book.TopPriceBuySide().Price.String())
+
+	// events 111 to 199 are lost, 200-210 is buffered and replayed
+	// on top of the second snapshot
+	handle(sb, event(200, 210, "12.5", "1"))
+	assert.Equal(t, 1, *calls)
+	assert.Equal(t, Synced, sb.State())
+	assert.Equal(t, int64(1), sb.Resyncs())
+	assert.Equal(t, gapsBefore+1, testutil.ToFloat64(gaps))
+	assert.Equal(t, resyncsBefore+1, testutil.ToFloat64(resyncs))
+	assert.Equal(t, int64(210), sb.prevu)
+	assert.Equal(t, "12.5", book.TopPriceBuySide().Price.String())
+	assert.Equal(t, "12", book.LowPriceBuySide().Price.String())
+
+	handle(sb, event(211, 212, "12.5", "0"))
+	assert.Equal(t, "12", book.TopPriceBuySide().Price.String())
+}
+
+func TestSymbolBookDropsMalformedEvent(t *testing.T) {
+	book := orderbook.NewOrderBook()
+	sb := newSymbolBook(orderbook.BTC_USDC, book)
+	snapshots, calls := fakeSnapshots(
+		binance.DepthResponse{LastUpdateID: 100, Bids: []binance.Bid{{Price: "10", Quantity: "1"}}},
+		binance.DepthResponse{LastUpdateID: 103, Bids: []binance.Bid{{Price: "10", Quantity: "2"}}},
+	)
+	sb.snapshot = snapshots
+	handle(sb, event(101, 101, "9", "1"))
+	assert.Equal(t, int64(101), sb.prevu)
+
+	// the book is left untouched, and rebuilt with the next event
+	handle(sb, event(102, 102, "9", "1e"))
+	assert.Equal(t, int64(101), sb.prevu)
+	assert.Equal(t, "1", book.TopPriceBuySide().Qty.String())
+	handle(sb, event(103, 104, "9", "3"))
+	assert.Equal(t, 1, *calls)
+	assert.Equal(t, Synced, sb.State())
+	assert.Equal(t, int64(104), sb.prevu)
+	assert.Equal(t, "2", book.TopPriceBuySide().Qty.String())
+	assert.Equal(t, "3", book.LowPriceBuySide().Qty.String())
+
+	_, err := toOrder(orderbook.BTC_USDC, "9", "1e")
+	assert.EqualError(t, err, "quantity of level [9 1e]: can't convert 1e to decimal: exponent is not numeric")
+}
+
+func TestSymbolBookWaitsForNewerSnapshot(t *testing.T) {
+	book := orderbook.NewOrderBook()
+	sb := newSymbolBook(orderbook.BTC_USDC, book)
+	sb.snapshot, _ = fakeSnapshots(binance.DepthResponse{LastUpdateID: 50})
+
+	// the snapshot is older than the buffered event
+	handle(sb, event(101, 110, "10.5", "1"))
+	assert.Equal(t, OutOfSync, sb.State())
+	assert.Len(t, sb.buffer, 1)
+}
+
+func TestSymbolBookRateLimited(t *testing.T) {
+	now := time.Date(2020, 1, 6, 10, 0, 0, 0, time.UTC)
+	sb := newSymbolBook(orderbook.BTC_USDC, orderbook.NewOrderBook())
+	sb.now = func() time.Time { return now }
+	calls := 0
+	sb.snapshot = func(string) (binance.DepthResponse, error) {
+		calls++
+		return binance.DepthResponse{}, &rest.RateLimited{Exchange: orderbook.Binance, Endpoint: "depth", RetryAfter: time.Minute}
+	}
+
+	handle(sb, event(101, 110, "10.5", "1"))
+	assert.Equal(t, 1, calls)
+	// no snapshot is requested until binance said to
+	now = now.Add(30 * time.Second)
+	handle(sb, event(111, 112, "10.5", "2"))
+	assert.Equal(t, 1, calls)
+	now = now.Add(30 * time.Second)
+	handle(sb, event(113, 114, "10.5", "3"))
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, OutOfSync, sb.State())
+}
+
+func TestDepthHandlerSyncsSymbolsIndependently(t *testing.T) {
+	btc := newSymbolBook(orderbook.BTC_USDC, orderbook.NewOrderBook())
+	btc.snapshot, _ = fakeSnapshots(binance.DepthResponse{LastUpdateID: 100})
+	eth := newSymbolBook(orderbook.ETH_USDT, orderbook.NewOrderBook())
+	eth.snapshot, _ = fakeSnapshots(binance.DepthResponse{LastUpdateID: 5000})
+	handler := depthHandler(map[string]*symbolBook{
+		"BTCUSDC": btc,
+		"ETHUSDT": eth,
+	})
+
+	handler(&binance.WsDepthEvent{Symbol: "BTCUSDC", FirstUpdateID: 90, UpdateID: 101})
+	handler(&binance.WsDepthEvent{Symbol: "ETHUSDT", FirstUpdateID: 6000, UpdateID: 6001})
+	handler(&binance.WsDepthEvent{Symbol: "LTCUSDT", FirstUpdateID: 1, UpdateID: 2})
+	handle(btc, <-btc.events)
+	handle(eth, <-eth.events)
+
+	assert.Equal(t, Synced, btc.State())
+	assert.Equal(t, OutOfSync, eth.State())
+	assert.Empty(t, btc.events)
+	assert.Empty(t, eth.events)
+}
+
+func TestSymbolBookFetchesSnapshotAsync(t *testing.T) {
+	book := orderbook.NewOrderBook()
+	sb := newSymbolBook(orderbook.BTC_USDC, book)
+	release := make(chan struct{})
+	calls := 0
+	sb.snapshot = func(string) (binance.DepthResponse, error) {
+		calls++
+		<-release
+		return binance.DepthResponse{LastUpdateID: 100, Bids: []binance.Bid{{Price: "10", Quantity: "1"}}}, nil
+	}
+
+	// the events keep being buffered while the snapshot is fetched
+	sb.handle(event(95, 101, "9", "1"))
+	sb.handle(event(102, 103, "9", "2"))
+	assert.Equal(t, OutOfSync, sb.State())
+	assert.Len(t, sb.buffer, 2)
+
+	close(release)
+	sb.mu.Lock()
+	sb.rebuild(<-sb.snapshots)
+	sb.mu.Unlock()
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, Synced, sb.State())
+	assert.Equal(t, int64(103), sb.prevu)
+	assert.Equal(t, "2", book.LowPriceBuySide().Qty.String())
+}
+
+func TestSymbolBookDropsSnapshotAfterReset(t *testing.T) {
+	sb := newSymbolBook(orderbook.BTC_USDC, orderbook.NewOrderBook())
+	snapshots, calls := fakeSnapshots(
+		binance.DepthResponse{LastUpdateID: 100},
+		binance.DepthResponse{LastUpdateID: 300},
+	)
+	sb.snapshot = snapshots
+
+	sb.handle(event(95, 101, "9", "1"))
+	// the stream reconnects while the snapshot is fetched
+	sb.reset()
+	sb.handle(event(290, 301, "9", "2"))
+	sb.mu.Lock()
+	sb.rebuild(<-sb.snapshots)
+	sb.mu.Unlock()
+	assert.Equal(t, OutOfSync, sb.State())
+	assert.Equal(t, 1, *calls)
+
+	// the snapshot requested again is applied
+	sb.mu.Lock()
+	sb.rebuild(<-sb.snapshots)
+	sb.mu.Unlock()
+	assert.Equal(t, Synced, sb.State())
+	assert.Equal(t, int64(301), sb.prevu)
+}
+
+func TestDepthHandlerNeverBlocks(t *testing.T) {
+	btc := newSymbolBook(orderbook.BTC_USDC, orderbook.NewOrderBook())
+	eth := newSymbolBook(orderbook.ETH_USDT, orderbook.NewOrderBook())
+	handler := depthHandler(map[string]*symbolBook{
+		"BTCUSDC": btc,
+		"ETHUSDT": eth,
+	})
+	for i := 0; i < cap(eth.events); i++ {
+		eth.events <- event(int64(i), int64(i), "9", "1")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler(&binance.WsDepthEvent{Symbol: "ETHUSDT", FirstUpdateID: 5000, UpdateID: 5001})
+		handler(&binance.WsDepthEvent{Symbol: "BTCUSDC", FirstUpdateID: 90, UpdateID: 101})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the handler blocked on a full queue")
+	}
+	assert.Len(t, eth.events, cap(eth.events))
+	assert.Len(t, btc.events, 1)
+}
@@ -0,0 +1,163 @@
+package websocket
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orders"
+	"github.com/shopspring/decimal"
+)
+
+// ExecutionPollInterval is how often the live orders are polled
+var ExecutionPollInterval = 2 * time.Second
+
+var _ orders.Executor = (*BinanceAdapter)(nil)
+
+// liveOrder is an order sent through the REST API
+// and what was already reported of its executions
+type liveOrder struct {
+	order    orders.Order
+	symbol   string          // Name of the symbol on binance
+	executed decimal.Decimal // Quantity filled
+	quote    decimal.Decimal // Quote spent or received by the fills
+}
+
+// timesInForce maps the times in force binance supports
+var timesInForce = map[orders.TimeInForce]string{
+	orders.GTC: "GTC",
+	orders.IOC: "IOC",
+	orders.FOK: "FOK",
+}
+
+// Submit places an order with the REST API, after applying the filters
+// of its symbol. Stop orders and day orders are refused.
+func (b *BinanceAdapter) Submit(o orders.Order) (string, error) {
+	r := BinanceOrderRequest{
+		Symbol:        streamName(o.Symbol),
+		Side:          strings.ToUpper(string(o.Side)),
+		Qty:           o.Qty,
+		ClientOrderID: o.ClientOrderID,
+	}
+	switch o.Type {
+	case orders.Limit:
+		tif, ok := timesInForce[o.TimeInForce]
+		if !ok {
+			return "", fmt.Errorf("binance does not support %v limit orders", o.TimeInForce)
+		}
+		r.Type, r.TimeInForce, r.Price = "LIMIT", tif, *o.LimitPrice
+	case orders.Market:
+		r.Type = "MARKET"
+	default:
+		return "", fmt.Errorf("binance does not support %v orders", o.Type)
+	}
+
+	order, err := b.client.CreateOrder(r)
+	if err != nil {
+		return "", err
+	}
+	// market and IOC orders are done by the time they are placed
+	l := &liveOrder{order: o, symbol: r.Symbol}
+	if !b.apply(l, order) {
+		b.mu.Lock()
+		b.live[o.ClientOrderID] = l
+		b.mu.Unlock()
+	}
+	return strconv.FormatInt(order.OrderID, 10), nil
+}
+
+// Cancel asks binance to cancel an order, its cancellation
+// is reported once polled
+func (b *BinanceAdapter) Cancel(o orders.Order) error {
+	_, err := b.client.CancelOrder(streamName(o.Symbol), o.ClientOrderID)
+	return err
+}
+
+// Executions reports the fills of the orders sent by Submit,
+// and their cancellation
+func (b *BinanceAdapter) Executions() <-chan orders.Execution {
+	return b.executions
+}
+
+// track polls the live orders every ExecutionPollInterval
+func (b *BinanceAdapter) track() {
+	defer b.tracking.Done()
+	ticker := time.NewTicker(ExecutionPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.pollExecutions()
+		case <-b.quit:
+			return
+		}
+	}
+}
+
+// pollExecutions reports what changed in every live order
+func (b *BinanceAdapter) pollExecutions() {
+	b.mu.RLock()
+	live := make([]*liveOrder, 0, len(b.live))
+	for _, l := range b.live {
+		live = append(live, l)
+	}
+	b.mu.RUnlock()
+
+	for _, l := range live {
+		order, err := b.client.GetOrder(l.symbol, l.order.ClientOrderID)
+		if err != nil {
+			log.Warn("order poll failed", "client_order_id", l.order.ClientOrderID, "err", err)
+			continue
+		}
+		if b.apply(l, order) {
+			b.mu.Lock()
+			delete(b.live, l.order.ClientOrderID)
+			b.mu.Unlock()
+		}
+	}
+}
+
+// apply reports the fills of an order since it was last seen, and its
+// cancellation. It tells whether the order is done.
+func (b *BinanceAdapter) apply(l *liveOrder, order BinanceOrder) bool {
+	if qty := order.ExecutedQty.Sub(l.executed); qty.IsPositive() {
+		b.report(orders.Execution{
+			ClientOrderID: l.order.ClientOrderID,
+			Qty:           qty,
+			Price:         order.CummulativeQuoteQty.Sub(l.quote).Div(qty),
+			Time:          time.Now(),
+		})
+		l.executed, l.quote = order.ExecutedQty, order.CummulativeQuoteQty
+	}
+
+	var reason string
+	switch order.Status {
+	case "FILLED":
+		if !l.executed.LessThan(l.order.Qty) {
+			return true
+		}
+		// the quantity was floored to the step size
+		reason = "quantity rounded to the lot size"
+	case "CANCELED":
+	case "EXPIRED", "REJECTED":
+		reason = strings.ToLower(order.Status)
+	default:
+		return false
+	}
+	b.report(orders.Execution{
+		ClientOrderID: l.order.ClientOrderID,
+		Status:        orders.Cancelled,
+		Reason:        reason,
+		Time:          time.Now(),
+	})
+	return true
+}
+
+// report sends an execution unless the adapter is closing
+func (b *BinanceAdapter) report(e orders.Execution) {
+	select {
+	case b.executions <- e:
+	case <-b.quit:
+	}
+}
@@ -0,0 +1,82 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anthonychristian/crypto-arbitrage/orderbook"
+	"github.com/anthonychristian/crypto-arbitrage/orders"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecutor(t *testing.T) {
+	mock, server := newMockBinance(t, 0)
+	defer server.Close()
+	interval := ExecutionPollInterval
+	ExecutionPollInterval = time.Hour
+	defer func() { ExecutionPollInterval = interval }()
+
+	b := NewBinanceAdapter()
+	b.client = newTestClient(server)
+	assert.Nil(t, b.Connect())
+
+	price := d("4000")
+	o := orders.Order{Request: orders.Request{
+		ClientOrderID: "a",
+		Symbol:        orderbook.BTC_USDC,
+		Exchange:      orderbook.Binance,
+		Qty:           d("0.01"),
+		Side:          orders.Buy,
+		Type:          orders.Limit,
+		TimeInForce:   orders.IOC,
+		LimitPrice:    &price,
+	}}
+	id, err := b.Submit(o)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", id)
+	sent := mock.requests[len(mock.requests)-1].URL.Query()
+	assert.Equal(t, "BTCUSDC", sent.Get("symbol"))
+	assert.Equal(t, "BUY", sent.Get("side"))
+	assert.Equal(t, "IOC", sent.Get("timeInForce"))
+	assert.Equal(t, "a", sent.Get("newClientOrderId"))
+	assert.Empty(t, b.executions)
+
+	fill := func(executed, quote string) {
+		mock.mu.Lock()
+		defer mock.mu.Unlock()
+		order := mock.orders["a"]
+		order.ExecutedQty, order.CummulativeQuoteQty = d(executed), d(quote)
+		order.Status = "PARTIALLY_FILLED"
+		mock.orders["a"] = order
+	}
+	fill("0.004", "16")
+	b.pollExecutions()
+	fill("0.006", "24.02")
+	b.pollExecutions()
+	b.pollExecutions()
+	assert.Len(t, b.executions, 2)
+	first, second := <-b.Executions(), <-b.Executions()
+	assert.Equal(t, "0.004", first.Qty.String())
+	assert.Equal(t, "4000", first.Price.String())
+	assert.Equal(t, "0.002", second.Qty.String())
+	assert.Equal(t, "4010", second.Price.String())
+
+	assert.Nil(t, b.Cancel(o))
+	b.pollExecutions()
+	cancelled := <-b.Executions()
+	assert.Equal(t, "a", cancelled.ClientOrderID)
+	assert.Equal(t, orders.Cancelled, cancelled.Status)
+	assert.Empty(t, b.live)
+
+	// orders binance doesn't have
+	o.ClientOrderID, o.TimeInForce = "b", orders.Day
+	_, err = b.Submit(o)
+	assert.EqualError(t, err, "binance does not support day limit orders")
+	o.Type, o.StopPrice = orders.StopLimit, &price
+	_, err = b.Submit(o)
+	assert.EqualError(t, err, "binance does not support stop_limit orders")
+
+	assert.Nil(t, b.Close())
+	_, open := <-b.Executions()
+	assert.False(t, open)
+}